@@ -5,24 +5,117 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	htmltemplate "html/template"
+	"io"
 	"log"
+	"maps"
+	"math/rand/v2"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/user"
 	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
-	ErrTaskAlreadyExists       = errors.New("task already exists")
-	ErrTaskDoesNotExist        = errors.New("task does not exist")
-	ErrOnlyOneArgumentAllowed  = errors.New("only one argument is allowed")
-	ErrOnlyTwoArgumentsAllowed = errors.New("only two arguments are allowed")
-	ErrInvalidTaskStatus       = errors.New("invalid task status")
+	ErrTaskAlreadyExists         = errors.New("task already exists")
+	ErrTaskDoesNotExist          = errors.New("task does not exist")
+	ErrNoArgumentsAllowed        = errors.New("no arguments are allowed")
+	ErrOnlyOneArgumentAllowed    = errors.New("only one argument is allowed")
+	ErrOnlyTwoArgumentsAllowed   = errors.New("only two arguments are allowed")
+	ErrInvalidTaskStatus         = errors.New("invalid task status")
+	ErrNoExportFormat            = errors.New("no export format given")
+	ErrResetIdsWithoutAll        = errors.New("--reset-ids can only be used with --all")
+	ErrOptionNeedsValue          = errors.New("option needs a value")
+	ErrInternal                  = errors.New("internal error")
+	ErrEmptyDescription          = errors.New("task description is empty")
+	ErrInvalidMergeStrategy      = errors.New("invalid merge strategy")
+	ErrUnknownImportFormat       = errors.New("unknown import format")
+	ErrMissingDescriptionColumn  = errors.New("missing description column")
+	ErrInvalidSortKey            = errors.New("invalid sort key")
+	ErrInvalidColorSetting       = errors.New("invalid color setting, expected auto, always or never")
+	ErrEmptyTimeFormat           = errors.New("time format is empty")
+	ErrUnknownConfigKey          = errors.New("unknown config key")
+	ErrInvalidConfigLine         = errors.New("expected a key = value line")
+	ErrUnknownRenderer           = errors.New("unknown renderer")
+	ErrInvalidJournalEntry       = errors.New("invalid journal entry")
+	ErrStoreNotEmpty             = errors.New("store is not empty, use --force to replace its tasks")
+	ErrInvalidTaskColor          = errors.New("invalid task color, expected red, green, yellow, blue, magenta, cyan, white or none")
+	ErrInvalidOffset             = errors.New("offset must not be negative")
+	ErrInvalidDuration           = errors.New("invalid duration, expected something like 7d, 2w or 1d12h")
+	ErrArchiveNeedsBefore        = errors.New("archive needs a task id, --before DATE or --done-older-than DURATION")
+	ErrInvalidDays               = errors.New("number of days must be positive")
+	ErrUnknownConfigAction       = errors.New("unknown config action, expected get, set or list")
+	ErrInvalidTag                = errors.New("invalid tag, expected a word without spaces or commas")
+	ErrInvalidLimit              = errors.New("limit must not be negative, use 0 for no limit")
+	ErrInvalidStore              = errors.New("invalid task store")
+	ErrNoEditFormat              = errors.New("no edit format given, use --json")
+	ErrInvalidDescWidth          = errors.New("invalid description width, expected a number of characters or 0 for no limit")
+	ErrInvalidBackup             = errors.New("invalid backup archive")
+	ErrConflictingFlags          = errors.New("conflicting output flags")
+	ErrStoreHasIssues            = errors.New("store has issues")
+	ErrNoTasksFound              = errors.New("no tasks found")
+	ErrSeedNonEmpty              = errors.New("store is not empty, use --force to seed it anyway")
+	ErrInvertedTimestamps        = errors.New("updated_at is before created_at")
+	ErrInvalidPriority           = errors.New("invalid task priority, expected low, medium, high or urgent")
+	ErrTemplateOutput            = errors.New("--template needs the output file as its only argument")
+	ErrNotTerminal               = errors.New("ui needs a terminal for its input and output")
+	ErrMissingTags               = errors.New("a task id and at least one tag are required")
+	ErrUnknownProjectAction      = errors.New("unknown project action, expected list, rename or delete")
+	ErrInvalidProject            = errors.New("invalid project, expected a word without spaces or commas")
+	ErrProjectNotFound           = errors.New("no task is in this project")
+	ErrInvalidChildrenAction     = errors.New("invalid children action, expected orphan or delete")
+	ErrDependencyCycle           = errors.New("dependency would create a cycle")
+	ErrUnknownNoteAction         = errors.New("unknown note action, expected add or list")
+	ErrEmptyNote                 = errors.New("note cannot be empty")
+	ErrInvalidRecurrence         = errors.New("invalid recurrence, expected a count and d, w, m or y like 3d, or an RRULE with FREQ and INTERVAL")
+	ErrInvalidRemindTime         = errors.New("invalid reminder time, expected a duration like 30m, a time like 15:04 or a date and time like 2006-01-02 15:04")
+	ErrAlreadyTracking           = errors.New("time is already being tracked on this task")
+	ErrNotTracking               = errors.New("time is not being tracked on this task")
+	ErrUnknownTimeAction         = errors.New("unknown time action, expected a task id or report")
+	ErrUnknownReportAction       = errors.New("unknown report, expected workload")
+	ErrEmptyAssignee             = errors.New("assignee cannot be empty, use --clear to unassign")
+	ErrInvalidFieldType          = errors.New("invalid custom field type, expected string, int or date")
+	ErrUnknownField              = errors.New("unknown custom field, declare it with field.<name> = <type> in the config")
+	ErrInvalidFieldValue         = errors.New("invalid custom field value")
+	ErrInvalidFieldAssignment    = errors.New("invalid custom field assignment, expected field=value")
+	ErrNoAttachments             = errors.New("task has no attachments")
+	ErrNotRegularFile            = errors.New("only regular files can be attached")
+	ErrUnknownCheckAction        = errors.New("unknown check action, expected add, done or undo")
+	ErrInvalidCheckItem          = errors.New("checklist item does not exist")
+	ErrArchiveConflict           = errors.New("archive takes only one of a task id, --before or --done-older-than")
+	ErrUnknownTemplateAction     = errors.New("unknown template action, expected save, list or delete")
+	ErrTemplateNotFound          = errors.New("template does not exist")
+	ErrInvalidTemplateName       = errors.New("invalid template name, expected a word without spaces or commas")
+	ErrMissingAssignments        = errors.New("a task id and at least one field=value assignment are required")
+	ErrMissingCheckItem          = errors.New("a task id and an item, its text for add or its number for done and undo, are required")
+	ErrArchivedIdTaken           = errors.New("an archived task already has this id")
+	ErrIncludeArchivedWithoutAll = errors.New("--include-archived can only be used with --all")
+	ErrStoreFrozen               = errors.New("store is frozen, run unfreeze to allow changes")
 )
 
 type TaskStatus uint8
@@ -59,24 +152,514 @@ func (status TaskStatus) Valid() bool {
 	return ok
 }
 
+func nextStatus(status TaskStatus) (TaskStatus, bool) {
+	if status >= TaskStatusDone {
+		return status, false
+	}
+	return status + 1, true
+}
+
+func prevStatus(status TaskStatus) (TaskStatus, bool) {
+	if status <= TaskStatusTodo {
+		return status, false
+	}
+	return status - 1, true
+}
+
+// TaskPriority orders tasks by importance. The zero value is the default
+// medium priority, so tasks saved without a priority have it.
+type TaskPriority int8
+
+const (
+	TaskPriorityLow TaskPriority = iota - 1
+	TaskPriorityMedium
+	TaskPriorityHigh
+	TaskPriorityUrgent
+)
+
+var taskPriorityMapFromString = map[string]TaskPriority{
+	"low":    TaskPriorityLow,
+	"medium": TaskPriorityMedium,
+	"high":   TaskPriorityHigh,
+	"urgent": TaskPriorityUrgent,
+}
+
+var taskPriorityMapToString = map[TaskPriority]string{
+	TaskPriorityLow:    "low",
+	TaskPriorityMedium: "medium",
+	TaskPriorityHigh:   "high",
+	TaskPriorityUrgent: "urgent",
+}
+
+// NewTaskPriority returns the priority called str. Unlike statuses, the
+// zero priority is valid, so ok tells whether there is one.
+func NewTaskPriority(str string) (priority TaskPriority, ok bool) {
+	priority, ok = taskPriorityMapFromString[str]
+	return
+}
+
+func (priority TaskPriority) String() string {
+	return taskPriorityMapToString[priority]
+}
+
+func (priority TaskPriority) Valid() bool {
+	_, ok := taskPriorityMapToString[priority]
+	return ok
+}
+
+// MarshalJSON stores priorities by name, so the store file stays readable
+// and does not depend on the values of the constants.
+func (priority TaskPriority) MarshalJSON() ([]byte, error) {
+	if !priority.Valid() {
+		return nil, ErrInvalidPriority
+	}
+	return json.Marshal(priority.String())
+}
+
+// UnmarshalJSON reads a priority by name, or by number as the first
+// versions with priorities stored them.
+func (priority *TaskPriority) UnmarshalJSON(data []byte) (err error) {
+	var name string
+	if json.Unmarshal(data, &name) != nil {
+		var number int8
+		if err = json.Unmarshal(data, &number); err != nil {
+			return
+		}
+		name = TaskPriority(number).String()
+	}
+
+	var ok bool
+	if *priority, ok = NewTaskPriority(name); !ok {
+		err = fmt.Errorf("%w: %s", ErrInvalidPriority, data)
+	}
+	return
+}
+
+func nextPriority(priority TaskPriority) (TaskPriority, bool) {
+	if priority >= TaskPriorityUrgent {
+		return priority, false
+	}
+	return priority + 1, true
+}
+
+func prevPriority(priority TaskPriority) (TaskPriority, bool) {
+	if priority <= TaskPriorityLow {
+		return priority, false
+	}
+	return priority - 1, true
+}
+
+const defaultAppName = "task"
+
+// appName returns the name of the directory holding the application data,
+// which can be overridden with the TASK_APP_NAME environment variable.
+func appName() string {
+	if name := os.Getenv("TASK_APP_NAME"); name != "" {
+		return name
+	}
+	return defaultAppName
+}
+
 type TaskId uint64
 
+type StatusChange struct {
+	Status TaskStatus `json:"status"`
+	At     time.Time  `json:"at"`
+	Note   string     `json:"note,omitempty"`
+}
+
+// Note is a free form, possibly multi-line, text attached to a task.
+type Note struct {
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session is an interval of work on a task, without end while it is still
+// being tracked.
+type Session struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// Attachment is a URL or a file attached to a task, the file being copied
+// into the blob directory of the store.
+type Attachment struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url,omitempty"`
+	Blob    string    `json:"blob,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// FieldChange is a change of a field of a task, its values before and
+// after being kept as JSON, null when the field was unset.
+type FieldChange struct {
+	At     time.Time       `json:"at"`
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// ChecklistItem is a step of the checklist of a task.
+type ChecklistItem struct {
+	Text string `json:"text"`
+	Done bool   `json:"done,omitempty"`
+}
+
 type Task struct {
-	Id          TaskId     `json:"id"`
-	Description string     `json:"description"`
-	Status      TaskStatus `json:"status"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	Id            TaskId          `json:"id"`
+	Description   string          `json:"description"`
+	Status        TaskStatus      `json:"status"`
+	StatusHistory []StatusChange  `json:"status_history"`
+	Color         string          `json:"color,omitempty"`
+	ParentId      TaskId          `json:"parent_id,omitempty"`
+	DueAt         *time.Time      `json:"due_at,omitempty"`
+	Tags          []string        `json:"tags,omitempty"`
+	Assignee      string          `json:"assignee,omitempty"`
+	Pinned        bool            `json:"pinned,omitempty"`
+	Priority      TaskPriority    `json:"priority,omitempty"`
+	Project       string          `json:"project,omitempty"`
+	DependsOn     []TaskId        `json:"depends_on,omitempty"`
+	Notes         []Note          `json:"notes,omitempty"`
+	Recurrence    string          `json:"recurrence,omitempty"`
+	RemindAt      *time.Time      `json:"remind_at,omitempty"`
+	Sessions      []Session       `json:"sessions,omitempty"`
+	Estimate      time.Duration   `json:"estimate,omitempty"`
+	Custom        map[string]any  `json:"custom,omitempty"`
+	Attachments   []Attachment    `json:"attachments,omitempty"`
+	Checklist     []ChecklistItem `json:"checklist,omitempty"`
+	WaitUntil     *time.Time      `json:"wait_until,omitempty"`
+	CompletedAt   *time.Time      `json:"completed_at,omitempty"`
+	History       []FieldChange   `json:"history,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+
+	// extra holds the fields unknown to this version, written back as is
+	// so saving does not lose what a newer version stored.
+	extra map[string]json.RawMessage
+}
+
+func (task *Task) UnmarshalJSON(data []byte) (err error) {
+	type plainTask Task
+	if err = json.Unmarshal(data, (*plainTask)(task)); err != nil {
+		return
+	}
+
+	task.extra, err = unknownFields(data, reflect.TypeFor[Task]())
+	return
+}
+
+func (task Task) MarshalJSON() ([]byte, error) {
+	type plainTask Task
+	return marshalWithExtra(plainTask(task), task.extra)
+}
+
+// unknownFields returns the fields of the JSON object data that are not
+// fields of the struct type t.
+func unknownFields(data []byte, t reflect.Type) (extra map[string]json.RawMessage, err error) {
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return
+	}
+
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		delete(fields, name)
+	}
+
+	if len(fields) > 0 {
+		extra = fields
+	}
+	return
+}
+
+// marshalWithExtra encodes v, which must encode as an object, adding the
+// extra fields it does not have itself.
+func marshalWithExtra(v any, extra map[string]json.RawMessage) (data []byte, err error) {
+	if data, err = json.Marshal(v); err != nil || len(extra) == 0 {
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return
+	}
+
+	for name, value := range extra {
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// SetStatus changes the task status, recording the transition and an
+// optional note telling why in its status history. Setting the current
+// status again is not a transition. The completion time is set when the
+// task becomes done and cleared when it is reopened.
+func (task *Task) SetStatus(status TaskStatus, at time.Time, note string) {
+	if task.Status == status {
+		return
+	}
+
+	task.Status = status
+	task.StatusHistory = append(task.StatusHistory, StatusChange{Status: status, At: at, Note: note})
+
+	task.CompletedAt = nil
+	if status == TaskStatusDone {
+		task.CompletedAt = &at
+	}
+}
+
+// Overdue tells whether the task is not done and its due date is a day
+// before now, a task being due until the end of its due date.
+func (task Task) Overdue(now time.Time) bool {
+	if task.DueAt == nil || task.Status == TaskStatusDone {
+		return false
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return task.DueAt.Before(today)
+}
+
+// Waiting tells whether the task is not done and hidden from the listing
+// until its wait date, which is still to come.
+func (task Task) Waiting(now time.Time) bool {
+	return task.WaitUntil != nil && task.Status != TaskStatusDone && now.Before(*task.WaitUntil)
+}
+
+// Ready tells whether the task is not done and every task it depends on in
+// store is, dependencies on deleted tasks being no longer waited for.
+func (task Task) Ready(store *TaskStore) bool {
+	return task.Status != TaskStatusDone && !task.Blocked(store)
+}
+
+// Blocked tells whether a task the task depends on in store is not done.
+func (task Task) Blocked(store *TaskStore) bool {
+	return slices.ContainsFunc(task.DependsOn, func(id TaskId) bool {
+		dependency, err := store.GetById(id)
+		return err == nil && dependency.Status != TaskStatusDone
+	})
+}
+
+// normalizeIds returns a sorted copy of ids without duplicates.
+func normalizeIds(ids []TaskId) []TaskId {
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// Tracking tells whether time is being tracked on the task, that is
+// whether its last session has no end yet.
+func (task Task) Tracking() bool {
+	return len(task.Sessions) != 0 && task.Sessions[len(task.Sessions)-1].End == nil
+}
+
+// TimeSpent returns the time worked on the task between from and to, a
+// zero from or to leaving that side open. A session still being tracked
+// counts up to now.
+func (task Task) TimeSpent(from, to, now time.Time) (spent time.Duration) {
+	for _, session := range task.Sessions {
+		start, end := session.Start, now
+		if session.End != nil {
+			end = *session.End
+		}
+		if !from.IsZero() && start.Before(from) {
+			start = from
+		}
+		if !to.IsZero() && end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			spent += end.Sub(start)
+		}
+	}
+	return
+}
+
+// Remaining returns the estimated work left on the task, its estimate less
+// the time already spent, or nothing once it is done.
+func (task Task) Remaining(now time.Time) time.Duration {
+	if task.Status == TaskStatusDone {
+		return 0
+	}
+	return max(0, task.Estimate-task.TimeSpent(time.Time{}, time.Time{}, now))
+}
+
+// checklistProgress returns how far the checklist of the task is, like
+// [2/5], or nothing when it has none.
+func (task Task) checklistProgress() string {
+	if len(task.Checklist) == 0 {
+		return ""
+	}
+	done := 0
+	for _, item := range task.Checklist {
+		if item.Done {
+			done++
+		}
+	}
+	return fmt.Sprintf("[%d/%d]", done, len(task.Checklist))
+}
+
+// formatSpent writes a time spent as hours and minutes, like 1h05m.
+func formatSpent(spent time.Duration) string {
+	minutes := int(spent / time.Minute)
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+}
+
+// parseDueDate parses a due date given on the command line as YYYY-MM-DD,
+// in the local time zone.
+func parseDueDate(value string) (dueAt *time.Time, err error) {
+	var t time.Time
+	if t, err = time.ParseInLocation(time.DateOnly, value, time.Local); err != nil {
+		return
+	}
+	return &t, nil
+}
+
+// Recurrence tells how often a recurring task comes back, as a number of
+// days, weeks, months or years.
+type Recurrence struct {
+	Interval int
+	Unit     byte
+}
+
+var recurrenceFreqs = map[string]byte{
+	"DAILY":   'd',
+	"WEEKLY":  'w',
+	"MONTHLY": 'm',
+	"YEARLY":  'y',
+}
+
+// parseRecurrence parses a recurrence given either as a count and a unit,
+// like 3d or 2w, or as an RRULE made of FREQ and an optional INTERVAL,
+// like FREQ=WEEKLY;INTERVAL=2.
+func parseRecurrence(value string) (recurrence Recurrence, err error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	recurrence.Interval = 1
+
+	if strings.Contains(value, "=") {
+		for _, part := range strings.Split(value, ";") {
+			key, val, _ := strings.Cut(part, "=")
+			switch strings.ToUpper(key) {
+			case "FREQ":
+				recurrence.Unit = recurrenceFreqs[strings.ToUpper(val)]
+			case "INTERVAL":
+				if recurrence.Interval, err = strconv.Atoi(val); err != nil {
+					err = ErrInvalidRecurrence
+					return
+				}
+			default:
+				err = ErrInvalidRecurrence
+				return
+			}
+		}
+	} else if len(value) >= 2 {
+		recurrence.Unit = value[len(value)-1]
+		if recurrence.Interval, err = strconv.Atoi(value[:len(value)-1]); err != nil {
+			err = ErrInvalidRecurrence
+			return
+		}
+	}
+
+	if recurrence.Interval < 1 || !strings.ContainsRune("dwmy", rune(recurrence.Unit)) {
+		err = ErrInvalidRecurrence
+	}
+	return
+}
+
+// String returns the recurrence in the short form parseRecurrence
+// accepts, which is how it is stored.
+func (recurrence Recurrence) String() string {
+	return strconv.Itoa(recurrence.Interval) + string(recurrence.Unit)
+}
+
+// Next returns the date one recurrence after from. Monthly and yearly
+// recurrences landing on a day their month does not have end on its last
+// day, so a month after January 31 is the end of February, not March.
+func (recurrence Recurrence) Next(from time.Time) time.Time {
+	switch recurrence.Unit {
+	case 'w':
+		return from.AddDate(0, 0, 7*recurrence.Interval)
+	case 'm':
+		return addMonths(from, recurrence.Interval)
+	case 'y':
+		return addMonths(from, 12*recurrence.Interval)
+	default:
+		return from.AddDate(0, 0, recurrence.Interval)
+	}
+}
+
+// addMonths adds months to t, keeping its day unless the month it lands
+// in is shorter.
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	return time.Date(first.Year(), first.Month(), min(day, last), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// validTag tells whether tag can be used as a tag, that is whether it is
+// a single word.
+func validTag(tag string) bool {
+	return tag != "" && !strings.ContainsAny(tag, " \t\n,")
+}
+
+// normalizeTags returns a sorted copy of tags without duplicates.
+func normalizeTags(tags []string) []string {
+	tags = slices.Clone(tags)
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// initialStatusHistory synthesizes the status history of a task that has
+// none, such as tasks saved by older versions or imported ones.
+func initialStatusHistory(task Task) []StatusChange {
+	change := StatusChange{Status: task.Status, At: task.CreatedAt}
+	if task.Status != TaskStatusTodo {
+		change.At = task.UpdatedAt
+	}
+	return []StatusChange{change}
+}
+
+// completionTime returns when the task was last marked done, falling back
+// to its status history for tasks saved before the completion time was
+// recorded, and to its last update for those without such a transition.
+func (task Task) completionTime() (t time.Time, ok bool) {
+	if task.Status != TaskStatusDone {
+		return
+	}
+
+	if task.CompletedAt != nil {
+		return *task.CompletedAt, true
+	}
+
+	for _, change := range slices.Backward(task.StatusHistory) {
+		if change.Status == TaskStatusDone {
+			return change.At, true
+		}
+	}
+
+	return task.UpdatedAt, true
 }
 
 type TaskStoreMeta struct {
 	CurrentId uint64 `json:"current_id"`
+	Frozen    bool   `json:"frozen,omitempty"`
 }
 
 type TaskStore struct {
-	dbPath string
-	Meta   TaskStoreMeta `json:"meta"`
-	Tasks  []Task        `json:"tasks"`
+	// mu serializes the assignment of ids.
+	mu      sync.Mutex
+	dbPath  string
+	journal []JournalEntry
+	// extra holds the top level fields unknown to this version.
+	extra map[string]json.RawMessage
+	Meta  TaskStoreMeta `json:"meta"`
+	Tasks []Task        `json:"tasks"`
 }
 
 func NewTaskStore() (store *TaskStore, err error) {
@@ -84,346 +667,8738 @@ func NewTaskStore() (store *TaskStore, err error) {
 	store.Meta.CurrentId = 1
 	store.Tasks = make([]Task, 0)
 
-	var dir string
-	if dir, err = os.UserConfigDir(); err != nil {
+	var dir string
+	if dir, err = os.UserConfigDir(); err != nil {
+		return
+	}
+	store.dbPath = path.Join(dir, appName(), "task.json")
+
+	return
+}
+
+// Load reads the store file, leaving the store empty when there is none
+// yet. Its directory is only created when the store is first written.
+func (store *TaskStore) Load() (err error) {
+	var data []byte
+	if data, err = os.ReadFile(store.dbPath); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	if err = json.Unmarshal(data, store); err != nil {
+		return
+	}
+
+	if store.extra, err = unknownFields(data, reflect.TypeFor[TaskStore]()); err != nil {
+		return
+	}
+
+	store.fillStatusHistory()
+	return
+}
+
+// Reload drops the tasks in memory and loads the store file again, picking
+// up the changes made by other processes.
+func (store *TaskStore) Reload() (err error) {
+	store.Meta = TaskStoreMeta{CurrentId: 1}
+	store.Tasks = make([]Task, 0)
+	store.extra = nil
+	store.journal = nil
+	return store.Load()
+}
+
+// fillStatusHistory gives the tasks saved before the status history
+// existed a single entry for their current status.
+func (store *TaskStore) fillStatusHistory() {
+	for i, task := range store.Tasks {
+		if len(task.StatusHistory) == 0 {
+			store.Tasks[i].StatusHistory = initialStatusHistory(task)
+		}
+	}
+}
+
+// StoreIssue is a problem found in the store by Issues.
+type StoreIssue struct {
+	Kind   string `json:"kind"`
+	TaskId TaskId `json:"task_id,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// Issues checks that the ids are set and unique, that the statuses and
+// priorities are valid, that descriptions are neither empty nor used
+// twice and that the next id is past every id in use.
+func (store *TaskStore) Issues() (issues []StoreIssue) {
+	ids := make(map[TaskId]bool, len(store.Tasks))
+	descriptions := make(map[string]TaskId, len(store.Tasks))
+	for _, task := range store.Tasks {
+		if task.Id == 0 {
+			issues = append(issues, StoreIssue{"missing_id", 0, fmt.Sprintf("task %q has no id", task.Description)})
+		} else if ids[task.Id] {
+			issues = append(issues, StoreIssue{"duplicate_id", task.Id, fmt.Sprintf("id %d is used more than once", task.Id)})
+		}
+		if !task.Status.Valid() {
+			issues = append(issues, StoreIssue{"invalid_status", task.Id, fmt.Sprintf("task %d has an invalid status", task.Id)})
+		}
+		if !task.Priority.Valid() {
+			issues = append(issues, StoreIssue{"invalid_priority", task.Id, fmt.Sprintf("task %d has an invalid priority", task.Id)})
+		}
+		if strings.TrimSpace(task.Description) == "" {
+			issues = append(issues, StoreIssue{"empty_description", task.Id, fmt.Sprintf("task %d has an empty description", task.Id)})
+		} else if first, ok := descriptions[task.Description]; ok {
+			issues = append(issues, StoreIssue{"duplicate_description", task.Id, fmt.Sprintf("task %d has the same description as task %d", task.Id, first)})
+		} else {
+			descriptions[task.Description] = task.Id
+		}
+		if uint64(task.Id) >= store.Meta.CurrentId {
+			issues = append(issues, StoreIssue{"current_id_behind", task.Id, fmt.Sprintf("current id %d is not past task id %d", store.Meta.CurrentId, task.Id)})
+		}
+		ids[task.Id] = true
+	}
+
+	return
+}
+
+// DescriptionChange is a task renamed by FixDuplicateDescriptions.
+type DescriptionChange struct {
+	Id     TaskId
+	Before string
+	After  string
+}
+
+// FixDuplicateDescriptions renames every task whose description is already
+// used by an earlier task, appending the first free " (n)" suffix, so the
+// tasks can be updated again. It saves once if anything changed.
+func (store *TaskStore) FixDuplicateDescriptions() (changes []DescriptionChange, err error) {
+	now := time.Now()
+	seen := make(map[string]bool, len(store.Tasks))
+
+	for i := range store.Tasks {
+		task := &store.Tasks[i]
+		if !seen[task.Description] {
+			seen[task.Description] = true
+			continue
+		}
+
+		old := *task
+		change := DescriptionChange{Id: task.Id, Before: task.Description, After: store.uniqueDescription(task.Description)}
+		task.Description = change.After
+		task.UpdatedAt = now
+		seen[task.Description] = true
+		if err = recordHistory(old, task); err != nil {
+			return
+		}
+		store.record(JournalOpUpdate, *task)
+		changes = append(changes, change)
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+
+	err = store.Save()
+	return
+}
+
+// Validate returns the first of the Issues of the store as an error.
+func (store *TaskStore) Validate() error {
+	if issues := store.Issues(); len(issues) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidStore, issues[0].Detail)
+	}
+	return nil
+}
+
+// RecomputeCurrentId sets the next id to one past the highest task id,
+// archived tasks included, leaving the task ids untouched, and saves if it
+// changed. It returns the next id before and after.
+func (store *TaskStore) RecomputeCurrentId() (before, after uint64, err error) {
+	var archived TaskId
+	if archived, err = store.maxArchivedId(); err != nil {
+		return
+	}
+
+	before = store.Meta.CurrentId
+	after = uint64(archived) + 1
+	for _, task := range store.Tasks {
+		after = max(after, uint64(task.Id)+1)
+	}
+
+	if after == before {
+		return
+	}
+
+	store.Meta.CurrentId = after
+	store.record(JournalOpMeta, Task{})
+	err = store.Save()
+	return
+}
+
+// Replace swaps the tasks and metadata of the store with the ones of
+// edited, journaling what changed, and saves.
+func (store *TaskStore) Replace(edited *TaskStore) (err error) {
+	for _, task := range store.Tasks {
+		if edited.Index(task.Id) == -1 {
+			store.record(JournalOpDelete, task)
+		}
+	}
+
+	for _, task := range edited.Tasks {
+		index := store.Index(task.Id)
+		if index == -1 {
+			store.record(JournalOpCreate, task)
+			continue
+		}
+
+		var before, after []byte
+		if before, err = json.Marshal(store.Tasks[index]); err != nil {
+			return
+		}
+		if after, err = json.Marshal(task); err != nil {
+			return
+		}
+		if !bytes.Equal(before, after) {
+			store.record(JournalOpUpdate, task)
+		}
+	}
+
+	store.Tasks = edited.Tasks
+	store.extra = edited.extra
+	if store.Meta != edited.Meta {
+		store.Meta = edited.Meta
+		store.record(JournalOpMeta, Task{})
+	}
+
+	return store.Save()
+}
+
+func (store *TaskStore) Save() (err error) {
+	if store.Meta.Frozen {
+		err = ErrStoreFrozen
+		return
+	}
+
+	return store.write()
+}
+
+// SetFrozen freezes or unfreezes the store. While frozen, Save refuses to
+// write so every mutating command fails.
+func (store *TaskStore) SetFrozen(frozen bool) (err error) {
+	store.Meta.Frozen = frozen
+	store.record(JournalOpMeta, Task{})
+	return store.write()
+}
+
+func (store *TaskStore) write() (err error) {
+	var data []byte
+	if data, err = marshalWithExtra(store, store.extra); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(path.Dir(store.dbPath), os.ModePerm); err != nil {
+		return
+	}
+
+	if err = os.WriteFile(store.dbPath, data, os.ModePerm); err != nil {
+		return
+	}
+
+	return store.writeJournal()
+}
+
+func (store *TaskStore) Create(task Task) (newTask Task, err error) {
+	var created []Task
+	if created, err = store.CreateMany([]Task{task}); err != nil {
+		return
+	}
+	return created[0], nil
+}
+
+// CreateMany adds tasks with sequential ids and saves once. Nothing is
+// added when a description is already taken, either by a stored task or
+// by another task of the batch.
+func (store *TaskStore) CreateMany(tasks []Task) (created []Task, err error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	seen := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		if seen[task.Description] || store.IndexByDescription(task.Description) != -1 {
+			err = ErrTaskAlreadyExists
+			return
+		}
+		seen[task.Description] = true
+	}
+
+	now := time.Now()
+	created = make([]Task, 0, len(tasks))
+
+	for _, task := range tasks {
+		task.Id = TaskId(store.Meta.CurrentId)
+		store.Meta.CurrentId++
+		if !task.Status.Valid() {
+			task.Status = TaskStatusTodo
+		}
+		task.CreatedAt = now
+		task.UpdatedAt = task.CreatedAt
+		task.StatusHistory = []StatusChange{{Status: task.Status, At: task.CreatedAt}}
+		task.CompletedAt = nil
+		if task.Status == TaskStatusDone {
+			task.CompletedAt = &task.CreatedAt
+		}
+
+		store.Tasks = append(store.Tasks, task)
+		store.record(JournalOpCreate, task)
+		created = append(created, task)
+	}
+
+	return created, store.Save()
+}
+
+func (store *TaskStore) Update(task Task) (err error) {
+	if store.Exists(task) {
+		err = ErrTaskAlreadyExists
+		return
+	}
+
+	task.UpdatedAt = time.Now()
+
+	index := store.Index(task.Id)
+	store.recur(store.Tasks[index], &task)
+
+	if err = recordHistory(store.Tasks[index], &task); err != nil {
+		return
+	}
+
+	store.Tasks[index] = task
+	store.record(JournalOpUpdate, task)
+	return store.Save()
+}
+
+// recur schedules the next occurrence of task when the change from old,
+// its stored version, marks a recurring task done.
+func (store *TaskStore) recur(old Task, task *Task) {
+	if task.Recurrence != "" && task.Status == TaskStatusDone && old.Status != TaskStatusDone {
+		store.scheduleNext(task)
+	}
+}
+
+// scheduleNext creates the next occurrence of task, a recurring task being
+// marked done, due one recurrence after its due date, or after today when
+// it has none, and never in the past. The next occurrence takes over the
+// description and the recurrence, while task is renamed after the day it
+// was done so descriptions stay unique. The next occurrence keeps the
+// shape of task, its tags, dependencies, estimate, custom fields,
+// attachments and checklist, the checklist starting over with every item
+// unticked. Unparsable recurrences, from hand edited stores, are left
+// alone.
+func (store *TaskStore) scheduleNext(task *Task) {
+	recurrence, err := parseRecurrence(task.Recurrence)
+	if err != nil {
+		return
+	}
+
+	now := task.UpdatedAt
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	dueAt := today
+	if task.DueAt != nil {
+		dueAt = *task.DueAt
+	}
+	dueAt = recurrence.Next(dueAt)
+	for dueAt.Before(today) {
+		dueAt = recurrence.Next(dueAt)
+	}
+
+	var checklist []ChecklistItem
+	for _, item := range task.Checklist {
+		checklist = append(checklist, ChecklistItem{Text: item.Text})
+	}
+
+	next := Task{
+		Id:            TaskId(store.Meta.CurrentId),
+		Description:   task.Description,
+		Status:        TaskStatusTodo,
+		StatusHistory: []StatusChange{{Status: TaskStatusTodo, At: now}},
+		Color:         task.Color,
+		ParentId:      task.ParentId,
+		DueAt:         &dueAt,
+		Tags:          slices.Clone(task.Tags),
+		Assignee:      task.Assignee,
+		Pinned:        task.Pinned,
+		Priority:      task.Priority,
+		Project:       task.Project,
+		DependsOn:     slices.Clone(task.DependsOn),
+		Recurrence:    task.Recurrence,
+		Estimate:      task.Estimate,
+		Custom:        maps.Clone(task.Custom),
+		Attachments:   slices.Clone(task.Attachments),
+		Checklist:     checklist,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	store.Meta.CurrentId++
+
+	task.Description = fmt.Sprintf("%s (done %s)", task.Description, today.Format(time.DateOnly))
+	if store.IndexByDescription(task.Description) != -1 {
+		task.Description = store.uniqueDescription(task.Description)
+	}
+	task.Recurrence = ""
+
+	store.Tasks = append(store.Tasks, next)
+	store.record(JournalOpCreate, next)
+}
+
+// MarkAll sets the status of every task not in it yet and saves once. It
+// returns the number of tasks changed, not counting the next occurrences
+// of the recurring tasks marked done.
+func (store *TaskStore) MarkAll(status TaskStatus) (count int, err error) {
+	now := time.Now()
+
+	// the next occurrences are appended while looping, the range is only
+	// over the tasks there before
+	for i := range store.Tasks {
+		if store.Tasks[i].Status == status {
+			continue
+		}
+
+		old, task := store.Tasks[i], store.Tasks[i]
+		task.SetStatus(status, now, "")
+		task.UpdatedAt = now
+		store.recur(old, &task)
+		if err = recordHistory(old, &task); err != nil {
+			return
+		}
+		store.Tasks[i] = task
+		store.record(JournalOpUpdate, task)
+		count++
+	}
+
+	if count == 0 {
+		return
+	}
+
+	return count, store.Save()
+}
+
+// Changed tells whether task differs from its stored version in anything
+// but its update time, so commands can skip saving when nothing changed.
+func (store *TaskStore) Changed(task Task) bool {
+	index := store.Index(task.Id)
+	if index == -1 {
+		return true
+	}
+
+	stored := store.Tasks[index]
+	stored.UpdatedAt = task.UpdatedAt
+
+	before, errBefore := json.Marshal(stored)
+	after, errAfter := json.Marshal(task)
+	return errBefore != nil || errAfter != nil || !bytes.Equal(before, after)
+}
+
+func (store *TaskStore) Delete(task Task) (err error) {
+	index := store.Index(task.Id)
+	store.Tasks = slices.Delete(store.Tasks, index, index+1)
+	store.record(JournalOpDelete, task)
+	return store.Save()
+}
+
+// DependsOn tells whether the task with id depends on the task with on,
+// directly or through other tasks. Cycles in hand edited stores are only
+// followed once.
+func (store *TaskStore) DependsOn(id, on TaskId) bool {
+	seen := map[TaskId]bool{id: true}
+	queue := []TaskId{id}
+	for len(queue) > 0 {
+		task, err := store.GetById(queue[0])
+		queue = queue[1:]
+		if err != nil {
+			continue
+		}
+		for _, dependency := range task.DependsOn {
+			if dependency == on {
+				return true
+			}
+			if !seen[dependency] {
+				seen[dependency] = true
+				queue = append(queue, dependency)
+			}
+		}
+	}
+	return false
+}
+
+// AddDependency makes the task with id wait for the task with on, unless
+// that would make a task wait for itself.
+func (store *TaskStore) AddDependency(id, on TaskId) (err error) {
+	var task Task
+	if task, err = store.GetById(id); err != nil {
+		return
+	}
+	if _, err = store.GetById(on); err != nil {
+		return
+	}
+
+	if id == on || store.DependsOn(on, id) {
+		err = ErrDependencyCycle
+		return
+	}
+
+	task.DependsOn = normalizeIds(append(task.DependsOn, on))
+	if !store.Changed(task) {
+		return
+	}
+	return store.Update(task)
+}
+
+// ClearReminders removes the reminders of the tasks with ids once they have
+// been given, leaving their update time alone as the tasks did not change
+// otherwise, and saves once if any was cleared.
+func (store *TaskStore) ClearReminders(ids []TaskId) (err error) {
+	cleared := false
+	for _, id := range ids {
+		index := store.Index(id)
+		if index == -1 || store.Tasks[index].RemindAt == nil {
+			continue
+		}
+		store.Tasks[index].RemindAt = nil
+		store.record(JournalOpUpdate, store.Tasks[index])
+		cleared = true
+	}
+
+	if !cleared {
+		return
+	}
+	return store.Save()
+}
+
+// Descendants returns the ids of the subtasks of the task with id, of their
+// own subtasks and so on. Parent cycles in hand edited stores are only
+// followed once.
+func (store *TaskStore) Descendants(id TaskId) (ids []TaskId) {
+	seen := map[TaskId]bool{id: true}
+	queue := []TaskId{id}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, task := range store.Tasks {
+			if task.ParentId == parent && !seen[task.Id] {
+				seen[task.Id] = true
+				ids = append(ids, task.Id)
+				queue = append(queue, task.Id)
+			}
+		}
+	}
+	return
+}
+
+// DeleteWithChildren deletes task along with every descendant when cascade
+// is set, and otherwise leaves its direct subtasks without parent. It saves
+// once and returns the number of subtasks deleted or orphaned.
+func (store *TaskStore) DeleteWithChildren(task Task, cascade bool) (count int, err error) {
+	if cascade {
+		doomed := make(map[TaskId]bool)
+		for _, id := range store.Descendants(task.Id) {
+			doomed[id] = true
+		}
+		count = len(doomed)
+		doomed[task.Id] = true
+
+		store.Tasks = slices.DeleteFunc(store.Tasks, func(task Task) bool {
+			if !doomed[task.Id] {
+				return false
+			}
+			store.record(JournalOpDelete, task)
+			return true
+		})
+		return count, store.Save()
+	}
+
+	now := time.Now()
+	for i := range store.Tasks {
+		child := &store.Tasks[i]
+		if child.ParentId != task.Id || child.Id == task.Id {
+			continue
+		}
+		old := *child
+		child.ParentId = 0
+		child.UpdatedAt = now
+		if err = recordHistory(old, child); err != nil {
+			return
+		}
+		store.record(JournalOpUpdate, *child)
+		count++
+	}
+
+	index := store.Index(task.Id)
+	store.Tasks = slices.Delete(store.Tasks, index, index+1)
+	store.record(JournalOpDelete, task)
+	return count, store.Save()
+}
+
+// DeleteAll deletes every task, and the archived ones too with
+// includeArchived. With resetIds the ids start over past the highest
+// archived id, so new tasks never take the id of an archived one.
+func (store *TaskStore) DeleteAll(resetIds, includeArchived bool) (err error) {
+	if store.Meta.Frozen {
+		err = ErrStoreFrozen
+		return
+	}
+
+	if includeArchived {
+		var archive *TaskStore
+		if archive, err = store.OpenArchive(); err != nil {
+			return
+		}
+		if len(archive.Tasks) != 0 {
+			if err = archive.DeleteAll(false, false); err != nil {
+				return
+			}
+		}
+	}
+
+	var archived TaskId
+	if resetIds {
+		if archived, err = store.maxArchivedId(); err != nil {
+			return
+		}
+	}
+
+	for _, task := range store.Tasks {
+		store.record(JournalOpDelete, task)
+	}
+	store.Tasks = make([]Task, 0)
+
+	if resetIds {
+		store.Meta.CurrentId = uint64(archived) + 1
+		store.record(JournalOpMeta, Task{})
+	}
+	return store.Save()
+}
+
+func (store *TaskStore) Exists(task Task) bool {
+	return slices.ContainsFunc(store.Tasks, func(v Task) bool {
+		return v.Id != task.Id && v.Description == task.Description
+	})
+}
+
+func (store *TaskStore) Index(id TaskId) int {
+	return slices.IndexFunc(store.Tasks, func(task Task) bool {
+		return task.Id == id
+	})
+}
+
+func (store *TaskStore) GetById(id TaskId) (task Task, err error) {
+	index := store.Index(id)
+	if index == -1 {
+		err = ErrTaskDoesNotExist
+		return
+	}
+
+	task = store.Tasks[index]
+	return
+}
+
+func (store *TaskStore) GetByStatus(status TaskStatus) (tasks []Task) {
+	for _, task := range store.Tasks {
+		if task.Status == status {
+			tasks = append(tasks, task)
+		}
+	}
+
+	return
+}
+
+func (store *TaskStore) Filter(predicate TaskPredicate) []Task {
+	return filterTasks(store.Tasks, predicate)
+}
+
+func filterTasks(tasks []Task, predicate TaskPredicate) (filtered []Task) {
+	for _, task := range tasks {
+		if predicate(task) {
+			filtered = append(filtered, task)
+		}
+	}
+
+	return
+}
+
+func (store *TaskStore) DeleteWhere(predicate TaskPredicate) (count int, err error) {
+	store.Tasks = slices.DeleteFunc(store.Tasks, func(task Task) bool {
+		if !predicate(task) {
+			return false
+		}
+
+		store.record(JournalOpDelete, task)
+		count++
+		return true
+	})
+	return count, store.Save()
+}
+
+type TaskPredicate func(Task) bool
+
+// WhereError reports an invalid --where expression, Pos being the byte
+// offset of the offending token.
+type WhereError struct {
+	Pos int
+	Msg string
+}
+
+func (err *WhereError) Error() string {
+	return fmt.Sprintf("invalid expression at position %d: %s", err.Pos+1, err.Msg)
+}
+
+type whereToken struct {
+	pos   int
+	value string
+	op    bool
+}
+
+func tokenizeWhere(expr string) (tokens []whereToken, err error) {
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '<' || c == '>':
+			if strings.HasPrefix(expr[i+1:], "=") {
+				tokens = append(tokens, whereToken{pos: i, value: expr[i : i+2], op: true})
+				i += 2
+				continue
+			}
+			tokens = append(tokens, whereToken{pos: i, value: string(c), op: true})
+			i++
+		case c == '=' || c == '(' || c == ')':
+			tokens = append(tokens, whereToken{pos: i, value: string(c), op: true})
+			i++
+		case c == '!':
+			if !strings.HasPrefix(expr[i:], "!=") {
+				err = &WhereError{Pos: i, Msg: "expected '!='"}
+				return
+			}
+			tokens = append(tokens, whereToken{pos: i, value: "!=", op: true})
+			i += 2
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end == -1 {
+				err = &WhereError{Pos: i, Msg: "unterminated quoted value"}
+				return
+			}
+			tokens = append(tokens, whereToken{pos: i, value: expr[i+1 : i+1+end]})
+			i += end + 2
+		default:
+			start := i
+			for i < len(expr) && !strings.ContainsRune(" \t=<>!'\"()", rune(expr[i])) {
+				i++
+			}
+			tokens = append(tokens, whereToken{pos: start, value: expr[start:i]})
+		}
+	}
+
+	return
+}
+
+type whereParser struct {
+	expr   string
+	tokens []whereToken
+	next   int
+	store  *TaskStore
+	now    time.Time
+}
+
+// parseWhere parses a filter expression such as
+// `status=todo and (tag=work or priority>=high) and due<eow` into a
+// predicate. Comparisons are made of a field, an operator (=, !=, <, >, <=
+// or >=) and a value. Bare words stand for the tasks with a status, or
+// overdue, waiting, ready, blocked or pinned ones. Both are negated with
+// `not`, grouped with parentheses and combined with `and`, which binds
+// tighter than `or`. The store is the one ready and blocked look up the
+// dependencies in.
+func parseWhere(expr string, store *TaskStore) (predicate TaskPredicate, err error) {
+	parser := whereParser{expr: expr, store: store, now: time.Now()}
+	if parser.tokens, err = tokenizeWhere(expr); err != nil {
+		return
+	}
+
+	if predicate, err = parser.parseOr(); err != nil {
+		return
+	}
+
+	if token, ok := parser.peek(); ok {
+		err = &WhereError{Pos: token.pos, Msg: fmt.Sprintf("unexpected %q", token.value)}
+	}
+
+	return
+}
+
+func (parser *whereParser) peek() (token whereToken, ok bool) {
+	if parser.next < len(parser.tokens) {
+		return parser.tokens[parser.next], true
+	}
+	return
+}
+
+func (parser *whereParser) keyword(word string) bool {
+	if token, ok := parser.peek(); ok && !token.op && token.value == word {
+		parser.next++
+		return true
+	}
+	return false
+}
+
+func (parser *whereParser) parseOr() (predicate TaskPredicate, err error) {
+	if predicate, err = parser.parseAnd(); err != nil {
+		return
+	}
+
+	for parser.keyword("or") {
+		var right TaskPredicate
+		if right, err = parser.parseAnd(); err != nil {
+			return
+		}
+
+		left := predicate
+		predicate = func(task Task) bool { return left(task) || right(task) }
+	}
+
+	return
+}
+
+func (parser *whereParser) parseAnd() (predicate TaskPredicate, err error) {
+	if predicate, err = parser.parseUnary(); err != nil {
+		return
+	}
+
+	for parser.keyword("and") {
+		var right TaskPredicate
+		if right, err = parser.parseUnary(); err != nil {
+			return
+		}
+
+		left := predicate
+		predicate = func(task Task) bool { return left(task) && right(task) }
+	}
+
+	return
+}
+
+func (parser *whereParser) expect(op bool, what string) (token whereToken, err error) {
+	var ok bool
+	if token, ok = parser.peek(); !ok {
+		err = &WhereError{Pos: len(parser.expr), Msg: "expected " + what}
+		return
+	}
+
+	if token.op != op {
+		err = &WhereError{Pos: token.pos, Msg: fmt.Sprintf("expected %s, got %q", what, token.value)}
+		return
+	}
+
+	parser.next++
+	return
+}
+
+func (parser *whereParser) parseUnary() (predicate TaskPredicate, err error) {
+	if parser.keyword("not") {
+		var operand TaskPredicate
+		if operand, err = parser.parseUnary(); err != nil {
+			return
+		}
+		predicate = func(task Task) bool { return !operand(task) }
+		return
+	}
+
+	if token, ok := parser.peek(); !ok || !token.op || token.value != "(" {
+		return parser.parseComparison()
+	}
+	parser.next++
+
+	if predicate, err = parser.parseOr(); err != nil {
+		return
+	}
+
+	token, ok := parser.peek()
+	if !ok {
+		err = &WhereError{Pos: len(parser.expr), Msg: "expected ')'"}
+		return
+	}
+	if !token.op || token.value != ")" {
+		err = &WhereError{Pos: token.pos, Msg: fmt.Sprintf("expected ')', got %q", token.value)}
+		return
+	}
+	parser.next++
+	return
+}
+
+// parseKeyword returns the predicate of a bare word, a status name or one
+// of overdue, waiting, ready, blocked and pinned.
+func (parser *whereParser) parseKeyword(word whereToken) (predicate TaskPredicate, err error) {
+	now, store := parser.now, parser.store
+
+	switch word.value {
+	case "overdue":
+		predicate = func(task Task) bool { return task.Overdue(now) }
+	case "waiting":
+		predicate = func(task Task) bool { return task.Waiting(now) }
+	case "ready":
+		predicate = func(task Task) bool { return task.Ready(store) }
+	case "blocked":
+		predicate = func(task Task) bool { return task.Status != TaskStatusDone && task.Blocked(store) }
+	case "pinned":
+		predicate = func(task Task) bool { return task.Pinned }
+	default:
+		status := NewTaskStatus(word.value)
+		if !status.Valid() {
+			err = &WhereError{Pos: word.pos, Msg: fmt.Sprintf("unknown filter %q", word.value)}
+			return
+		}
+		predicate = func(task Task) bool { return task.Status == status }
+	}
+
+	return
+}
+
+func (parser *whereParser) parseComparison() (predicate TaskPredicate, err error) {
+	var field, op, value whereToken
+	if field, err = parser.expect(false, "field"); err != nil {
+		return
+	}
+	if token, ok := parser.peek(); !ok || !token.op || token.value == "(" || token.value == ")" {
+		return parser.parseKeyword(field)
+	}
+	if op, err = parser.expect(true, "operator"); err != nil {
+		return
+	}
+	if value, err = parser.expect(false, "value"); err != nil {
+		return
+	}
+
+	// compare returns how a task compares to the value, ok being false
+	// when the task has no such field, which then matches no operator
+	var compare func(Task) (order int, ok bool)
+	// ordered tells whether <, >, <= and >= make sense for the field
+	ordered := true
+	switch field.value {
+	case "status":
+		status := NewTaskStatus(value.value)
+		if !status.Valid() {
+			err = &WhereError{Pos: value.pos, Msg: fmt.Sprintf("invalid status %q", value.value)}
+			return
+		}
+		compare = func(task Task) (int, bool) { return int(task.Status) - int(status), true }
+	case "priority":
+		priority, ok := NewTaskPriority(value.value)
+		if !ok {
+			err = &WhereError{Pos: value.pos, Msg: fmt.Sprintf("invalid priority %q", value.value)}
+			return
+		}
+		compare = func(task Task) (int, bool) { return int(task.Priority) - int(priority), true }
+	case "created", "updated", "due", "wait", "completed":
+		fieldTime := whereTimeFields[field.value]
+
+		// none matches the tasks without the date
+		if value.value == "none" {
+			if op.value != "=" && op.value != "!=" {
+				err = &WhereError{Pos: op.pos, Msg: fmt.Sprintf("operator %q does not apply to none", op.value)}
+				return
+			}
+			ordered = false
+			compare = func(task Task) (int, bool) {
+				if fieldTime(task) == nil {
+					return 0, true
+				}
+				return 1, true
+			}
+			break
+		}
+
+		var t time.Time
+		var precision time.Duration
+		if t, precision, err = parseWhereTime(value, parser.now); err != nil {
+			return
+		}
+
+		compare = func(task Task) (int, bool) {
+			at := fieldTime(task)
+			if at == nil {
+				return 0, false
+			}
+			return truncateTime(*at, precision).Compare(t), true
+		}
+	case "tag":
+		ordered = false
+		compare = func(task Task) (int, bool) {
+			if slices.Contains(task.Tags, value.value) {
+				return 0, true
+			}
+			return 1, true
+		}
+	case "project", "assignee":
+		ordered = false
+		fieldValue := func(task Task) string { return task.Project }
+		if field.value == "assignee" {
+			fieldValue = func(task Task) string { return task.Assignee }
+		}
+		compare = func(task Task) (int, bool) { return strings.Compare(fieldValue(task), value.value), true }
+	default:
+		err = &WhereError{Pos: field.pos, Msg: fmt.Sprintf("unknown field %q", field.value)}
+		return
+	}
+
+	var match func(order int) bool
+	switch op.value {
+	case "=":
+		match = func(order int) bool { return order == 0 }
+	case "!=":
+		match = func(order int) bool { return order != 0 }
+	case "<":
+		match = func(order int) bool { return order < 0 }
+	case ">":
+		match = func(order int) bool { return order > 0 }
+	case "<=":
+		match = func(order int) bool { return order <= 0 }
+	case ">=":
+		match = func(order int) bool { return order >= 0 }
+	default:
+		err = &WhereError{Pos: op.pos, Msg: fmt.Sprintf("unexpected %q", op.value)}
+		return
+	}
+	if !ordered && op.value != "=" && op.value != "!=" {
+		err = &WhereError{Pos: op.pos, Msg: fmt.Sprintf("operator %q does not apply to %s", op.value, field.value)}
+		return
+	}
+
+	predicate = func(task Task) bool {
+		order, ok := compare(task)
+		return ok && match(order)
+	}
+	return
+}
+
+// whereTimeFields are the dates filter expressions compare, nil when a task
+// has none.
+var whereTimeFields = map[string]func(Task) *time.Time{
+	"created":   func(task Task) *time.Time { return &task.CreatedAt },
+	"updated":   func(task Task) *time.Time { return &task.UpdatedAt },
+	"due":       func(task Task) *time.Time { return task.DueAt },
+	"wait":      func(task Task) *time.Time { return task.WaitUntil },
+	"completed": func(task Task) *time.Time { return task.CompletedAt },
+}
+
+// filtersWaiting tells whether the filter expression is about waiting
+// tasks, which list then does not hide.
+func filtersWaiting(expr string) bool {
+	tokens, _ := tokenizeWhere(expr)
+	return slices.ContainsFunc(tokens, func(token whereToken) bool {
+		return !token.op && (token.value == "waiting" || token.value == "wait")
+	})
+}
+
+// parseWhereTime parses a date or date and time value in the local zone,
+// returning the precision comparisons against it should be made with.
+// Besides dates, it accepts now, today, yesterday and tomorrow, and the
+// start or end of the current week, month or year: sow, eow, som, eom, soy
+// and eoy, weeks starting on monday.
+func parseWhereTime(token whereToken, now time.Time) (t time.Time, precision time.Duration, err error) {
+	now = now.In(time.Local)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	// weeks start on monday
+	monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	year := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.Local)
+
+	// the ends of periods are their last second, so that < takes in the
+	// whole period
+	switch token.value {
+	case "now":
+		return now.Truncate(time.Second), time.Second, nil
+	case "today":
+		return today, 24 * time.Hour, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), 24 * time.Hour, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), 24 * time.Hour, nil
+	case "sow":
+		return monday, time.Second, nil
+	case "eow":
+		return monday.AddDate(0, 0, 7).Add(-time.Second), time.Second, nil
+	case "som":
+		return month, time.Second, nil
+	case "eom":
+		return month.AddDate(0, 1, 0).Add(-time.Second), time.Second, nil
+	case "soy":
+		return year, time.Second, nil
+	case "eoy":
+		return year.AddDate(1, 0, 0).Add(-time.Second), time.Second, nil
+	}
+
+	if t, err = time.ParseInLocation(time.DateOnly, token.value, time.Local); err == nil {
+		precision = 24 * time.Hour
+		return
+	}
+
+	if t, err = time.ParseInLocation(time.DateTime, token.value, time.Local); err == nil {
+		precision = time.Second
+		return
+	}
+
+	err = &WhereError{Pos: token.pos, Msg: fmt.Sprintf("invalid date %q", token.value)}
+	return
+}
+
+// truncateTime truncates t to the given precision in its own zone, unlike
+// time.Time.Truncate which works on absolute time.
+func truncateTime(t time.Time, precision time.Duration) time.Time {
+	t = t.In(time.Local)
+	if precision >= 24*time.Hour {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	return t.Truncate(precision)
+}
+
+type MergeStrategy uint8
+
+const (
+	_ MergeStrategy = iota
+	MergeStrategySkip
+	MergeStrategyOverwrite
+	MergeStrategyRename
+)
+
+var mergeStrategyMapFromString = map[string]MergeStrategy{
+	"skip":      MergeStrategySkip,
+	"overwrite": MergeStrategyOverwrite,
+	"rename":    MergeStrategyRename,
+}
+
+var mergeStrategyMapToString = map[MergeStrategy]string{
+	MergeStrategySkip:      "skip",
+	MergeStrategyOverwrite: "overwrite",
+	MergeStrategyRename:    "rename",
+}
+
+func NewMergeStrategy(str string) MergeStrategy {
+	return mergeStrategyMapFromString[str]
+}
+
+func (strategy MergeStrategy) String() string {
+	return mergeStrategyMapToString[strategy]
+}
+
+func (strategy MergeStrategy) Valid() bool {
+	_, ok := mergeStrategyMapToString[strategy]
+	return ok
+}
+
+type ImportResult struct {
+	Created     int
+	Overwritten int
+	Skipped     int
+}
+
+// Import adds tasks to the store, resolving the ones whose description is
+// already taken with strategy, and saves once at the end. Imported tasks
+// always get a new id.
+func (store *TaskStore) Import(tasks []Task, strategy MergeStrategy) (result ImportResult, err error) {
+	now := time.Now()
+
+	for _, task := range tasks {
+		if task.CreatedAt.IsZero() {
+			task.CreatedAt = now
+		}
+		if task.UpdatedAt.IsZero() {
+			task.UpdatedAt = task.CreatedAt
+		}
+		if !task.Status.Valid() {
+			task.Status = TaskStatusTodo
+		}
+		task.StatusHistory = initialStatusHistory(task)
+
+		if index := store.IndexByDescription(task.Description); index != -1 {
+			switch strategy {
+			case MergeStrategyOverwrite:
+				// the imported fields replace the existing ones, but the task
+				// keeps its id and its histories, the status change recorded
+				// on top of them
+				old := store.Tasks[index]
+				status := task.Status
+				task.Id, task.CreatedAt, task.UpdatedAt = old.Id, old.CreatedAt, now
+				task.Status, task.StatusHistory, task.CompletedAt = old.Status, old.StatusHistory, old.CompletedAt
+				task.History = old.History
+				task.SetStatus(status, now, "")
+				if err = recordHistory(old, &task); err != nil {
+					return
+				}
+				store.Tasks[index] = task
+				store.record(JournalOpUpdate, task)
+				result.Overwritten++
+				continue
+			case MergeStrategyRename:
+				task.Description = store.uniqueDescription(task.Description)
+			default:
+				result.Skipped++
+				continue
+			}
+		}
+
+		task.Id = TaskId(store.Meta.CurrentId)
+		store.Meta.CurrentId++
+		store.Tasks = append(store.Tasks, task)
+		store.record(JournalOpCreate, task)
+		result.Created++
+	}
+
+	return result, store.Save()
+}
+
+func (store *TaskStore) IndexByDescription(description string) int {
+	return slices.IndexFunc(store.Tasks, func(task Task) bool {
+		return task.Description == description
+	})
+}
+
+// uniqueDescription appends the first free " (n)" suffix to description.
+func (store *TaskStore) uniqueDescription(description string) string {
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)", description, n)
+		if store.IndexByDescription(candidate) == -1 {
+			return candidate
+		}
+	}
+}
+
+// importRecord is a task as read by the importers, where everything but
+// the description is optional.
+type importRecord struct {
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Task returns the task the record describes. A record updated before it
+// was created is an error, unless clampTimes is set, in which case its
+// update time is moved up to its creation time and clamped tells so.
+func (record importRecord) Task(clampTimes bool) (task Task, clamped bool, err error) {
+	if record.Description == "" {
+		err = ErrEmptyDescription
+		return
+	}
+
+	task.Description = record.Description
+	task.CreatedAt = record.CreatedAt
+	task.UpdatedAt = record.UpdatedAt
+
+	if !task.CreatedAt.IsZero() && !task.UpdatedAt.IsZero() && task.UpdatedAt.Before(task.CreatedAt) {
+		if !clampTimes {
+			err = ErrInvertedTimestamps
+			return
+		}
+		task.UpdatedAt = task.CreatedAt
+		clamped = true
+	}
+
+	if record.Status != "" {
+		if task.Status = NewTaskStatus(record.Status); !task.Status.Valid() {
+			err = ErrInvalidTaskStatus
+			return
+		}
+	}
+
+	return
+}
+
+// readImportJSON reads a JSON array of tasks, as written by `list --json`.
+// With clampTimes, warnings tells which tasks had their update time
+// clamped.
+func readImportJSON(r io.Reader, clampTimes bool) (tasks []Task, warnings []string, err error) {
+	var records []importRecord
+	if err = json.NewDecoder(r).Decode(&records); err != nil {
+		return
+	}
+
+	for i, record := range records {
+		var task Task
+		var clamped bool
+		if task, clamped, err = record.Task(clampTimes); err != nil {
+			err = fmt.Errorf("task %d: %w", i+1, err)
+			return
+		}
+		if clamped {
+			warnings = append(warnings, fmt.Sprintf("task %d: %s, clamped it to created_at", i+1, ErrInvertedTimestamps))
+		}
+		tasks = append(tasks, task)
+	}
+
+	return
+}
+
+// readImportCSV reads tasks from CSV with a header row naming the columns.
+// Only the description column is required, timestamps are RFC 3339. With
+// clampTimes, warnings tells which rows had their update time clamped.
+func readImportCSV(r io.Reader, clampTimes bool) (tasks []Task, warnings []string, err error) {
+	reader := csv.NewReader(r)
+
+	var header []string
+	if header, err = reader.Read(); err != nil {
+		return
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	if _, ok := columns["description"]; !ok {
+		err = ErrMissingDescriptionColumn
+		return
+	}
+
+	for row := 2; ; row++ {
+		var fields []string
+		if fields, err = reader.Read(); err == io.EOF {
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return fields[i]
+			}
+			return ""
+		}
+
+		record := importRecord{Description: field("description"), Status: field("status")}
+		for name, t := range map[string]*time.Time{"created_at": &record.CreatedAt, "updated_at": &record.UpdatedAt} {
+			if value := field(name); value != "" {
+				if *t, err = time.Parse(time.RFC3339, value); err != nil {
+					err = fmt.Errorf("row %d: %w", row, err)
+					return
+				}
+			}
+		}
+
+		var task Task
+		var clamped bool
+		if task, clamped, err = record.Task(clampTimes); err != nil {
+			err = fmt.Errorf("row %d: %w", row, err)
+			return
+		}
+		if clamped {
+			warnings = append(warnings, fmt.Sprintf("row %d: %s, clamped it to created_at", row, ErrInvertedTimestamps))
+		}
+		tasks = append(tasks, task)
+	}
+}
+
+var taskSortKeysMap = map[string]func(a, b Task) int{
+	"id": func(a, b Task) int {
+		return cmp.Compare(a.Id, b.Id)
+	},
+	"created": func(a, b Task) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	},
+	"updated": func(a, b Task) int {
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	},
+	"status": func(a, b Task) int {
+		return cmp.Compare(a.Status, b.Status)
+	},
+	"description": func(a, b Task) int {
+		return strings.Compare(a.Description, b.Description)
+	},
+	// most important first, as a priority list is read top down
+	"priority": func(a, b Task) int {
+		return cmp.Compare(priorityRank[a.Priority], priorityRank[b.Priority])
+	},
+}
+
+// priorityRank is the position of each priority when sorting by priority,
+// urgent first, so the order does not depend on the values of the
+// constants.
+var priorityRank = map[TaskPriority]int{
+	TaskPriorityUrgent: 0,
+	TaskPriorityHigh:   1,
+	TaskPriorityMedium: 2,
+	TaskPriorityLow:    3,
+}
+
+// taskSortKey returns the comparison function for a sort key, which is
+// one of taskSortKeysMap optionally prefixed with "-" for descending order.
+func taskSortKey(key string) (compare func(a, b Task) int, ok bool) {
+	name, descending := strings.CutPrefix(key, "-")
+	if compare, ok = taskSortKeysMap[name]; !ok || !descending {
+		return
+	}
+
+	ascending := compare
+	return func(a, b Task) int { return ascending(b, a) }, true
+}
+
+// sortTasks returns a copy of tasks sorted by key, ties keeping their
+// original order.
+func sortTasks(tasks []Task, key string) (sorted []Task, err error) {
+	compare, ok := taskSortKey(key)
+	if !ok {
+		err = ErrInvalidSortKey
+		return
+	}
+
+	sorted = slices.Clone(tasks)
+	slices.SortStableFunc(sorted, compare)
+	return
+}
+
+// pinnedFirst moves the pinned tasks before the others, keeping the order
+// of both.
+func pinnedFirst(tasks []Task) []Task {
+	slices.SortStableFunc(tasks, func(a, b Task) int {
+		switch {
+		case a.Pinned == b.Pinned:
+			return 0
+		case a.Pinned:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return tasks
+}
+
+type JournalOp string
+
+const (
+	JournalOpCreate JournalOp = "create"
+	JournalOpUpdate JournalOp = "update"
+	JournalOpDelete JournalOp = "delete"
+	JournalOpMeta   JournalOp = "meta"
+)
+
+// JournalEntry is one mutation of the store as logged in its journal, an
+// append-only file of JSON lines kept next to the store file. Create and
+// update entries hold the whole task, delete entries its id and meta
+// entries the whole store metadata.
+type JournalEntry struct {
+	At   time.Time      `json:"at"`
+	Op   JournalOp      `json:"op"`
+	Task *Task          `json:"task,omitempty"`
+	Id   TaskId         `json:"id,omitempty"`
+	Meta *TaskStoreMeta `json:"meta,omitempty"`
+}
+
+func (store *TaskStore) journalPath() string {
+	return strings.TrimSuffix(store.dbPath, path.Ext(store.dbPath)) + ".journal"
+}
+
+// record queues a journal entry, written along with the store on the next
+// successful save.
+func (store *TaskStore) record(op JournalOp, task Task) {
+	entry := JournalEntry{At: time.Now(), Op: op}
+	switch op {
+	case JournalOpDelete:
+		entry.Id = task.Id
+	case JournalOpMeta:
+		meta := store.Meta
+		entry.Meta = &meta
+	default:
+		entry.Task = &task
+	}
+
+	store.journal = append(store.journal, entry)
+}
+
+func (store *TaskStore) writeJournal() (err error) {
+	if len(store.journal) == 0 {
+		return
+	}
+
+	var file *os.File
+	if file, err = os.OpenFile(store.journalPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.ModePerm); err != nil {
+		return
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, entry := range store.journal {
+		if err = encoder.Encode(entry); err != nil {
+			return
+		}
+	}
+	store.journal = nil
+
+	return file.Close()
+}
+
+// Replay replaces the tasks and metadata of the store with the state
+// obtained by applying every entry of a journal in order, starting from an
+// empty store. It stops at the first entry that cannot be applied,
+// reporting its line number. Nothing is saved.
+func (store *TaskStore) Replay(r io.Reader) (err error) {
+	replayed := TaskStore{Meta: TaskStoreMeta{CurrentId: 1}, Tasks: make([]Task, 0)}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var entry JournalEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("journal line %d: %w", line, err)
+		}
+
+		if err = replayed.apply(entry); err != nil {
+			return fmt.Errorf("journal line %d: %w", line, err)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	store.Meta = replayed.Meta
+	store.Tasks = replayed.Tasks
+	return
+}
+
+func (store *TaskStore) apply(entry JournalEntry) (err error) {
+	switch entry.Op {
+	case JournalOpCreate:
+		if entry.Task == nil {
+			return ErrInvalidJournalEntry
+		}
+		if store.Index(entry.Task.Id) != -1 {
+			return ErrTaskAlreadyExists
+		}
+		store.Tasks = append(store.Tasks, *entry.Task)
+		store.Meta.CurrentId = max(store.Meta.CurrentId, uint64(entry.Task.Id)+1)
+	case JournalOpUpdate:
+		if entry.Task == nil {
+			return ErrInvalidJournalEntry
+		}
+		index := store.Index(entry.Task.Id)
+		if index == -1 {
+			return ErrTaskDoesNotExist
+		}
+		store.Tasks[index] = *entry.Task
+	case JournalOpDelete:
+		index := store.Index(entry.Id)
+		if index == -1 {
+			return ErrTaskDoesNotExist
+		}
+		store.Tasks = slices.Delete(store.Tasks, index, index+1)
+	case JournalOpMeta:
+		if entry.Meta == nil {
+			return ErrInvalidJournalEntry
+		}
+		store.Meta = *entry.Meta
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidJournalEntry, entry.Op)
+	}
+
+	return
+}
+
+// backupFile is a file bundled in a backup archive under name, restored
+// to path. A directory is bundled as one entry per file, named after the
+// directory and the file. Restoring a backup without a file removes the
+// file when removeIfMissing is set, as it would not match the tasks.
+type backupFile struct {
+	name            string
+	path            string
+	required        bool
+	dir             bool
+	removeIfMissing bool
+}
+
+// backupFiles returns the files making a backup: the store, its journal,
+// the archive and its journal, the templates, the attachment blobs and the
+// user config file.
+func (store *TaskStore) backupFiles() (files []backupFile, err error) {
+	var configPath string
+	if configPath, err = userConfigPath(); err != nil {
+		return
+	}
+
+	archive := &TaskStore{dbPath: store.archivePath()}
+	files = []backupFile{
+		{name: "task.json", path: store.dbPath, required: true},
+		{name: "task.journal", path: store.journalPath(), removeIfMissing: true},
+		{name: "task.archive.json", path: archive.dbPath, removeIfMissing: true},
+		{name: "task.archive.journal", path: archive.journalPath(), removeIfMissing: true},
+		{name: "task.templates.json", path: store.templatesPath()},
+		{name: "task.blobs", path: store.blobDir(), dir: true},
+		{name: configFileName, path: configPath},
+	}
+	return
+}
+
+// backupEntry returns the backup file the archive entry name belongs to,
+// and for directories the name of the file inside it.
+func backupEntry(files []backupFile, name string) (file backupFile, inner string, ok bool) {
+	for _, file = range files {
+		if !file.dir && name == file.name {
+			return file, "", true
+		}
+
+		// only plain file names, so entries cannot escape the directory
+		rest, found := strings.CutPrefix(name, file.name+"/")
+		if file.dir && found && rest != "" && rest != "." && rest != ".." && !strings.ContainsAny(rest, "/\\") {
+			return file, rest, true
+		}
+	}
+	return backupFile{}, "", false
+}
+
+// ExportBackup writes a zip archive of the files returned by backupFiles
+// to w, leaving out the optional ones that do not exist.
+func (store *TaskStore) ExportBackup(w io.Writer) (err error) {
+	var files []backupFile
+	if files, err = store.backupFiles(); err != nil {
+		return
+	}
+
+	archive := zip.NewWriter(w)
+
+	write := func(name, file string) (err error) {
+		var data []byte
+		if data, err = os.ReadFile(file); err != nil {
+			return
+		}
+
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+		var entry io.Writer
+		if entry, err = archive.CreateHeader(header); err != nil {
+			return
+		}
+		_, err = entry.Write(data)
+		return
+	}
+
+	for _, file := range files {
+		if !file.dir {
+			if err = write(file.name, file.path); err != nil {
+				if os.IsNotExist(err) && !file.required {
+					err = nil
+					continue
+				}
+				return
+			}
+			continue
+		}
+
+		var entries []os.DirEntry
+		if entries, err = os.ReadDir(file.path); err != nil {
+			if os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			return
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			if err = write(file.name+"/"+entry.Name(), path.Join(file.path, entry.Name())); err != nil {
+				return
+			}
+		}
+	}
+
+	return archive.Close()
+}
+
+// ReadBackup reads the backup archive at file, checking it holds nothing
+// but backup files, the store among them, and that the store is valid. It
+// returns the contents of each file by name.
+func (store *TaskStore) ReadBackup(file string) (contents map[string][]byte, err error) {
+	var files []backupFile
+	if files, err = store.backupFiles(); err != nil {
+		return
+	}
+
+	var archive *zip.ReadCloser
+	if archive, err = zip.OpenReader(file); err != nil {
+		return
+	}
+	defer archive.Close()
+
+	contents = make(map[string][]byte, len(archive.File))
+
+	for _, entry := range archive.File {
+		if _, _, ok := backupEntry(files, entry.Name); !ok {
+			return nil, fmt.Errorf("%w: unexpected file %q", ErrInvalidBackup, entry.Name)
+		}
+
+		var reader io.ReadCloser
+		if reader, err = entry.Open(); err != nil {
+			return
+		}
+		contents[entry.Name], err = io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	for _, file := range files {
+		if _, ok := contents[file.name]; file.required && !ok {
+			return nil, fmt.Errorf("%w: missing %s", ErrInvalidBackup, file.name)
+		}
+	}
+
+	backup := &TaskStore{Tasks: make([]Task, 0)}
+	if err = json.Unmarshal(contents["task.json"], backup); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+	if err = backup.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	return
+}
+
+// RestoreBackup overwrites the backup files with contents, as returned by
+// ReadBackup. A journal missing from the backup is removed, since it would
+// not match the restored store, while a missing config file is left as is.
+func (store *TaskStore) RestoreBackup(contents map[string][]byte) (err error) {
+	if store.Meta.Frozen {
+		return ErrStoreFrozen
+	}
+
+	var files []backupFile
+	if files, err = store.backupFiles(); err != nil {
+		return
+	}
+
+	for _, file := range files {
+		data, ok := contents[file.name]
+		if !ok {
+			if file.removeIfMissing {
+				if err = os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+					return
+				}
+				err = nil
+			}
+			continue
+		}
+
+		if err = os.MkdirAll(path.Dir(file.path), os.ModePerm); err != nil {
+			return
+		}
+		if err = os.WriteFile(file.path, data, os.ModePerm); err != nil {
+			return
+		}
+	}
+
+	// the files of directories are added to what is there, blobs being
+	// named after their content
+	for name, data := range contents {
+		file, inner, ok := backupEntry(files, name)
+		if !ok || !file.dir {
+			continue
+		}
+
+		if err = os.MkdirAll(file.path, os.ModePerm); err != nil {
+			return
+		}
+		if err = os.WriteFile(path.Join(file.path, inner), data, 0o644); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// TaskChange names the fields of a task that differ between two stores,
+// by their JSON keys.
+type TaskChange struct {
+	Id     TaskId   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// StoreDiff holds what changes from one store to another.
+type StoreDiff struct {
+	Added    []Task
+	Removed  []Task
+	Modified []TaskChange
+}
+
+// DiffStores returns the tasks added, removed and modified going from a to
+// b, matching tasks by id.
+func DiffStores(a, b *TaskStore) (diff StoreDiff, err error) {
+	for _, task := range a.Tasks {
+		if b.Index(task.Id) == -1 {
+			diff.Removed = append(diff.Removed, task)
+		}
+	}
+
+	for _, task := range b.Tasks {
+		index := a.Index(task.Id)
+		if index == -1 {
+			diff.Added = append(diff.Added, task)
+			continue
+		}
+
+		var fields []string
+		if fields, err = changedFields(a.Tasks[index], task); err != nil {
+			return
+		}
+		if len(fields) > 0 {
+			diff.Modified = append(diff.Modified, TaskChange{Id: task.Id, Fields: fields})
+		}
+	}
+
+	return
+}
+
+// changedFields returns the sorted JSON keys whose values differ between
+// the tasks a and b.
+func changedFields(a, b Task) (fields []string, err error) {
+	var before, after map[string]json.RawMessage
+	if before, err = taskFields(a); err != nil {
+		return
+	}
+	if after, err = taskFields(b); err != nil {
+		return
+	}
+
+	for key, value := range before {
+		if !bytes.Equal(value, after[key]) {
+			fields = append(fields, key)
+		}
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			fields = append(fields, key)
+		}
+	}
+
+	slices.Sort(fields)
+	return
+}
+
+// historyIgnoredFields are the fields left out of the change history, as
+// they change on every update or are histories themselves.
+var historyIgnoredFields = []string{"updated_at", "status_history", "history"}
+
+// recordHistory appends to the history of task a change for each field
+// that differs from old, dated at the update time of task.
+func recordHistory(old Task, task *Task) (err error) {
+	var before, after map[string]json.RawMessage
+	if before, err = taskFields(old); err != nil {
+		return
+	}
+	if after, err = taskFields(*task); err != nil {
+		return
+	}
+
+	var fields []string
+	if fields, err = changedFields(old, *task); err != nil {
+		return
+	}
+
+	history := slices.Clone(old.History)
+	for _, field := range fields {
+		if slices.Contains(historyIgnoredFields, field) {
+			continue
+		}
+		history = append(history, FieldChange{At: task.UpdatedAt, Field: field, Before: before[field], After: after[field]})
+	}
+	task.History = history
+	return
+}
+
+// taskFields returns the JSON encoding of each field of task by key.
+func taskFields(task Task) (fields map[string]json.RawMessage, err error) {
+	var data []byte
+	if data, err = json.Marshal(task); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &fields)
+	return
+}
+
+func (store *TaskStore) archivePath() string {
+	return strings.TrimSuffix(store.dbPath, path.Ext(store.dbPath)) + ".archive.json"
+}
+
+// OpenArchive loads the store holding the archived tasks, kept in its own
+// file next to the store file.
+func (store *TaskStore) OpenArchive() (archive *TaskStore, err error) {
+	return OpenTaskStore(store.archivePath())
+}
+
+// maxArchivedId returns the highest id of the archived tasks, 0 when there
+// are none.
+func (store *TaskStore) maxArchivedId() (id TaskId, err error) {
+	var archive *TaskStore
+	if archive, err = store.OpenArchive(); err != nil {
+		return
+	}
+
+	for _, task := range archive.Tasks {
+		id = max(id, task.Id)
+	}
+	return
+}
+
+// TaskTemplate holds the fields a task created from a template starts
+// with, saved from an existing task.
+type TaskTemplate struct {
+	Description string          `json:"description"`
+	Priority    TaskPriority    `json:"priority,omitempty"`
+	Tags        []string        `json:"tags,omitempty"`
+	Checklist   []ChecklistItem `json:"checklist,omitempty"`
+	Project     string          `json:"project,omitempty"`
+	Color       string          `json:"color,omitempty"`
+	Assignee    string          `json:"assignee,omitempty"`
+	Estimate    time.Duration   `json:"estimate,omitempty"`
+	Recurrence  string          `json:"recurrence,omitempty"`
+	Custom      map[string]any  `json:"custom,omitempty"`
+}
+
+// newTaskTemplate returns a template of task, with its checklist unticked.
+func newTaskTemplate(task Task) TaskTemplate {
+	checklist := slices.Clone(task.Checklist)
+	for i := range checklist {
+		checklist[i].Done = false
+	}
+
+	return TaskTemplate{
+		Description: task.Description,
+		Priority:    task.Priority,
+		Tags:        slices.Clone(task.Tags),
+		Checklist:   checklist,
+		Project:     task.Project,
+		Color:       task.Color,
+		Assignee:    task.Assignee,
+		Estimate:    task.Estimate,
+		Recurrence:  task.Recurrence,
+		Custom:      maps.Clone(task.Custom),
+	}
+}
+
+// Task returns a new task filled from the template.
+func (taskTemplate TaskTemplate) Task() Task {
+	return Task{
+		Description: taskTemplate.Description,
+		Priority:    taskTemplate.Priority,
+		Tags:        slices.Clone(taskTemplate.Tags),
+		Checklist:   slices.Clone(taskTemplate.Checklist),
+		Project:     taskTemplate.Project,
+		Color:       taskTemplate.Color,
+		Assignee:    taskTemplate.Assignee,
+		Estimate:    taskTemplate.Estimate,
+		Recurrence:  taskTemplate.Recurrence,
+		Custom:      maps.Clone(taskTemplate.Custom),
+	}
+}
+
+func (store *TaskStore) templatesPath() string {
+	return strings.TrimSuffix(store.dbPath, path.Ext(store.dbPath)) + ".templates.json"
+}
+
+// LoadTemplates reads the task templates, kept by name in their own file
+// next to the store file. There are none while the file does not exist.
+func (store *TaskStore) LoadTemplates() (templates map[string]TaskTemplate, err error) {
+	templates = make(map[string]TaskTemplate)
+
+	var data []byte
+	if data, err = os.ReadFile(store.templatesPath()); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	var file struct {
+		Templates map[string]TaskTemplate `json:"templates"`
+	}
+	if err = json.Unmarshal(data, &file); err != nil {
+		return
+	}
+	if file.Templates != nil {
+		templates = file.Templates
+	}
+	return
+}
+
+// SaveTemplates writes the task templates. They are not tasks, so a frozen
+// store still accepts them.
+func (store *TaskStore) SaveTemplates(templates map[string]TaskTemplate) (err error) {
+	var data []byte
+	if data, err = json.Marshal(map[string]any{"templates": templates}); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(path.Dir(store.templatesPath()), os.ModePerm); err != nil {
+		return
+	}
+
+	return os.WriteFile(store.templatesPath(), data, os.ModePerm)
+}
+
+// blobDir returns the directory attached files are copied to, next to the
+// store file.
+func (store *TaskStore) blobDir() string {
+	return strings.TrimSuffix(store.dbPath, path.Ext(store.dbPath)) + ".blobs"
+}
+
+// blobPath returns where the file of attachment is kept.
+func (store *TaskStore) blobPath(attachment Attachment) string {
+	return path.Join(store.blobDir(), attachment.Blob)
+}
+
+// AttachFile copies the file at file into the blob directory, named after
+// its SHA-256 so attaching the same file twice keeps a single copy, and
+// returns the attachment to add to a task.
+func (store *TaskStore) AttachFile(file string) (attachment Attachment, err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(file); err != nil {
+		return
+	}
+	if !info.Mode().IsRegular() {
+		err = ErrNotRegularFile
+		return
+	}
+
+	var data []byte
+	if data, err = os.ReadFile(file); err != nil {
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	attachment = Attachment{
+		Name:    path.Base(file),
+		Blob:    hex.EncodeToString(sum[:]) + path.Ext(file),
+		Size:    int64(len(data)),
+		AddedAt: time.Now(),
+	}
+
+	if err = os.MkdirAll(store.blobDir(), os.ModePerm); err != nil {
+		return
+	}
+	err = os.WriteFile(store.blobPath(attachment), data, 0o644)
+	return
+}
+
+// copyBlobs copies the files of attachments from the blob directory of
+// store into the one of target. The blobs are copied rather than moved as
+// other tasks of store may share them.
+func (store *TaskStore) copyBlobs(target *TaskStore, attachments []Attachment) (err error) {
+	for _, attachment := range attachments {
+		if attachment.Blob == "" {
+			continue
+		}
+
+		var data []byte
+		if data, err = os.ReadFile(store.blobPath(attachment)); err != nil {
+			return
+		}
+
+		if err = os.MkdirAll(target.blobDir(), os.ModePerm); err != nil {
+			return
+		}
+		if err = os.WriteFile(target.blobPath(attachment), data, 0o644); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// isURL tells whether value is an absolute URL rather than a file path.
+func isURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && parsed.Scheme != "" && parsed.Host != "" && len(parsed.Scheme) > 1
+}
+
+// formatSize writes a number of bytes with a binary unit, like 1.5 KiB.
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, unit := float64(size)/1024, "KiB"
+	for _, next := range []string{"MiB", "GiB", "TiB"} {
+		if value < 1024 {
+			break
+		}
+		value, unit = value/1024, next
+	}
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
+
+// OpenTaskStore loads the store at dbPath, which is empty if the file does
+// not exist yet.
+func OpenTaskStore(dbPath string) (store *TaskStore, err error) {
+	store = &TaskStore{dbPath: dbPath, Meta: TaskStoreMeta{CurrentId: 1}, Tasks: make([]Task, 0)}
+	err = store.Load()
+	return
+}
+
+// MoveTo moves the task with the given id into target, where it gets a new
+// id. The files attached to the task are copied into the blob directory of
+// target, then target is saved, so a failure leaves the task in the store
+// and at worst in both, but never in none.
+func (store *TaskStore) MoveTo(target *TaskStore, id TaskId) (moved Task, err error) {
+	if store.Meta.Frozen {
+		err = ErrStoreFrozen
+		return
+	}
+
+	var task Task
+	if task, err = store.GetById(id); err != nil {
+		return
+	}
+
+	if target.IndexByDescription(task.Description) != -1 {
+		err = ErrTaskAlreadyExists
+		return
+	}
+
+	moved = task
+	moved.Id = TaskId(target.Meta.CurrentId)
+	// the parent and the dependencies are left behind in the store, their
+	// ids would name other tasks in target
+	moved.ParentId = 0
+	moved.DependsOn = nil
+	moved.UpdatedAt = time.Now()
+
+	if err = store.copyBlobs(target, task.Attachments); err != nil {
+		return
+	}
+
+	target.Meta.CurrentId++
+	target.Tasks = append(target.Tasks, moved)
+	target.record(JournalOpCreate, moved)
+	if err = target.Save(); err != nil {
+		return
+	}
+
+	err = store.Delete(task)
+	return
+}
+
+// ArchiveDoneBefore moves the done tasks completed before t into the
+// archive, keeping their ids. The archive is saved first so a failure can
+// leave a task in both stores but never in none. With dryRun nothing is
+// moved, the tasks that would be are returned.
+func (store *TaskStore) ArchiveDoneBefore(t time.Time, dryRun bool) (archived []Task, err error) {
+	for _, task := range store.Tasks {
+		if completedAt, ok := task.completionTime(); ok && completedAt.Before(t) {
+			archived = append(archived, task)
+		}
+	}
+
+	if dryRun || len(archived) == 0 {
+		return
+	}
+
+	err = store.archiveTasks(archived)
+	return
+}
+
+// Archive moves the task with id into the archive whatever its status,
+// keeping its id.
+func (store *TaskStore) Archive(id TaskId) (task Task, err error) {
+	if task, err = store.GetById(id); err != nil {
+		return
+	}
+
+	err = store.archiveTasks([]Task{task})
+	return
+}
+
+// archiveTasks moves tasks into the archive. The archive is saved first so
+// a failure can leave a task in both stores but never in none.
+func (store *TaskStore) archiveTasks(archived []Task) (err error) {
+	if store.Meta.Frozen {
+		err = ErrStoreFrozen
+		return
+	}
+
+	var archive *TaskStore
+	if archive, err = store.OpenArchive(); err != nil {
+		return
+	}
+
+	// restore finds archived tasks by id, so ids must stay unique there
+	for _, task := range archived {
+		if archive.Index(task.Id) != -1 {
+			err = fmt.Errorf("%w: %d", ErrArchivedIdTaken, task.Id)
+			return
+		}
+	}
+
+	for _, task := range archived {
+		archive.Tasks = append(archive.Tasks, task)
+		archive.record(JournalOpCreate, task)
+	}
+
+	if err = archive.Save(); err != nil {
+		return
+	}
+
+	store.Tasks = slices.DeleteFunc(store.Tasks, func(task Task) bool {
+		if !slices.ContainsFunc(archived, func(v Task) bool { return v.Id == task.Id }) {
+			return false
+		}
+
+		store.record(JournalOpDelete, task)
+		return true
+	})
+	return store.Save()
+}
+
+// Restore moves the task with id from the archive back into the store. It
+// keeps its id unless a live task took it since, in which case it gets the
+// next one. The store is saved first so a failure can leave the task in
+// both stores but never in none.
+func (store *TaskStore) Restore(id TaskId) (task Task, err error) {
+	if store.Meta.Frozen {
+		err = ErrStoreFrozen
+		return
+	}
+
+	var archive *TaskStore
+	if archive, err = store.OpenArchive(); err != nil {
+		return
+	}
+
+	var archived Task
+	if archived, err = archive.GetById(id); err != nil {
+		return
+	}
+
+	if store.IndexByDescription(archived.Description) != -1 {
+		err = ErrTaskAlreadyExists
+		return
+	}
+
+	task = archived
+	if store.Index(task.Id) != -1 {
+		task.Id = TaskId(store.Meta.CurrentId)
+	}
+	store.Meta.CurrentId = max(store.Meta.CurrentId, uint64(task.Id)+1)
+
+	store.Tasks = append(store.Tasks, task)
+	store.record(JournalOpCreate, task)
+	if err = store.Save(); err != nil {
+		return
+	}
+
+	err = archive.Delete(archived)
+	return
+}
+
+// RenameTag replaces the tag oldTag with newTag on every task carrying it,
+// merging both on the tasks that already have newTag, and saves once. It
+// returns the number of tasks changed.
+func (store *TaskStore) RenameTag(oldTag, newTag string) (count int, err error) {
+	if oldTag == newTag {
+		return
+	}
+
+	now := time.Now()
+
+	for i := range store.Tasks {
+		task := &store.Tasks[i]
+
+		index := slices.Index(task.Tags, oldTag)
+		if index == -1 {
+			continue
+		}
+
+		old := *task
+		tags := slices.Clone(task.Tags)
+		tags[index] = newTag
+		task.Tags = normalizeTags(tags)
+		task.UpdatedAt = now
+		if err = recordHistory(old, task); err != nil {
+			return
+		}
+		store.record(JournalOpUpdate, *task)
+		count++
+	}
+
+	if count == 0 {
+		return
+	}
+
+	return count, store.Save()
+}
+
+// TagCounts returns how many tasks carry each tag.
+func (store *TaskStore) TagCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, task := range store.Tasks {
+		for _, tag := range task.Tags {
+			counts[tag]++
+		}
+	}
+	return counts
+}
+
+// validProject tells whether name can be used as a project, that is
+// whether it is a single word like tags.
+func validProject(name string) bool {
+	return validTag(name)
+}
+
+// ProjectCounts returns how many tasks are in each project.
+func (store *TaskStore) ProjectCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, task := range store.Tasks {
+		if task.Project != "" {
+			counts[task.Project]++
+		}
+	}
+	return counts
+}
+
+// MoveProject moves every task of the project from to the project to, an
+// empty to leaving them without project, and saves once. It returns the
+// number of tasks moved.
+func (store *TaskStore) MoveProject(from, to string) (count int, err error) {
+	if from == to {
+		return
+	}
+
+	now := time.Now()
+
+	for i := range store.Tasks {
+		task := &store.Tasks[i]
+		if task.Project != from {
+			continue
+		}
+
+		old := *task
+		task.Project = to
+		task.UpdatedAt = now
+		if err = recordHistory(old, task); err != nil {
+			return
+		}
+		store.record(JournalOpUpdate, *task)
+		count++
+	}
+
+	if count == 0 {
+		return
+	}
+
+	return count, store.Save()
+}
+
+// IdleSince returns the tasks not done yet that were last updated at least
+// d before now, the longest idle first.
+func (store *TaskStore) IdleSince(now time.Time, d time.Duration) (tasks []Task) {
+	cutoff := now.Add(-d)
+	for _, task := range store.Tasks {
+		if task.Status != TaskStatusDone && !task.UpdatedAt.After(cutoff) {
+			tasks = append(tasks, task)
+		}
+	}
+
+	slices.SortStableFunc(tasks, func(a, b Task) int {
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	})
+	return
+}
+
+type DayCount struct {
+	Day   time.Time
+	Count int
+}
+
+// CompletionsByDay counts the tasks completed on each of the last days
+// days, today included, oldest first. Days start at midnight in the zone
+// of now.
+func (store *TaskStore) CompletionsByDay(now time.Time, days int) []DayCount {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	counts := make([]DayCount, days)
+	for i := range counts {
+		counts[i].Day = today.AddDate(0, 0, i-days+1)
+	}
+
+	for _, task := range store.Tasks {
+		completedAt, ok := task.completionTime()
+		if !ok {
+			continue
+		}
+
+		completedAt = completedAt.In(now.Location())
+		day := time.Date(completedAt.Year(), completedAt.Month(), completedAt.Day(), 0, 0, 0, 0, now.Location())
+		if i := slices.IndexFunc(counts, func(count DayCount) bool { return count.Day.Equal(day) }); i != -1 {
+			counts[i].Count++
+		}
+	}
+
+	return counts
+}
+
+// cycleTimes returns the average and median time the done tasks took from
+// their creation to their completion, ok being false without done tasks.
+func cycleTimes(tasks []Task) (average, median time.Duration, ok bool) {
+	var durations []time.Duration
+	for _, task := range tasks {
+		if completedAt, done := task.completionTime(); done {
+			durations = append(durations, max(0, completedAt.Sub(task.CreatedAt)))
+		}
+	}
+
+	if len(durations) == 0 {
+		return
+	}
+
+	var total time.Duration
+	for _, duration := range durations {
+		total += duration
+	}
+	slices.Sort(durations)
+
+	return total / time.Duration(len(durations)), durations[len(durations)/2], true
+}
+
+func countByStatus(tasks []Task) map[TaskStatus]int {
+	counts := make(map[TaskStatus]int, len(taskStatusMapToString))
+	for _, task := range tasks {
+		counts[task.Status]++
+	}
+
+	return counts
+}
+
+// Search returns the tasks whose description, tags or notes contain query,
+// ignoring case, by id. With words set, the query only matches whole words.
+func (store *TaskStore) Search(query string, words bool) []Task {
+	query = strings.ToLower(query)
+	tasks := store.Filter(func(task Task) bool {
+		if searchMatch(task.Description, query, words) {
+			return true
+		}
+		for _, tag := range task.Tags {
+			if searchMatch(tag, query, words) {
+				return true
+			}
+		}
+		for _, note := range task.Notes {
+			if searchMatch(note.Text, query, words) {
+				return true
+			}
+		}
+		return false
+	})
+
+	slices.SortStableFunc(tasks, func(a, b Task) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	return tasks
+}
+
+// searchMatch tells whether text contains query, given in lower case,
+// ignoring case, and only as a whole word when words is set. It looks for
+// the query as a plain substring, without regular expressions, so searching
+// large stores stays fast.
+func searchMatch(text, query string, words bool) bool {
+	text = strings.ToLower(text)
+	if !words {
+		return strings.Contains(text, query)
+	}
+
+	for offset := 0; offset <= len(text); {
+		index := strings.Index(text[offset:], query)
+		if index == -1 {
+			return false
+		}
+		start := offset + index
+		if wholeWord(text, start, start+len(query)) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(text[start:])
+		offset = start + max(size, 1)
+	}
+	return false
+}
+
+// wholeWord tells whether the part of text between start and end is
+// neither preceded nor followed by a letter, a digit or an underscore.
+func wholeWord(text string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	after, _ := utf8.DecodeRuneInString(text[end:])
+	return !isWordRune(before) && !isWordRune(after)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func (store *TaskStore) ExportICS(w io.Writer) (err error) {
+	var ics strings.Builder
+	ics.WriteString("BEGIN:VCALENDAR\r\n")
+	ics.WriteString("VERSION:2.0\r\n")
+	ics.WriteString("PRODID:-//xeraph//task//EN\r\n")
+
+	for _, task := range store.Tasks {
+		ics.WriteString("BEGIN:VTODO\r\n")
+		writeICSLine(&ics, "UID:"+strconv.FormatUint(uint64(task.Id), 10)+"@task")
+		writeICSLine(&ics, "DTSTAMP:"+formatICSTime(task.UpdatedAt))
+		writeICSLine(&ics, "CREATED:"+formatICSTime(task.CreatedAt))
+		writeICSLine(&ics, "LAST-MODIFIED:"+formatICSTime(task.UpdatedAt))
+		writeICSLine(&ics, "SUMMARY:"+escapeICSText(task.Description))
+		writeICSLine(&ics, "STATUS:"+icsStatus(task.Status))
+		if task.DueAt != nil {
+			writeICSLine(&ics, "DUE:"+formatICSTime(*task.DueAt))
+		}
+		ics.WriteString("END:VTODO\r\n")
+	}
+
+	ics.WriteString("END:VCALENDAR\r\n")
+
+	_, err = io.WriteString(w, ics.String())
+	return
+}
+
+func icsStatus(status TaskStatus) string {
+	switch status {
+	case TaskStatusInProgress:
+		return "IN-PROCESS"
+	case TaskStatusDone:
+		return "COMPLETED"
+	default:
+		return "NEEDS-ACTION"
+	}
+}
+
+func formatICSTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+var icsTextEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	";", "\\;",
+	",", "\\,",
+	"\r\n", "\\n",
+	"\n", "\\n",
+)
+
+func escapeICSText(str string) string {
+	return icsTextEscaper.Replace(str)
+}
+
+// writeICSLine folds content lines longer than 75 octets as required by
+// RFC 5545, taking care not to split a multi-byte rune.
+func writeICSLine(b *strings.Builder, line string) {
+	// continuation lines start with a space, which counts towards the limit
+	maxLen := 75
+
+	for len(line) > maxLen {
+		cut := maxLen
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		maxLen = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// ExportTemplate writes the result of the Go template in file executed
+// with a copy of the store, giving it .Meta and .Tasks. Templates for HTML
+// files are run with html/template, escaping the task fields, and any
+// other with text/template. Errors tell the template line they come from.
+func (store *TaskStore) ExportTemplate(w io.Writer, file string, html bool) (err error) {
+	var data []byte
+	if data, err = os.ReadFile(file); err != nil {
+		return
+	}
+
+	// both template packages share this method, only parsing differs
+	var tmpl interface {
+		Execute(w io.Writer, data any) error
+	}
+	if html {
+		tmpl, err = htmltemplate.New(path.Base(file)).Parse(string(data))
+	} else {
+		tmpl, err = template.New(path.Base(file)).Parse(string(data))
+	}
+	if err != nil {
+		return
+	}
+
+	// a copy, so templates cannot reach the methods changing the store
+	return tmpl.Execute(w, struct {
+		Meta  TaskStoreMeta
+		Tasks []Task
+	}{store.Meta, slices.Clone(store.Tasks)})
+}
+
+// sqlTasksTable is the schema of the table ExportSQL fills. Timestamps are
+// ISO 8601 text and tags are joined with commas.
+const sqlTasksTable = `CREATE TABLE IF NOT EXISTS tasks (
+	id INTEGER PRIMARY KEY,
+	description TEXT NOT NULL,
+	status TEXT NOT NULL,
+	color TEXT,
+	parent_id INTEGER,
+	due_at TEXT,
+	tags TEXT,
+	assignee TEXT,
+	pinned INTEGER NOT NULL DEFAULT 0,
+	priority TEXT NOT NULL DEFAULT 'medium',
+	project TEXT,
+	depends_on TEXT,
+	recurrence TEXT,
+	remind_at TEXT,
+	wait_until TEXT,
+	completed_at TEXT,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);
+`
+
+// ExportSQL writes the tasks as a SQL script creating and filling a tasks
+// table, in a single transaction, meant to be run by sqlite3.
+func (store *TaskStore) ExportSQL(w io.Writer) (err error) {
+	var script strings.Builder
+	script.WriteString("BEGIN TRANSACTION;\n")
+	script.WriteString(sqlTasksTable)
+
+	for _, task := range store.Tasks {
+		values := []string{
+			strconv.FormatUint(uint64(task.Id), 10),
+			sqlText(task.Description),
+			sqlText(task.Status.String()),
+			sqlNullText(task.Color),
+			"NULL",
+			"NULL",
+			sqlNullText(strings.Join(task.Tags, ",")),
+			sqlNullText(task.Assignee),
+			"0",
+			sqlText(task.Priority.String()),
+			sqlNullText(task.Project),
+			sqlNullText(joinIds(normalizeIds(task.DependsOn))),
+			sqlNullText(task.Recurrence),
+			"NULL",
+			"NULL",
+			"NULL",
+			sqlText(task.CreatedAt.Format(time.RFC3339Nano)),
+			sqlText(task.UpdatedAt.Format(time.RFC3339Nano)),
+		}
+		if task.ParentId != 0 {
+			values[4] = strconv.FormatUint(uint64(task.ParentId), 10)
+		}
+		if task.DueAt != nil {
+			values[5] = sqlText(task.DueAt.Format(time.RFC3339Nano))
+		}
+		if task.Pinned {
+			values[8] = "1"
+		}
+		if task.RemindAt != nil {
+			values[13] = sqlText(task.RemindAt.Format(time.RFC3339Nano))
+		}
+		if task.WaitUntil != nil {
+			values[14] = sqlText(task.WaitUntil.Format(time.RFC3339Nano))
+		}
+		if task.CompletedAt != nil {
+			values[15] = sqlText(task.CompletedAt.Format(time.RFC3339Nano))
+		}
+
+		script.WriteString("INSERT INTO tasks (id, description, status, color, parent_id, due_at, tags, assignee, pinned, priority, project, depends_on, recurrence, remind_at, wait_until, completed_at, created_at, updated_at) VALUES (")
+		script.WriteString(strings.Join(values, ", "))
+		script.WriteString(");\n")
+	}
+
+	script.WriteString("COMMIT;\n")
+
+	_, err = io.WriteString(w, script.String())
+	return
+}
+
+// sqlText quotes str as a SQL string literal, doubling its single quotes.
+func sqlText(str string) string {
+	return "'" + strings.ReplaceAll(str, "'", "''") + "'"
+}
+
+// sqlNullText is sqlText, except that an empty str is NULL.
+func sqlNullText(str string) string {
+	if str == "" {
+		return "NULL"
+	}
+	return sqlText(str)
+}
+
+// joinIds joins ids with commas, as the tags are in the SQL export.
+func joinIds(ids []TaskId) string {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(strs, ",")
+}
+
+// parseFlags parses flags interleaved with positional arguments, so flags
+// may be given before, between or after them. A bare "--" is kept as a
+// positional argument instead of ending flag parsing.
+func parseFlags(flags *flag.FlagSet, args []string) (positional []string, err error) {
+	flags.SetOutput(io.Discard)
+
+	for len(args) > 0 {
+		if args[0] == "--" || args[0] == "-" || !strings.HasPrefix(args[0], "-") {
+			positional = append(positional, args[0])
+			args = args[1:]
+			continue
+		}
+
+		end := slices.Index(args, "--")
+		if end == -1 {
+			end = len(args)
+		}
+
+		if err = flags.Parse(args[:end]); err != nil {
+			return
+		}
+		args = args[end-flags.NArg():]
+	}
+
+	return
+}
+
+// flagPassed tells whether the flag called name was given on the command
+// line, as opposed to keeping its default value.
+func flagPassed(flags *flag.FlagSet, name string) (passed bool) {
+	flags.Visit(func(f *flag.Flag) {
+		passed = passed || f.Name == name
+	})
+	return
+}
+
+const configFileName = ".taskrc"
+
+// Config holds the user settings. Each setting is resolved, from lowest to
+// highest precedence, from its default, the .taskrc file in the user
+// configuration directory, the .taskrc file in the working directory, its
+// TASK_<KEY> environment variable and its --<key> command line option.
+type Config struct {
+	DB              string
+	Sort            string
+	Color           string
+	DefaultStatus   string
+	TimeFormat      string
+	DescWidth       int
+	Locale          string
+	DefaultAssignee string
+	Fields          map[string]string
+}
+
+var configKeys = []string{"db", "sort", "color", "default_status", "time_format", "desc_width", "locale", "default_assignee"}
+
+// localeTimeLayouts holds the timestamp layout of the locales known to the
+// locale setting, keyed by locale and by language for the locales whose
+// layout does not depend on the region.
+var localeTimeLayouts = map[string]string{
+	"C":     time.DateTime,
+	"POSIX": time.DateTime,
+	"en_US": "01/02/2006 03:04:05 PM",
+	"en_CA": time.DateTime,
+	"en_GB": "02/01/2006 15:04:05",
+	"en_AU": "02/01/2006 15:04:05",
+	"en_IN": "02/01/2006 15:04:05",
+	"fr":    "02/01/2006 15:04:05",
+	"es":    "02/01/2006 15:04:05",
+	"it":    "02/01/2006 15:04:05",
+	"pt":    "02/01/2006 15:04:05",
+	"de":    "02.01.2006 15:04:05",
+	"ru":    "02.01.2006 15:04:05",
+	"pl":    "02.01.2006 15:04:05",
+	"nl":    "02-01-2006 15:04:05",
+	"sv":    time.DateTime,
+	"ja":    "2006/01/02 15:04:05",
+	"zh":    "2006/01/02 15:04:05",
+	"ko":    "2006. 01. 02. 15:04:05",
+}
+
+// localeTimeLayout returns the timestamp layout of locale, given as in
+// $LANG such as en_GB.UTF-8 or as a language tag such as en-GB.
+func localeTimeLayout(locale string) (layout string, ok bool) {
+	locale, _, _ = strings.Cut(locale, ".")
+	locale = strings.ReplaceAll(locale, "-", "_")
+	if layout, ok = localeTimeLayouts[locale]; ok {
+		return
+	}
+
+	language, _, _ := strings.Cut(locale, "_")
+	layout, ok = localeTimeLayouts[strings.ToLower(language)]
+	return
+}
+
+// TimeLayout returns the layout of the timestamps shown to the user: the
+// time_format setting, or the layout of the locale setting while
+// time_format is left to its default.
+func (config Config) TimeLayout() string {
+	if config.TimeFormat != time.DateTime || config.Locale == "" {
+		return config.TimeFormat
+	}
+	if layout, ok := localeTimeLayout(config.Locale); ok {
+		return layout
+	}
+	return config.TimeFormat
+}
+
+var configColors = []string{"auto", "always", "never"}
+
+// customFieldTypes are the types a custom field can be declared with.
+var customFieldTypes = []string{"string", "int", "date"}
+
+// fieldConfigPrefix starts the config keys declaring custom fields.
+const fieldConfigPrefix = "field."
+
+// CustomValue parses value as the custom field name, returning what is
+// stored: a string for string fields and dates, written as YYYY-MM-DD,
+// and an int64 for int fields.
+func (config Config) CustomValue(name, value string) (parsed any, err error) {
+	switch config.Fields[name] {
+	case "string":
+		parsed = value
+	case "int":
+		if parsed, err = strconv.ParseInt(value, 10, 64); err != nil {
+			err = fmt.Errorf("%w for %s: %q is not an int", ErrInvalidFieldValue, name, value)
+		}
+	case "date":
+		if _, err = time.Parse(time.DateOnly, value); err != nil {
+			err = fmt.Errorf("%w for %s: %q is not a YYYY-MM-DD date", ErrInvalidFieldValue, name, value)
+		}
+		parsed = value
+	default:
+		err = ErrUnknownField
+	}
+	return
+}
+
+// formatCustomValue writes a stored custom value, numbers read back from
+// the store being float64s that must still print as ints.
+func formatCustomValue(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func defaultConfig() Config {
+	return Config{
+		Sort:          "id",
+		Color:         "auto",
+		DefaultStatus: TaskStatusTodo.String(),
+		TimeFormat:    time.DateTime,
+		DescWidth:     maxDescriptionWidth,
+	}
+}
+
+// Set validates value and assigns it to the setting named key.
+func (config *Config) Set(key, value string) (err error) {
+	switch key {
+	case "db":
+		config.DB = value
+	case "sort":
+		if _, ok := taskSortKey(value); !ok {
+			err = ErrInvalidSortKey
+			return
+		}
+		config.Sort = value
+	case "color":
+		if !slices.Contains(configColors, value) {
+			err = ErrInvalidColorSetting
+			return
+		}
+		config.Color = value
+	case "default_status":
+		if !NewTaskStatus(value).Valid() {
+			err = ErrInvalidTaskStatus
+			return
+		}
+		config.DefaultStatus = value
+	case "time_format":
+		if value == "" {
+			err = ErrEmptyTimeFormat
+			return
+		}
+		config.TimeFormat = value
+	case "desc_width":
+		var width int
+		if width, err = strconv.Atoi(value); err != nil || width < 0 {
+			err = ErrInvalidDescWidth
+			return
+		}
+		config.DescWidth = width
+	case "locale":
+		// unknown locales are only warned about, see TimeLayout
+		config.Locale = value
+	case "default_assignee":
+		config.DefaultAssignee = strings.TrimSpace(value)
+	default:
+		name, isField := strings.CutPrefix(key, fieldConfigPrefix)
+		if !isField || !validTag(name) {
+			err = ErrUnknownConfigKey
+			return
+		}
+		if !slices.Contains(customFieldTypes, value) {
+			err = ErrInvalidFieldType
+			return
+		}
+		// copies of the config share the map until one of them sets a field
+		config.Fields = maps.Clone(config.Fields)
+		if config.Fields == nil {
+			config.Fields = make(map[string]string)
+		}
+		config.Fields[name] = value
+	}
+
+	return
+}
+
+func (config Config) Get(key string) (value string, err error) {
+	switch key {
+	case "db":
+		value = config.DB
+	case "sort":
+		value = config.Sort
+	case "color":
+		value = config.Color
+	case "default_status":
+		value = config.DefaultStatus
+	case "time_format":
+		value = config.TimeFormat
+	case "desc_width":
+		value = strconv.Itoa(config.DescWidth)
+	case "locale":
+		value = config.Locale
+	case "default_assignee":
+		value = config.DefaultAssignee
+	default:
+		name, isField := strings.CutPrefix(key, fieldConfigPrefix)
+		var ok bool
+		if value, ok = config.Fields[name]; !isField || !ok {
+			err = ErrUnknownConfigKey
+		}
+	}
+
+	return
+}
+
+// userConfigPath returns the path of the .taskrc file in the task data
+// directory, the one written by the config command.
+func userConfigPath() (file string, err error) {
+	var dir string
+	if dir, err = os.UserConfigDir(); err != nil {
+		return
+	}
+
+	return path.Join(dir, appName(), configFileName), nil
+}
+
+// loadConfig resolves the settings from the config files and environment.
+// Command line options are applied on top of it by the caller.
+func loadConfig() (config Config, err error) {
+	config = defaultConfig()
+
+	var userFile string
+	if userFile, err = userConfigPath(); err != nil {
+		return
+	}
+
+	for _, file := range []string{userFile, configFileName} {
+		if err = config.readFile(file); err != nil {
+			return
+		}
+	}
+
+	for _, key := range configKeys {
+		if value, ok := os.LookupEnv("TASK_" + strings.ToUpper(key)); ok {
+			if err = config.Set(key, value); err != nil {
+				err = fmt.Errorf("TASK_%s: %w", strings.ToUpper(key), err)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// readFile applies the settings of a config file made of `key = value`
+// lines, where blank lines and lines starting with # are ignored. A
+// missing file is not an error.
+func (config *Config) readFile(file string) (err error) {
+	var data []byte
+	if data, err = os.ReadFile(file); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			err = fmt.Errorf("%s:%d: %w", file, i+1, ErrInvalidConfigLine)
+			return
+		}
+
+		if err = config.Set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			err = fmt.Errorf("%s:%d: %w", file, i+1, err)
+			return
+		}
+	}
+
+	return
+}
+
+// writeConfigFileValue sets key to value in a config file, replacing the
+// line of the key if any and keeping every other line as is.
+func writeConfigFileValue(file, key, value string) (err error) {
+	var data []byte
+	if data, err = os.ReadFile(file); err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	line := key + " = " + value
+	index := slices.IndexFunc(lines, func(line string) bool {
+		lineKey, _, ok := strings.Cut(line, "=")
+		return ok && strings.TrimSpace(lineKey) == key && !strings.HasPrefix(strings.TrimSpace(line), "#")
+	})
+	if index == -1 {
+		lines = append(lines, line)
+	} else {
+		lines[index] = line
+	}
+
+	if err = os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		return
+	}
+
+	return os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), os.ModePerm)
+}
+
+// ColorEnabled tells whether output written to w should be colored.
+func (config Config) ColorEnabled(w io.Writer) bool {
+	switch config.Color {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	return isTerminal(w)
+}
+
+// isTerminal tells whether stream, a reader or writer, is a terminal.
+func isTerminal(stream any) bool {
+	file, ok := stream.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+type GlobalOptions struct {
+	Output  string
+	Verbose bool
+	// Config holds the settings given on the command line by key.
+	Config map[string]string
+}
+
+// parseGlobalOptions extracts the options shared by every command from
+// anywhere in args, returning the remaining arguments untouched. Every
+// config key is an option too, with underscores replaced by dashes.
+func parseGlobalOptions(args []string) (options GlobalOptions, rest []string, err error) {
+	options.Config = make(map[string]string)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		configKey := strings.ReplaceAll(name, "-", "_")
+
+		switch {
+		case !strings.HasPrefix(arg, "-"):
+			rest = append(rest, arg)
+		case name == "verbose" && !hasValue:
+			options.Verbose = true
+		case name == "output" || slices.Contains(configKeys, configKey):
+			if !hasValue {
+				if i+1 == len(args) {
+					err = fmt.Errorf("--%s: %w", name, ErrOptionNeedsValue)
+					return
+				}
+				i++
+				value = args[i]
+			}
+
+			if name == "output" {
+				options.Output = value
+			} else {
+				options.Config[configKey] = value
+			}
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	return
+}
+
+// IO holds the streams commands read from and write to, so they never
+// touch os.Stdin, os.Stdout or os.Stderr directly.
+type IO struct {
+	In  io.Reader
+	Out io.Writer
+	Err io.Writer
+}
+
+type viewOptions struct {
+	TimeEpoch bool
+	// RawTime writes the timestamps with the offset they are stored with,
+	// instead of in UTC.
+	RawTime bool
+	Camel   bool
+	// Page, when set, wraps the tasks in an envelope telling which part of
+	// the whole list they are.
+	Page *PageInfo
+	// Schema wraps the tasks in an envelope whose "$schema" field names the
+	// schema printed by the schema command.
+	Schema bool
+	// Meta, when set, wraps the tasks in an envelope holding the next id of
+	// the store.
+	Meta *TaskStoreMeta
+	// Archived, when not nil, wraps the tasks in an envelope listing these
+	// archived tasks in an array of their own.
+	Archived []Task
+	// Compact leaves out the optional fields of the tasks that are unset,
+	// instead of writing them with their zero value.
+	Compact bool
+}
+
+type PageInfo struct {
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// taskPage is the JSON envelope of a page of tasks.
+type taskPage struct {
+	Schema   string `json:"$schema,omitempty"`
+	Meta     any    `json:"meta,omitempty"`
+	Tasks    any    `json:"tasks"`
+	Archived any    `json:"archived,omitempty"`
+	*PageInfo
+}
+
+// viewTime is a timestamp in the command output, encoded either as an
+// RFC 3339 string, keeping the offset the time was stored with, or as Unix
+// seconds. The zero time, standing for an unset one, is encoded as null.
+type viewTime struct {
+	time.Time
+	epoch bool
+}
+
+// time returns the view of t, normalized to UTC unless the raw time is
+// asked for.
+func (options viewOptions) time(t time.Time) viewTime {
+	if !options.RawTime {
+		t = t.UTC()
+	}
+	return viewTime{t, options.TimeEpoch}
+}
+
+func (t viewTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	if t.epoch {
+		return strconv.AppendInt(nil, t.Unix(), 10), nil
+	}
+	return t.Time.MarshalJSON()
+}
+
+// taskView is how a task is shown in the JSON output. It is kept apart
+// from Task so the output can change without touching the stored format.
+// Being a struct, its keys always come out in the same order, and its
+// tags are sorted, so the same tasks always give the same bytes. Optional
+// fields are pointers, left nil to be omitted from compact output.
+type taskView struct {
+	Id            TaskId               `json:"id"`
+	Description   string               `json:"description"`
+	Status        string               `json:"status"`
+	StatusHistory *[]statusChangeView  `json:"status_history,omitempty"`
+	Color         *string              `json:"color,omitempty"`
+	ParentId      *TaskId              `json:"parent_id,omitempty"`
+	DueAt         *viewTime            `json:"due_at,omitempty"`
+	Tags          *[]string            `json:"tags,omitempty"`
+	Assignee      *string              `json:"assignee,omitempty"`
+	Pinned        *bool                `json:"pinned,omitempty"`
+	Priority      *string              `json:"priority,omitempty"`
+	Project       *string              `json:"project,omitempty"`
+	DependsOn     *[]TaskId            `json:"depends_on,omitempty"`
+	Notes         *[]noteView          `json:"notes,omitempty"`
+	Recurrence    *string              `json:"recurrence,omitempty"`
+	RemindAt      *viewTime            `json:"remind_at,omitempty"`
+	Sessions      *[]sessionView       `json:"sessions,omitempty"`
+	Estimate      *int64               `json:"estimate,omitempty"`
+	Custom        *map[string]any      `json:"custom,omitempty"`
+	Attachments   *[]attachmentView    `json:"attachments,omitempty"`
+	Checklist     *[]checklistItemView `json:"checklist,omitempty"`
+	WaitUntil     *viewTime            `json:"wait_until,omitempty"`
+	CompletedAt   *viewTime            `json:"completed_at,omitempty"`
+	History       *[]fieldChangeView   `json:"history,omitempty"`
+	CreatedAt     viewTime             `json:"created_at"`
+	UpdatedAt     viewTime             `json:"updated_at"`
+}
+
+// statusChangeView is how a status change is shown in the JSON output.
+type statusChangeView struct {
+	Status string   `json:"status"`
+	At     viewTime `json:"at"`
+	Note   string   `json:"note,omitempty"`
+}
+
+// noteView is how a note is shown in the JSON output, its key following
+// the case of the task keys.
+type noteView struct {
+	Text      string
+	CreatedAt viewTime
+	camel     bool
+}
+
+func (note noteView) MarshalJSON() ([]byte, error) {
+	if note.camel {
+		return json.Marshal(struct {
+			Text      string   `json:"text"`
+			CreatedAt viewTime `json:"createdAt"`
+		}{note.Text, note.CreatedAt})
+	}
+	return json.Marshal(struct {
+		Text      string   `json:"text"`
+		CreatedAt viewTime `json:"created_at"`
+	}{note.Text, note.CreatedAt})
+}
+
+// sessionView is how a session of tracked time is shown in the JSON
+// output, without end while it is still being tracked.
+type sessionView struct {
+	Start viewTime `json:"start"`
+	End   viewTime `json:"end"`
+}
+
+// attachmentView is how an attachment is shown in the JSON output, its
+// keys following the case of the task keys.
+type attachmentView struct {
+	Name    string
+	URL     string
+	Blob    string
+	Size    int64
+	AddedAt viewTime
+	camel   bool
+}
+
+func (attachment attachmentView) MarshalJSON() ([]byte, error) {
+	if attachment.camel {
+		return json.Marshal(struct {
+			Name    string   `json:"name"`
+			URL     string   `json:"url,omitempty"`
+			Blob    string   `json:"blob,omitempty"`
+			Size    int64    `json:"size,omitempty"`
+			AddedAt viewTime `json:"addedAt"`
+		}{attachment.Name, attachment.URL, attachment.Blob, attachment.Size, attachment.AddedAt})
+	}
+	return json.Marshal(struct {
+		Name    string   `json:"name"`
+		URL     string   `json:"url,omitempty"`
+		Blob    string   `json:"blob,omitempty"`
+		Size    int64    `json:"size,omitempty"`
+		AddedAt viewTime `json:"added_at"`
+	}{attachment.Name, attachment.URL, attachment.Blob, attachment.Size, attachment.AddedAt})
+}
+
+// checklistItemView is how a checklist item is shown in the JSON output.
+type checklistItemView struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// fieldChangeView is how a change of a field is shown in the JSON output,
+// the field being named by its key in the store file.
+type fieldChangeView struct {
+	At     viewTime        `json:"at"`
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// camelTaskView is taskView with camelCase keys. Both must keep the same
+// fields so one can be converted into the other.
+type camelTaskView struct {
+	Id            TaskId               `json:"id"`
+	Description   string               `json:"description"`
+	Status        string               `json:"status"`
+	StatusHistory *[]statusChangeView  `json:"statusHistory,omitempty"`
+	Color         *string              `json:"color,omitempty"`
+	ParentId      *TaskId              `json:"parentId,omitempty"`
+	DueAt         *viewTime            `json:"dueAt,omitempty"`
+	Tags          *[]string            `json:"tags,omitempty"`
+	Assignee      *string              `json:"assignee,omitempty"`
+	Pinned        *bool                `json:"pinned,omitempty"`
+	Priority      *string              `json:"priority,omitempty"`
+	Project       *string              `json:"project,omitempty"`
+	DependsOn     *[]TaskId            `json:"dependsOn,omitempty"`
+	Notes         *[]noteView          `json:"notes,omitempty"`
+	Recurrence    *string              `json:"recurrence,omitempty"`
+	RemindAt      *viewTime            `json:"remindAt,omitempty"`
+	Sessions      *[]sessionView       `json:"sessions,omitempty"`
+	Estimate      *int64               `json:"estimate,omitempty"`
+	Custom        *map[string]any      `json:"custom,omitempty"`
+	Attachments   *[]attachmentView    `json:"attachments,omitempty"`
+	Checklist     *[]checklistItemView `json:"checklist,omitempty"`
+	WaitUntil     *viewTime            `json:"waitUntil,omitempty"`
+	CompletedAt   *viewTime            `json:"completedAt,omitempty"`
+	History       *[]fieldChangeView   `json:"history,omitempty"`
+	CreatedAt     viewTime             `json:"createdAt"`
+	UpdatedAt     viewTime             `json:"updatedAt"`
+}
+
+// newTaskView builds the view of task and is the one place slice fields
+// get a stable order, whatever the order they are stored in: the tags and
+// dependencies are sets, sorted and deduplicated, while the histories,
+// notes, sessions, attachments and checklist keep the order they were
+// added in, which means something.
+func newTaskView(task Task, options viewOptions) (view taskView) {
+	view = taskView{
+		Id:          task.Id,
+		Description: task.Description,
+		Status:      task.Status.String(),
+		CreatedAt:   options.time(task.CreatedAt),
+		UpdatedAt:   options.time(task.UpdatedAt),
+	}
+
+	// unset fields are written with their zero value, or null for the due
+	// date, unless the output is compact
+	full := !options.Compact
+	if len(task.StatusHistory) != 0 || full {
+		history := make([]statusChangeView, 0, len(task.StatusHistory))
+		for _, change := range task.StatusHistory {
+			history = append(history, statusChangeView{change.Status.String(), options.time(change.At), change.Note})
+		}
+		view.StatusHistory = &history
+	}
+	if task.Color != "" || full {
+		view.Color = &task.Color
+	}
+	if task.ParentId != 0 || full {
+		view.ParentId = &task.ParentId
+	}
+	if task.DueAt != nil {
+		dueAt := options.time(*task.DueAt)
+		view.DueAt = &dueAt
+	} else if full {
+		view.DueAt = &viewTime{}
+	}
+	if tags := normalizeTags(task.Tags); len(tags) != 0 || full {
+		if tags == nil {
+			tags = []string{}
+		}
+		view.Tags = &tags
+	}
+	if task.Assignee != "" || full {
+		view.Assignee = &task.Assignee
+	}
+	if task.Pinned || full {
+		view.Pinned = &task.Pinned
+	}
+	if task.Priority != TaskPriorityMedium || full {
+		priority := task.Priority.String()
+		view.Priority = &priority
+	}
+	if task.Project != "" || full {
+		view.Project = &task.Project
+	}
+	if dependsOn := normalizeIds(task.DependsOn); len(dependsOn) != 0 || full {
+		if dependsOn == nil {
+			dependsOn = []TaskId{}
+		}
+		view.DependsOn = &dependsOn
+	}
+	if len(task.Notes) != 0 || full {
+		notes := make([]noteView, 0, len(task.Notes))
+		for _, note := range task.Notes {
+			notes = append(notes, noteView{note.Text, options.time(note.CreatedAt), options.Camel})
+		}
+		view.Notes = &notes
+	}
+	if task.Recurrence != "" || full {
+		view.Recurrence = &task.Recurrence
+	}
+	if task.RemindAt != nil {
+		remindAt := options.time(*task.RemindAt)
+		view.RemindAt = &remindAt
+	} else if full {
+		view.RemindAt = &viewTime{}
+	}
+	if len(task.Sessions) != 0 || full {
+		sessions := make([]sessionView, 0, len(task.Sessions))
+		for _, session := range task.Sessions {
+			var end viewTime
+			if session.End != nil {
+				end = options.time(*session.End)
+			}
+			sessions = append(sessions, sessionView{options.time(session.Start), end})
+		}
+		view.Sessions = &sessions
+	}
+	if task.Estimate != 0 || full {
+		// whole seconds, like --time-epoch timestamps
+		estimate := int64(task.Estimate / time.Second)
+		view.Estimate = &estimate
+	}
+	if len(task.Custom) != 0 || full {
+		custom := task.Custom
+		if custom == nil {
+			custom = map[string]any{}
+		}
+		view.Custom = &custom
+	}
+	if len(task.Attachments) != 0 || full {
+		attachments := make([]attachmentView, 0, len(task.Attachments))
+		for _, attachment := range task.Attachments {
+			attachments = append(attachments, attachmentView{
+				Name:    attachment.Name,
+				URL:     attachment.URL,
+				Blob:    attachment.Blob,
+				Size:    attachment.Size,
+				AddedAt: options.time(attachment.AddedAt),
+				camel:   options.Camel,
+			})
+		}
+		view.Attachments = &attachments
+	}
+	if len(task.Checklist) != 0 || full {
+		checklist := make([]checklistItemView, 0, len(task.Checklist))
+		for _, item := range task.Checklist {
+			checklist = append(checklist, checklistItemView(item))
+		}
+		view.Checklist = &checklist
+	}
+	if task.WaitUntil != nil {
+		waitUntil := options.time(*task.WaitUntil)
+		view.WaitUntil = &waitUntil
+	} else if full {
+		view.WaitUntil = &viewTime{}
+	}
+	if task.CompletedAt != nil {
+		completedAt := options.time(*task.CompletedAt)
+		view.CompletedAt = &completedAt
+	} else if full {
+		view.CompletedAt = &viewTime{}
+	}
+	if len(task.History) != 0 || full {
+		history := make([]fieldChangeView, 0, len(task.History))
+		for _, change := range task.History {
+			history = append(history, fieldChangeView{options.time(change.At), change.Field, change.Before, change.After})
+		}
+		view.History = &history
+	}
+	return
+}
+
+// jsonTaskView returns the value a task is encoded as in the JSON output.
+func jsonTaskView(task Task, options viewOptions) any {
+	if options.Camel {
+		return camelTaskView(newTaskView(task, options))
+	}
+	return newTaskView(task, options)
+}
+
+func writeTasksJSON(w io.Writer, tasks []Task, options viewOptions) error {
+	taskViews := make([]any, 0, len(tasks))
+	for _, task := range tasks {
+		taskViews = append(taskViews, jsonTaskView(task, options))
+	}
+
+	var views any = taskViews
+
+	if options.Page != nil || options.Schema || options.Meta != nil || options.Archived != nil {
+		page := taskPage{Tasks: taskViews, PageInfo: options.Page}
+		if options.Archived != nil {
+			archivedViews := make([]any, 0, len(options.Archived))
+			for _, task := range options.Archived {
+				archivedViews = append(archivedViews, jsonTaskView(task, options))
+			}
+			page.Archived = archivedViews
+		}
+		if options.Schema {
+			page.Schema = taskListSchemaId(options.Camel)
+		}
+		if options.Meta != nil {
+			key := "current_id"
+			if options.Camel {
+				key = "currentId"
+			}
+			page.Meta = map[string]uint64{key: options.Meta.CurrentId}
+		}
+		views = page
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(views)
+}
+
+// taskListSchemaId returns the identifier of the schema of the JSON output
+// of list, as printed by the schema command.
+func taskListSchemaId(camel bool) string {
+	if camel {
+		return "urn:task:schema:list:camel"
+	}
+	return "urn:task:schema:list"
+}
+
+// taskListSchema returns the JSON Schema of the JSON output of list, in
+// either its bare array or its envelope form.
+func taskListSchema(camel bool) map[string]any {
+	key := func(snake, camelCase string) string {
+		if camel {
+			return camelCase
+		}
+		return snake
+	}
+
+	timestamp := map[string]any{
+		"type":        []string{"string", "integer"},
+		"description": "RFC 3339 timestamp, or Unix seconds with --time-epoch",
+	}
+
+	task := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"id":          map[string]any{"type": "integer", "minimum": 1},
+			"description": map[string]any{"type": "string"},
+			"status":      map[string]any{"enum": slices.Sorted(maps.Keys(taskStatusMapFromString))},
+			key("status_history", "statusHistory"): map[string]any{
+				"type":        "array",
+				"description": "the status changes, oldest first",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"status": map[string]any{"enum": slices.Sorted(maps.Keys(taskStatusMapFromString))},
+						"at":     timestamp,
+						"note":   map[string]any{"type": "string"},
+					},
+					"required": []string{"status", "at"},
+				},
+			},
+			"color":                        map[string]any{"enum": append([]string{""}, slices.Sorted(maps.Keys(taskColorsMap))...)},
+			key("parent_id", "parentId"):   map[string]any{"type": "integer", "minimum": 0, "description": "0 when the task has no parent"},
+			"assignee":                     map[string]any{"type": "string"},
+			"pinned":                       map[string]any{"type": "boolean"},
+			"priority":                     map[string]any{"enum": slices.Sorted(maps.Keys(taskPriorityMapFromString))},
+			"project":                      map[string]any{"type": "string"},
+			key("depends_on", "dependsOn"): map[string]any{"type": "array", "items": map[string]any{"type": "integer", "minimum": 1}},
+			"custom":                       map[string]any{"type": "object", "description": "the custom fields declared in the config, by name"},
+			"attachments": map[string]any{
+				"type":        "array",
+				"description": "the attached URLs and files, the files being named after their content in the blob directory, in the order they were added",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"name":                     map[string]any{"type": "string"},
+						"url":                      map[string]any{"type": "string"},
+						"blob":                     map[string]any{"type": "string"},
+						"size":                     map[string]any{"type": "integer", "minimum": 0},
+						key("added_at", "addedAt"): timestamp,
+					},
+					"required": []string{"name", key("added_at", "addedAt")},
+				},
+			},
+			"checklist": map[string]any{
+				"type":        "array",
+				"description": "the steps of the task, in the order they were added",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"text": map[string]any{"type": "string"},
+						"done": map[string]any{"type": "boolean"},
+					},
+					"required": []string{"text", "done"},
+				},
+			},
+			"notes": map[string]any{
+				"type":        "array",
+				"description": "the notes, in the order they were added",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"text":                         map[string]any{"type": "string"},
+						key("created_at", "createdAt"): timestamp,
+					},
+					"required": []string{"text", key("created_at", "createdAt")},
+				},
+			},
+			key("wait_until", "waitUntil"):     map[string]any{"type": []string{"string", "integer", "null"}, "description": "like the other timestamps, null when the task is not waiting"},
+			key("completed_at", "completedAt"): map[string]any{"type": []string{"string", "integer", "null"}, "description": "like the other timestamps, null unless the task is done"},
+			key("remind_at", "remindAt"):       map[string]any{"type": []string{"string", "integer", "null"}, "description": "like the other timestamps, null when no reminder is pending"},
+			"history": map[string]any{
+				"type":        "array",
+				"description": "the changes made to the fields of the task, oldest first",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"at":     timestamp,
+						"field":  map[string]any{"type": "string", "description": "the key of the field in the store file"},
+						"before": map[string]any{"description": "the value before the change as stored, null when unset"},
+						"after":  map[string]any{"description": "the value after the change as stored, null when unset"},
+					},
+					"required": []string{"at", "field", "before", "after"},
+				},
+			},
+			"recurrence": map[string]any{"type": "string", "description": "a count and d, w, m or y, empty when the task does not recur"},
+			"estimate":   map[string]any{"type": "integer", "minimum": 0, "description": "the expected effort in seconds, 0 when there is no estimate"},
+			"sessions": map[string]any{
+				"type":        "array",
+				"description": "the intervals of tracked time, oldest first",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"start": timestamp,
+						"end":   map[string]any{"type": []string{"string", "integer", "null"}, "description": "like the other timestamps, null while the session is being tracked"},
+					},
+					"required": []string{"start", "end"},
+				},
+			},
+			key("due_at", "dueAt"):         map[string]any{"type": []string{"string", "integer", "null"}, "description": "like the other timestamps, null when the task has no due date"},
+			"tags":                         map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			key("created_at", "createdAt"): timestamp,
+			key("updated_at", "updatedAt"): timestamp,
+		},
+		"required": []string{"id", "description", "status", key("created_at", "createdAt"), key("updated_at", "updatedAt")},
+	}
+
+	tasks := map[string]any{"type": "array", "items": task}
+
+	envelope := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"$schema": map[string]any{"const": taskListSchemaId(camel)},
+			"meta": map[string]any{
+				"type":       "object",
+				"properties": map[string]any{key("current_id", "currentId"): map[string]any{"type": "integer", "minimum": 1}},
+			},
+			"tasks":    tasks,
+			"archived": tasks,
+			"total":    map[string]any{"type": "integer", "minimum": 0},
+			"offset":   map[string]any{"type": "integer", "minimum": 0},
+			"limit":    map[string]any{"type": "integer", "minimum": 0},
+		},
+		"required": []string{"tasks"},
+	}
+
+	return map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     taskListSchemaId(camel),
+		"title":   "task list",
+		"oneOf":   []any{tasks, envelope},
+	}
+}
+
+// writeTasksNDJSON writes one compact JSON object per task and line, as
+// each task is encoded rather than all at once.
+func writeTasksNDJSON(w io.Writer, tasks []Task, options viewOptions) (err error) {
+	encoder := json.NewEncoder(w)
+	for _, task := range tasks {
+		if err = encoder.Encode(jsonTaskView(task, options)); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// recordSeparator starts every record of a JSON text sequence, RFC 7464.
+const recordSeparator = '\x1e'
+
+// writeTasksJSONSeq writes the tasks as a JSON text sequence, each compact
+// JSON object framed by a leading record separator and a trailing newline.
+func writeTasksJSONSeq(w io.Writer, tasks []Task, options viewOptions) (err error) {
+	for _, task := range tasks {
+		var data []byte
+		if data, err = json.Marshal(jsonTaskView(task, options)); err != nil {
+			return
+		}
+
+		record := make([]byte, 0, len(data)+2)
+		record = append(record, recordSeparator)
+		record = append(record, data...)
+		record = append(record, '\n')
+		if _, err = w.Write(record); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+type CommandState struct {
+	TaskStore *TaskStore
+	Args      []string
+	IO        IO
+	Config    Config
+
+	output *os.File
+	stdin  *bufio.Reader
+}
+
+func NewCommandState(args []string, streams IO, config Config) (state *CommandState, err error) {
+	state = new(CommandState)
+	state.Args = args
+	state.IO = streams
+	state.Config = config
+	if state.TaskStore, err = NewTaskStore(); err != nil {
+		return
+	}
+
+	if config.DB != "" {
+		state.TaskStore.dbPath = config.DB
+	}
+
+	return
+}
+
+// RenderOptions returns the options for rendering tasks to the command
+// output according to the settings.
+func (state *CommandState) RenderOptions() RenderOptions {
+	return RenderOptions{
+		Color:      state.Config.ColorEnabled(state.IO.Out),
+		TimeFormat: state.Config.TimeLayout(),
+		DescWidth:  state.Config.DescWidth,
+	}
+}
+
+// OpenOutput redirects the command output to the file at path, truncating
+// it. An empty path keeps writing to stdout.
+func (state *CommandState) OpenOutput(path string) (err error) {
+	if path == "" {
+		return
+	}
+
+	if state.output, err = os.Create(path); err != nil {
+		return
+	}
+	state.IO.Out = state.output
+
+	return
+}
+
+// startPager streams the command output through $PAGER, or less, when it
+// goes to a terminal. The returned function ends the output and waits for
+// the pager to quit. Should the pager fail to start, the output stays
+// direct.
+func (state *CommandState) startPager() (stop func() error) {
+	stop = func() error { return nil }
+
+	if !isTerminal(state.IO.Out) {
+		return
+	}
+
+	// -F quits at once when the output fits the screen
+	command := []string{"less", "-FRX"}
+	if value, ok := os.LookupEnv("PAGER"); ok {
+		command = strings.Fields(value)
+	}
+	if len(command) == 0 || command[0] == "cat" {
+		return
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout, cmd.Stderr = state.IO.Out, state.IO.Err
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+
+	out := state.IO.Out
+	state.IO.Out = pagerInput{stdin}
+
+	return func() error {
+		state.IO.Out = out
+		stdin.Close()
+		return cmd.Wait()
+	}
+}
+
+// pagerInput drops what is written once the pager has quit, as quitting
+// before the end of the output is no error.
+type pagerInput struct {
+	io.Writer
+}
+
+func (input pagerInput) Write(p []byte) (n int, err error) {
+	if n, err = input.Writer.Write(p); errors.Is(err, syscall.EPIPE) {
+		return len(p), nil
+	}
+	return
+}
+
+func (state *CommandState) Close() (err error) {
+	if state.output != nil {
+		err = state.output.Close()
+	}
+	return
+}
+
+func helpCommand(state *CommandState) (err error) {
+	fmt.Fprint(state.IO.Out, `USAGE: task [command] [args]
+
+COMMANDS:
+	help          show this message
+	add           add a new task
+	update        update a task description and optionally its status
+	delete        delete a task, --children orphan or delete decides for its subtasks
+	mark          change a task status
+	mark-all      change the status of every task
+	touch         bump the update time of a task
+	archive       move a task, or the old done tasks, to the archive
+	restore       move an archived task back to the tasks
+	move-to       move a task to another task store
+	edit          edit the whole task store in $EDITOR
+	prune-index   set the next id to one past the highest task id, archived ones included
+	doctor        check the task store for issues, --fix renames duplicate descriptions
+	color         label a task with a color
+	pin           keep a task at the top of the list
+	unpin         stop keeping a task at the top of the list
+	priority      set the priority of a task: low, medium, high or urgent
+	bump          raise the priority of a task one level, up to urgent
+	lower         lower the priority of a task one level, down to low
+	due           set or clear the due date of a task
+	project       list, rename or delete projects
+	depends       make a task wait for another, --remove to stop waiting
+	start         start tracking time on a task
+	stop          stop tracking time on a task
+	time          print the time spent on a task, or with report on every task, --week for this week only
+	template      save a task as a template for add --template, list or delete templates
+	check         add a checklist item to a task, or mark one done or undo it by number
+	attach        attach a file, copied next to the store, or a URL to a task
+	open          open the first attachment of a task, --print to only print it
+	set           set custom fields of a task, field= removes one
+	assign        assign a task to a person, --clear to unassign it
+	estimate      set how long a task should take, like 2h or 1d4h, --clear to remove it
+	report        print the remaining estimated work per status, tag and project with workload
+	remind        remind about a task at a time, --clear to remove the reminder
+	notify-daemon show desktop notifications for reminders and tasks due today
+	note          add a note to a task, read from the input without text, or list its notes
+	tag           add tags to a task
+	untag         remove tags from a task
+	rename-tag    rename a tag on every task
+	tags          list the tags with the number of tasks using them
+	list          list all tasks but the waiting ones unless --all, or those matching a filter expression,
+	              --tree to show subtasks under their parent with the share of them done
+	ui            browse the tasks and change them from the keyboard
+	config        get or set settings
+	context       show the task store, profile, user and settings in use
+	count         count tasks
+	idle          list unfinished tasks not updated for a while
+	search        search tasks by description, tags and notes, --word for whole words
+	show          show the details of a task
+	history       show the changes made to the fields of a task
+	stats         show task counts and completions per day
+	export        export tasks to another format
+	import        import tasks from a CSV or JSON file
+	replay        rebuild the tasks from the journal of changes
+	diff          show what restoring a backup would change
+	schema        print the JSON Schema of the list JSON output
+	freeze        refuse any change to the tasks
+	unfreeze      allow changes to the tasks again
+
+OPTIONS:
+	--output FILE    write the command output to FILE instead of stdout
+	--verbose        print the stack trace when a command crashes
+
+	--db FILE                 use FILE as the task store
+	--sort KEY                sort tasks by id, created, updated, status,
+	                          description or priority, which puts urgent
+	                          first, prefix with - to reverse the order
+	--color WHEN              color the output: auto, always or never
+	--default-status STATUS   status given to new tasks
+	--time-format LAYOUT      Go time layout used to show timestamps
+	--desc-width N            truncate descriptions to N characters, 0 for
+	                          no limit, --full on list also disables it
+	--locale LOCALE           show timestamps the way LOCALE does, such as
+	                          en_US or de_DE, unless --time-format is set
+	--default-assignee NAME   person new tasks are assigned to
+
+	The options above can also be set as key = value lines, such as
+	"default_status = in-progress", in a .taskrc file in the task data
+	directory or in the working directory, or with environment variables
+	such as TASK_DEFAULT_STATUS. Options override environment variables,
+	which override the working directory file, which overrides the data
+	directory file.
+
+EXAMPLES:
+	task help
+
+	task-cli add "Buy groceries"
+	task-cli add --force list
+	task-cli add -i
+	task-cli add "Pay rent" --due 2024-07-01
+	task-cli update 1 "Buy groceries and cook dinner"
+	task-cli update 1 "Cook dinner" --status in-progress
+	task-cli update 1 "Cook dinner" --due 2024-06-30
+	task-cli delete 1
+	task-cli delete --all
+	task-cli delete --all --reset-ids --yes --force
+	task-cli delete --all --include-archived
+	task-cli delete --where 'status=done and updated<2024-06-01'
+
+	task-cli mark 1 done
+	task-cli mark 1 todo
+	task-cli mark 1 in-progress
+	task-cli mark 1 ++
+	task-cli mark 1 --
+	task-cli mark 1 todo --note "reopened, the fix did not work"
+	task-cli mark-all todo --yes
+
+	task-cli touch 1
+
+	task-cli archive --before 2024-01-01
+	task-cli archive --before 2024-01-01 --dry-run
+	task-cli archive --done-older-than 30d
+	task-cli archive 3
+	task-cli list --archived
+	task-cli restore 3
+
+	task-cli move-to work.json 3
+
+	task-cli edit --json
+
+	task-cli prune-index
+
+	task-cli doctor
+	task-cli doctor --json
+	task-cli doctor --fix
+
+	task-cli color 1 red
+	task-cli color 1 none
+
+	task-cli pin 1
+	task-cli unpin 1
+	task-cli priority 1 urgent
+	task-cli bump 1
+	task-cli lower 1
+
+	task-cli due 1 2024-06-30
+	task-cli due 1 --clear
+
+	task-cli add "Fix login" --project myapp
+	task-cli list --project myapp
+	task-cli project list
+	task-cli depends 4 2
+	task-cli depends 4 2 --remove
+	task-cli list ready
+	task-cli add "Renew passport" --wait 2025-06-01
+	task-cli list waiting
+	task-cli list --all
+	task-cli start 1
+	task-cli stop 1
+	task-cli time 1
+	task-cli time report --week
+	task-cli template save sprint-bug 4
+	task-cli add --template sprint-bug "Login fails on Safari"
+	task-cli template list
+	task-cli check add 1 "Write the migration"
+	task-cli check done 1 1
+	task-cli attach 1 ./design.pdf
+	task-cli attach 1 https://example.com/issue/42
+	task-cli open 1
+	task-cli config set field.points int
+	task-cli set 1 points=3 sprint=
+	task-cli list --custom points
+	task-cli assign 1 alice
+	task-cli list --assignee alice
+	task-cli estimate 1 2h
+	task-cli report workload
+	task-cli remind 1 30m
+	task-cli remind 1 "2025-01-01 09:00"
+	task-cli notify-daemon --interval 1m
+	task-cli note add 1 "Asked for the logs"
+	task-cli note add 1 < notes.txt
+	task-cli note list 1
+	task-cli project rename myapp webapp
+	task-cli project delete webapp --reassign archive
+	task-cli tag 1 +work +home
+	task-cli untag 1 work
+	task-cli list --tag work
+	task-cli rename-tag work job
+	task-cli tags
+
+	task-cli list
+	task-cli list done
+	task-cli list todo
+	task-cli list in-progress
+	task-cli list overdue
+	task-cli list --summary
+	task-cli list --full
+	task-cli list --row-numbers
+	task-cli list --no-pager
+	task-cli list --pretty-dates
+	task-cli add "Write tests" --parent 3
+	task-cli add "Water plants" --every 3d --due 2025-01-01
+	task-cli update 5 "Water plants" --every "FREQ=WEEKLY;INTERVAL=2"
+	task-cli list --tree
+	task-cli delete 3 --children orphan
+	task-cli list --table
+	task-cli list --table --ascii
+	task-cli list --offset 10 --limit 10
+	task-cli list --offset 10 --limit 0
+	task-cli list --since-days 7
+	task-cli list --mine
+	task-cli list --pinned
+	task-cli list todo --fail-if-empty
+	task-cli list --renderer=oneline
+	task-cli list --json
+	task-cli list --json --time-epoch
+	task-cli list --json --json-raw-time
+	task-cli list --json --camel
+	task-cli list --json --with-meta
+	task-cli list --json --include-archived
+	task-cli list --json --compact-json
+	task-cli list --json-seq
+	task-cli list --highlight groceries
+	task-cli list --where 'status=done and created<2024-06-01'
+	task-cli list "status=todo and (tag=work or priority>=high) and due<eow"
+	task-cli list not done and project=myapp
+
+	task-cli idle 7d
+	task-cli idle 1w2d
+
+	task-cli config list
+	task-cli config get color
+	task-cli config set color never
+
+	task-cli ui
+	task-cli context
+	task-cli context --json
+
+	task-cli count
+	task-cli count todo
+	task-cli count done --since-days 7
+	task-cli count --where 'status=todo or status=in-progress'
+
+	task-cli search groceries
+	task-cli search groceries --ndjson
+	task-cli search milk --word
+
+	task-cli show 1
+	task-cli show 1 --stats
+	task-cli show 1 --history
+
+	task-cli stats
+	task-cli stats --by-day --days 14
+	task-cli stats --since-days 7
+
+	task-cli export --ics tasks.ics
+	task-cli export --zip backup.zip
+	task-cli export --sql tasks.sql
+	task-cli export --template report.tmpl report.html
+
+	task-cli import tasks.json
+	task-cli import tasks.csv --merge-strategy=rename
+	task-cli import old.csv --clamp-times
+	task-cli import --zip backup.zip
+
+	task-cli history 1
+	task-cli diff backup.zip
+	task-cli diff old-task.json --json
+
+	task-cli replay ~/.config/task/task.journal
+
+	task-cli schema
+	task-cli list --json --with-schema
+
+	task-cli freeze
+	task-cli unfreeze
+`)
+	return
+}
+
+func addCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("add", flag.ContinueOnError)
+	force := flags.Bool("force", false, "add the task even if its description is a command name")
+	due := flags.String("due", "", "due date of the task, as YYYY-MM-DD")
+	project := flags.String("project", "", "project of the task")
+	every := flags.String("every", "", "make the task recur, like 3d, 2w, 1m, 1y or an RRULE such as FREQ=WEEKLY;INTERVAL=2")
+	parent := flags.Uint64("parent", 0, "id of the task this one is a subtask of")
+	assignee := flags.String("assignee", "", "person the task is assigned to, instead of the default_assignee setting")
+	wait := flags.String("wait", "", "hide the task from the list until this date, as YYYY-MM-DD")
+	templateName := flags.String("template", "", "start from the fields of this template, the description being optional")
+	var interactive bool
+	flags.BoolVar(&interactive, "interactive", false, "ask for the description, priority, due date and tags")
+	flags.BoolVar(&interactive, "i", false, "same as --interactive")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if *parent != 0 {
+		if _, err = state.TaskStore.GetById(TaskId(*parent)); err != nil {
+			return
+		}
+	}
+
+	var task Task
+	if *templateName != "" {
+		var templates map[string]TaskTemplate
+		if templates, err = state.TaskStore.LoadTemplates(); err != nil {
+			return
+		}
+		taskTemplate, ok := templates[*templateName]
+		if !ok {
+			err = ErrTemplateNotFound
+			return
+		}
+		task = taskTemplate.Task()
+	}
+	task.ParentId = TaskId(*parent)
+	task.Assignee = cmp.Or(*assignee, task.Assignee, state.Config.DefaultAssignee)
+
+	var dueAt, waitUntil *time.Time
+	if *due != "" {
+		if dueAt, err = parseDueDate(*due); err != nil {
+			return
+		}
+	}
+	if *wait != "" {
+		if waitUntil, err = parseDueDate(*wait); err != nil {
+			return
+		}
+	}
+
+	if *project != "" && !validProject(*project) {
+		err = ErrInvalidProject
+		return
+	}
+
+	var recurrence string
+	if *every != "" {
+		var parsed Recurrence
+		if parsed, err = parseRecurrence(*every); err != nil {
+			return
+		}
+		recurrence = parsed.String()
+	}
+
+	// "task-cli add list" is more likely a typo than a task called list,
+	// but only a person at a terminal can be asked
+	if !*force && len(state.Args) == 1 && slices.Contains(commandNames, state.Args[0]) && isTerminal(state.IO.In) {
+		prompt := fmt.Sprintf("%q is a command, add it as a task anyway?", state.Args[0])
+		var ok bool
+		if ok, err = state.confirm(prompt); err != nil || !ok {
+			fmt.Fprintln(state.IO.Out, "Aborted")
+			return
+		}
+	}
+
+	if len(state.Args) == 1 {
+		task.Description = state.Args[0]
+	}
+	task.DueAt = dueAt
+	task.Project = cmp.Or(*project, task.Project)
+	task.Recurrence = cmp.Or(recurrence, task.Recurrence)
+	task.WaitUntil = waitUntil
+
+	// without a terminal to ask, the description must be given as usual,
+	// and the prompts start from what the flags already set
+	if interactive && len(state.Args) <= 1 && isTerminal(state.IO.In) {
+		return addInteractive(state, task)
+	}
+
+	// a template brings its own description, which can still be replaced
+	if len(state.Args) > 1 || task.Description == "" {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	task.Status = NewTaskStatus(state.Config.DefaultStatus)
+
+	if task, err = state.TaskStore.Create(task); err != nil {
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "Task added successfully: (ID: %d)\n", task.Id)
+
+	return
+}
+
+// addInteractive asks for the fields of task one by one, starting with its
+// description unless it already has one, and creates it. The optional
+// fields are skipped by giving an empty answer.
+func addInteractive(state *CommandState, task Task) (err error) {
+	for task.Description == "" {
+		if task.Description, err = state.ask("Description:"); err == io.EOF {
+			err = ErrEmptyDescription
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	// an empty answer keeps the value given by a flag or template
+	err = state.askUntilValid(fmt.Sprintf("Priority (low, medium, high or urgent) [%s]:", task.Priority), func(answer string) error {
+		var ok bool
+		if task.Priority, ok = NewTaskPriority(answer); !ok {
+			return ErrInvalidPriority
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	due := "none"
+	if task.DueAt != nil {
+		due = task.DueAt.Format(time.DateOnly)
+	}
+	err = state.askUntilValid(fmt.Sprintf("Due date (YYYY-MM-DD) [%s]:", due), func(answer string) error {
+		t, err := time.ParseInLocation(time.DateOnly, answer, time.Local)
+		if err != nil {
+			return err
+		}
+		task.DueAt = &t
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	tags := "none"
+	if len(task.Tags) != 0 {
+		tags = strings.Join(task.Tags, " ")
+	}
+	err = state.askUntilValid(fmt.Sprintf("Tags (separated by spaces or commas) [%s]:", tags), func(answer string) error {
+		task.Tags = normalizeTags(strings.FieldsFunc(answer, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		}))
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	task.Status = NewTaskStatus(state.Config.DefaultStatus)
+
+	if task, err = state.TaskStore.Create(task); err != nil {
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "Task added successfully: (ID: %d)\n", task.Id)
+	return
+}
+
+func updateCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("update", flag.ContinueOnError)
+	statusFlag := flags.String("status", "", "also set the status of the task")
+	due := flags.String("due", "", "also set the due date of the task, as YYYY-MM-DD")
+	project := flags.String("project", "", "also move the task to this project, empty for none")
+	every := flags.String("every", "", "also make the task recur, like 3d or an RRULE, empty to stop")
+	wait := flags.String("wait", "", "also hide the task from the list until this date, as YYYY-MM-DD, empty to show it again")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	// the status and due date are checked before touching the task, so an
+	// invalid one leaves the description unchanged as well
+	var status TaskStatus
+	if flagPassed(flags, "status") {
+		if status = NewTaskStatus(*statusFlag); !status.Valid() {
+			err = ErrInvalidTaskStatus
+			return
+		}
+	}
+
+	var dueAt, waitUntil *time.Time
+	if flagPassed(flags, "due") {
+		if dueAt, err = parseDueDate(*due); err != nil {
+			return
+		}
+	}
+	if *wait != "" {
+		if waitUntil, err = parseDueDate(*wait); err != nil {
+			return
+		}
+	}
+
+	if *project != "" && !validProject(*project) {
+		err = ErrInvalidProject
+		return
+	}
+
+	var recurrence string
+	if *every != "" {
+		var parsed Recurrence
+		if parsed, err = parseRecurrence(*every); err != nil {
+			return
+		}
+		recurrence = parsed.String()
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	task.Description = state.Args[1]
+	if flagPassed(flags, "status") {
+		task.SetStatus(status, time.Now(), "")
+	}
+	if flagPassed(flags, "due") {
+		task.DueAt = dueAt
+	}
+	if flagPassed(flags, "project") {
+		task.Project = *project
+	}
+	if flagPassed(flags, "every") {
+		task.Recurrence = recurrence
+	}
+	if flagPassed(flags, "wait") {
+		task.WaitUntil = waitUntil
+	}
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task updated successfully")
+	printNextOccurrence(state, task)
+	return
+}
+
+func deleteCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	all := flags.Bool("all", false, "delete every task")
+	resetIds := flags.Bool("reset-ids", false, "restart ids from 1, or past the archived ones, after deleting every task")
+	includeArchived := flags.Bool("include-archived", false, "also delete the archived tasks with --all")
+	yes := flags.Bool("yes", false, "skip the first confirmation")
+	force := flags.Bool("force", false, "skip the second confirmation")
+	where := flags.String("where", "", "delete every task matching the expression")
+	children := flags.String("children", "", "what to do with the subtasks of the task: orphan or delete, asked when omitted")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if *where != "" {
+		if *all || len(state.Args) != 0 {
+			err = ErrNoArgumentsAllowed
+			return
+		}
+		return deleteWhere(state, *where, *yes)
+	}
+
+	if *resetIds && !*all {
+		err = ErrResetIdsWithoutAll
+		return
+	}
+	if *includeArchived && !*all {
+		err = ErrIncludeArchivedWithoutAll
+		return
+	}
+
+	if *all {
+		if len(state.Args) != 0 {
+			err = ErrNoArgumentsAllowed
+			return
+		}
+		return deleteAll(state, *resetIds, *includeArchived, *yes, *force)
+	}
+
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	subtasks := len(state.TaskStore.Descendants(task.Id))
+	if subtasks == 0 {
+		if err = state.TaskStore.Delete(task); err != nil {
+			return
+		}
+
+		fmt.Fprintln(state.IO.Out, "Task deleted successfully")
+		return
+	}
+
+	var cascade bool
+	switch *children {
+	case "orphan":
+	case "delete":
+		cascade = true
+	case "":
+		// answering no, or not answering at all, keeps the subtasks
+		prompt := fmt.Sprintf("Task %d has %d subtasks, delete them too?", task.Id, subtasks)
+		if cascade, err = state.confirm(prompt); err != nil {
+			return
+		}
+	default:
+		err = ErrInvalidChildrenAction
+		return
+	}
+
+	var count int
+	if count, err = state.TaskStore.DeleteWithChildren(task, cascade); err != nil {
+		return
+	}
+
+	if cascade {
+		fmt.Fprintf(state.IO.Out, "Task deleted successfully with %d subtasks\n", count)
+	} else {
+		fmt.Fprintf(state.IO.Out, "Task deleted successfully, %d subtasks left without parent\n", count)
+	}
+	return
+}
+
+func deleteWhere(state *CommandState, where string, yes bool) (err error) {
+	var predicate TaskPredicate
+	if predicate, err = parseWhere(where, state.TaskStore); err != nil {
+		return
+	}
+
+	if !yes {
+		prompt := fmt.Sprintf("Delete %d tasks?", len(state.TaskStore.Filter(predicate)))
+		if yes, err = state.confirm(prompt); err != nil || !yes {
+			fmt.Fprintln(state.IO.Out, "Aborted")
+			return
+		}
+	}
+
+	var count int
+	if count, err = state.TaskStore.DeleteWhere(predicate); err != nil {
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "%d tasks deleted successfully\n", count)
+	return
+}
+
+func deleteAll(state *CommandState, resetIds, includeArchived, yes, force bool) (err error) {
+	count := len(state.TaskStore.Tasks)
+	if includeArchived {
+		var archive *TaskStore
+		if archive, err = state.TaskStore.OpenArchive(); err != nil {
+			return
+		}
+		count += len(archive.Tasks)
+	}
+
+	if !yes {
+		prompt := fmt.Sprintf("Delete all %d tasks?", count)
+		if includeArchived {
+			prompt = fmt.Sprintf("Delete all %d tasks, archived ones included?", count)
+		}
+		if yes, err = state.confirm(prompt); err != nil || !yes {
+			fmt.Fprintln(state.IO.Out, "Aborted")
+			return
+		}
+	}
+
+	if !force {
+		if force, err = state.confirm("This cannot be undone, are you sure?"); err != nil || !force {
+			fmt.Fprintln(state.IO.Out, "Aborted")
+			return
+		}
+	}
+
+	if err = state.TaskStore.DeleteAll(resetIds, includeArchived); err != nil {
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "%d tasks deleted successfully\n", count)
+	return
+}
+
+// confirm asks a yes/no question, treating anything but "y" or "yes" as no.
+func (state *CommandState) confirm(prompt string) (ok bool, err error) {
+	var answer string
+	if answer, err = state.ask(prompt + " [y/N]"); err != nil && err != io.EOF {
+		return
+	}
+	err = nil
+
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		ok = true
+	}
+
+	return
+}
+
+// ask writes prompt to stderr and returns the trimmed line read from the
+// input. It returns io.EOF once the input has nothing left.
+func (state *CommandState) ask(prompt string) (answer string, err error) {
+	fmt.Fprint(state.IO.Err, prompt, " ")
+
+	if state.stdin == nil {
+		state.stdin = bufio.NewReader(state.IO.In)
+	}
+
+	if answer, err = state.stdin.ReadString('\n'); err == io.EOF && answer != "" {
+		err = nil
+	}
+	answer = strings.TrimSpace(answer)
+	return
+}
+
+// askUntilValid asks with prompt until set accepts the answer, telling why
+// it did not otherwise. An empty answer, or the end of the input, leaves
+// the value unset.
+func (state *CommandState) askUntilValid(prompt string, set func(answer string) error) (err error) {
+	for {
+		var answer string
+		if answer, err = state.ask(prompt); err == io.EOF || (err == nil && answer == "") {
+			return nil
+		} else if err != nil {
+			return
+		}
+
+		setErr := set(answer)
+		if setErr == nil {
+			return
+		}
+		fmt.Fprintln(state.IO.Err, "Invalid answer:", setErr)
+	}
+}
+
+func markCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("mark", flag.ContinueOnError)
+	note := flags.String("note", "", "record why the status changed")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	var status TaskStatus
+	switch state.Args[1] {
+	case "++":
+		var ok bool
+		if status, ok = nextStatus(task.Status); !ok {
+			fmt.Fprintln(state.IO.Out, "Task is already", task.Status.String())
+			return
+		}
+	case "--":
+		var ok bool
+		if status, ok = prevStatus(task.Status); !ok {
+			fmt.Fprintln(state.IO.Out, "Task is already", task.Status.String())
+			return
+		}
+	default:
+		if status = NewTaskStatus(state.Args[1]); !status.Valid() {
+			err = ErrInvalidTaskStatus
+			return
+		}
+	}
+
+	task.SetStatus(status, time.Now(), *note)
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task status updated to", task.Status.String())
+	printNextOccurrence(state, task)
+	return
+}
+
+// printNextOccurrence tells about the next occurrence of task, a recurring
+// task that was just marked done, if one was created.
+func printNextOccurrence(state *CommandState, task Task) {
+	if task.Recurrence == "" || task.Status != TaskStatusDone {
+		return
+	}
+	index := state.TaskStore.IndexByDescription(task.Description)
+	if index == -1 || state.TaskStore.Tasks[index].Id == task.Id {
+		return
+	}
+	next := state.TaskStore.Tasks[index]
+	fmt.Fprintf(state.IO.Out, "Next occurrence added: (ID: %d, due %s)\n", next.Id, next.DueAt.Format(time.DateOnly))
+}
+
+func markAllCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("mark-all", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "skip the confirmation")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var status TaskStatus
+	if status = NewTaskStatus(state.Args[0]); !status.Valid() {
+		err = ErrInvalidTaskStatus
+		return
+	}
+
+	if !*yes {
+		prompt := fmt.Sprintf("Mark all %d tasks %s?", len(state.TaskStore.Tasks), status.String())
+		if *yes, err = state.confirm(prompt); err != nil || !*yes {
+			fmt.Fprintln(state.IO.Out, "Aborted")
+			return
+		}
+	}
+
+	var count int
+	if count, err = state.TaskStore.MarkAll(status); err != nil {
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "%d tasks marked %s\n", count, status.String())
+	return
+}
+
+func touchCommand(state *CommandState) (err error) {
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	// Update bumps the update time, nothing else changes
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task touched successfully")
+	return
+}
+
+func colorCommand(state *CommandState) (err error) {
+	if len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	color := state.Args[1]
+	if color == "none" {
+		color = ""
+	} else if _, ok := taskColorsMap[color]; !ok {
+		err = ErrInvalidTaskColor
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	task.Color = color
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task color updated successfully")
+	return
+}
+
+func pinCommand(state *CommandState) error {
+	return setPinned(state, true)
+}
+
+func unpinCommand(state *CommandState) error {
+	return setPinned(state, false)
+}
+
+func setPinned(state *CommandState, pinned bool) (err error) {
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	task.Pinned = pinned
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	if pinned {
+		fmt.Fprintln(state.IO.Out, "Task pinned successfully")
+	} else {
+		fmt.Fprintln(state.IO.Out, "Task unpinned successfully")
+	}
+	return
+}
+
+func priorityCommand(state *CommandState) (err error) {
+	if len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	priority, ok := NewTaskPriority(state.Args[1])
+	if !ok {
+		err = ErrInvalidPriority
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	task.Priority = priority
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task priority changed to", task.Priority.String())
+	return
+}
+
+func bumpCommand(state *CommandState) error {
+	return shiftPriority(state, nextPriority)
+}
+
+func lowerCommand(state *CommandState) error {
+	return shiftPriority(state, prevPriority)
+}
+
+// shiftPriority moves the priority of a task one level with shift, which
+// tells when the priority cannot go any further.
+func shiftPriority(state *CommandState, shift func(TaskPriority) (TaskPriority, bool)) (err error) {
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	var ok bool
+	if task.Priority, ok = shift(task.Priority); !ok {
+		fmt.Fprintln(state.IO.Out, "Task priority is already", task.Priority.String())
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task priority changed to", task.Priority.String())
+	return
+}
+
+func dueCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("due", flag.ContinueOnError)
+	clearDue := flags.Bool("clear", false, "remove the due date of the task")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if *clearDue && len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	} else if !*clearDue && len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	// the date is parsed before the task is looked up, so a bad date
+	// leaves the task untouched
+	var dueAt *time.Time
+	if !*clearDue {
+		if dueAt, err = parseDueDate(state.Args[1]); err != nil {
+			return
+		}
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	task.DueAt = dueAt
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	if *clearDue {
+		fmt.Fprintln(state.IO.Out, "Task due date cleared successfully")
+	} else {
+		fmt.Fprintln(state.IO.Out, "Task due date updated successfully")
+	}
+	return
+}
+
+func tagCommand(state *CommandState) error {
+	return editTags(state, "+", func(tags []string, tag string) []string {
+		return append(tags, tag)
+	})
+}
+
+func untagCommand(state *CommandState) error {
+	return editTags(state, "+-", func(tags []string, tag string) []string {
+		return slices.DeleteFunc(tags, func(t string) bool { return t == tag })
+	})
+}
+
+// editTags applies edit to the tags of a task for each tag argument, which
+// may start with one of the characters in prefixes, as in +work.
+func editTags(state *CommandState, prefixes string, edit func(tags []string, tag string) []string) (err error) {
+	if len(state.Args) < 2 {
+		err = ErrMissingTags
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	// every tag is checked before touching the task
+	tags := make([]string, 0, len(state.Args)-1)
+	for _, arg := range state.Args[1:] {
+		tag := arg
+		if len(tag) > 0 && strings.ContainsRune(prefixes, rune(tag[0])) {
+			tag = tag[1:]
+		}
+		if !validTag(tag) {
+			err = fmt.Errorf("%w: %q", ErrInvalidTag, arg)
+			return
+		}
+		tags = append(tags, tag)
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	task.Tags = slices.Clone(task.Tags)
+	for _, tag := range tags {
+		task.Tags = edit(task.Tags, tag)
+	}
+	task.Tags = normalizeTags(task.Tags)
+	if len(task.Tags) == 0 {
+		task.Tags = nil
+	}
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task tags updated successfully")
+	return
+}
+
+func renameTagCommand(state *CommandState) (err error) {
+	if len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	oldTag, newTag := state.Args[0], state.Args[1]
+	if !validTag(oldTag) || !validTag(newTag) {
+		err = ErrInvalidTag
+		return
+	}
+
+	var count int
+	if count, err = state.TaskStore.RenameTag(oldTag, newTag); err != nil {
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "Tag renamed on %d tasks\n", count)
+	return
+}
+
+func tagsCommand(state *CommandState) (err error) {
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
+		return
+	}
+
+	counts := state.TaskStore.TagCounts()
+
+	tags := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+
+	for _, tag := range tags {
+		fmt.Fprintf(state.IO.Out, "%-20s %d\n", tag, counts[tag])
+	}
+
+	return
+}
+
+func doctorCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the issues as a JSON array")
+	fix := flags.Bool("fix", false, "rename tasks with duplicate descriptions before checking")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
+		return
+	}
+
+	if *fix {
+		var changes []DescriptionChange
+		if changes, err = state.TaskStore.FixDuplicateDescriptions(); err != nil {
+			return
+		}
+		// keep stdout a valid JSON document with --json
+		out := state.IO.Out
+		if *asJSON {
+			out = state.IO.Err
+		}
+		for _, change := range changes {
+			fmt.Fprintf(out, "Renamed task %d from %q to %q\n", change.Id, change.Before, change.After)
+		}
+	}
+
+	issues := state.TaskStore.Issues()
+
+	if *asJSON {
+		if issues == nil {
+			issues = []StoreIssue{}
+		}
+		encoder := json.NewEncoder(state.IO.Out)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(issues); err != nil {
+			return
+		}
+	} else if len(issues) == 0 {
+		fmt.Fprintln(state.IO.Out, "No issues found")
+	} else {
+		for _, issue := range issues {
+			fmt.Fprintf(state.IO.Out, "%s: %s\n", issue.Kind, issue.Detail)
+		}
+	}
+
+	// a non zero exit status lets scripts tell a broken store apart
+	if len(issues) > 0 {
+		err = fmt.Errorf("%w: %d found", ErrStoreHasIssues, len(issues))
+	}
+	return
+}
+
+func pruneIndexCommand(state *CommandState) (err error) {
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
+		return
+	}
+
+	var before, after uint64
+	if before, after, err = state.TaskStore.RecomputeCurrentId(); err != nil {
+		return
+	}
+
+	if before == after {
+		fmt.Fprintf(state.IO.Out, "Next id already is %d\n", after)
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "Next id changed from %d to %d\n", before, after)
+	return
+}
+
+func editCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("edit", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "edit the whole store as JSON")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
+		return
+	}
+
+	if !*asJSON {
+		err = ErrNoEditFormat
+		return
+	}
+
+	if state.TaskStore.Meta.Frozen {
+		err = ErrStoreFrozen
+		return
+	}
+
+	// a store that is already broken would only be made worse
+	if err = state.TaskStore.Validate(); err != nil {
+		return
+	}
+
+	var data []byte
+	if data, err = marshalWithExtra(state.TaskStore, state.TaskStore.extra); err != nil {
+		return
+	}
+
+	var pretty bytes.Buffer
+	if err = json.Indent(&pretty, data, "", "  "); err != nil {
+		return
+	}
+	pretty.WriteByte('\n')
+
+	var file *os.File
+	if file, err = os.CreateTemp("", appName()+"-*.json"); err != nil {
+		return
+	}
+	defer os.Remove(file.Name())
+
+	if _, err = file.Write(pretty.Bytes()); err != nil {
+		file.Close()
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+
+	cmd := exec.Command(editor[0], append(editor[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = state.IO.In, state.IO.Out, state.IO.Err
+	if err = cmd.Run(); err != nil {
+		return
+	}
+
+	var edited []byte
+	if edited, err = os.ReadFile(file.Name()); err != nil {
+		return
+	}
+
+	if bytes.Equal(edited, pretty.Bytes()) {
+		fmt.Fprintln(state.IO.Out, "No changes made")
+		return
+	}
+
+	editedStore := &TaskStore{Tasks: make([]Task, 0)}
+	if err = json.Unmarshal(edited, editedStore); err != nil {
+		return
+	}
+	if editedStore.extra, err = unknownFields(edited, reflect.TypeFor[TaskStore]()); err != nil {
+		return
+	}
+	editedStore.fillStatusHistory()
+
+	if err = editedStore.Validate(); err != nil {
+		return
+	}
+
+	if err = state.TaskStore.Replace(editedStore); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Store updated successfully")
+	return
+}
+
+func moveToCommand(state *CommandState) (err error) {
+	if len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[1], 10, 64); err != nil {
+		return
+	}
+
+	var target *TaskStore
+	if target, err = OpenTaskStore(state.Args[0]); err != nil {
+		return
+	}
+
+	var moved Task
+	if moved, err = state.TaskStore.MoveTo(target, TaskId(id)); err != nil {
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "Task moved successfully: (ID: %d)\n", moved.Id)
+	return
+}
+
+func archiveCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	before := flags.String("before", "", "archive the done tasks completed before this date")
+	olderThan := flags.String("done-older-than", "", "archive the done tasks completed longer ago than this duration, like 30d")
+	dryRun := flags.Bool("dry-run", false, "only list the tasks that would be archived")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) > 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	if len(state.Args) == 1 {
+		if *before != "" || *olderThan != "" {
+			err = ErrArchiveConflict
+			return
+		}
+		return archiveOne(state, state.Args[0], *dryRun)
+	}
+
+	var cutoff time.Time
+	switch {
+	case *before != "" && *olderThan != "":
+		err = ErrArchiveConflict
+		return
+	case *before != "":
+		if cutoff, err = time.ParseInLocation(time.DateOnly, *before, time.Local); err != nil {
+			return
+		}
+	case *olderThan != "":
+		var age time.Duration
+		if age, err = parseHumanDuration(*olderThan); err != nil {
+			return
+		}
+		cutoff = time.Now().Add(-age)
+	default:
+		err = ErrArchiveNeedsBefore
+		return
+	}
+
+	var archived []Task
+	if archived, err = state.TaskStore.ArchiveDoneBefore(cutoff, *dryRun); err != nil {
+		return
+	}
+
+	if *dryRun {
+		if err = (plainRenderer{state.RenderOptions()}).Render(state.IO.Out, archived); err != nil {
+			return
+		}
+		fmt.Fprintf(state.IO.Out, "%d tasks would be archived\n", len(archived))
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "%d tasks archived successfully\n", len(archived))
+	return
+}
+
+// archiveOne archives the task whose id is given as argument.
+func archiveOne(state *CommandState, arg string, dryRun bool) (err error) {
+	var id uint64
+	if id, err = strconv.ParseUint(arg, 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if dryRun {
+		if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+			return
+		}
+		if err = (plainRenderer{state.RenderOptions()}).Render(state.IO.Out, []Task{task}); err != nil {
+			return
+		}
+		fmt.Fprintln(state.IO.Out, "1 tasks would be archived")
+		return
+	}
+
+	if task, err = state.TaskStore.Archive(TaskId(id)); err != nil {
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "Task archived successfully: (ID: %d)\n", task.Id)
+	return
+}
+
+func restoreCommand(state *CommandState) (err error) {
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.Restore(TaskId(id)); err != nil {
+		return
+	}
+
+	fmt.Fprintf(state.IO.Out, "Task restored successfully: (ID: %d)\n", task.Id)
+	return
+}
+
+// maxBarLen is the length of the longest bar of the stats charts.
+const maxBarLen = 50
+
+func statsCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	byDay := flags.Bool("by-day", false, "chart the tasks completed per day")
+	days := flags.Int("days", 30, "number of days charted by --by-day")
+	sinceDays := flags.Int("since-days", 0, "only count the tasks created or updated within the last N days, or chart N days with --by-day")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
+		return
+	}
+
+	if !*byDay {
+		tasks := state.TaskStore.Tasks
+		if flagPassed(flags, "since-days") {
+			if tasks, err = filterSinceDays(tasks, time.Now(), *sinceDays); err != nil {
+				return
+			}
+		}
+
+		counts := countByStatus(tasks)
+		for status := TaskStatusTodo; status <= TaskStatusDone; status++ {
+			fmt.Fprintf(state.IO.Out, "%-12s %d\n", status.String(), counts[status])
+		}
+		fmt.Fprintf(state.IO.Out, "%-12s %d\n", "total", len(tasks))
+
+		if average, median, ok := cycleTimes(tasks); ok {
+			fmt.Fprintf(state.IO.Out, "%-12s %s average, %s median\n", "cycle time", formatSpent(average), formatSpent(median))
+		}
+		return
+	}
+
+	if flagPassed(flags, "since-days") {
+		*days = *sinceDays
+	}
+
+	if *days <= 0 {
+		err = ErrInvalidDays
+		return
+	}
+
+	counts := state.TaskStore.CompletionsByDay(time.Now(), *days)
+
+	maxCount := 0
+	for _, count := range counts {
+		maxCount = max(maxCount, count.Count)
+	}
+
+	for _, count := range counts {
+		barLen := count.Count
+		if maxCount > maxBarLen {
+			barLen = count.Count * maxBarLen / maxCount
+		}
+		line := fmt.Sprintf("%s  %3d  %s", count.Day.Format(time.DateOnly), count.Count, strings.Repeat("#", barLen))
+		fmt.Fprintln(state.IO.Out, strings.TrimRight(line, " "))
+	}
+
+	return
+}
+
+// contextInfo is the effective context of the commands, as printed by the
+// context command.
+type contextInfo struct {
+	DB           string `json:"db"`
+	Profile      string `json:"profile"`
+	User         string `json:"user"`
+	Color        string `json:"color"`
+	ColorEnabled bool   `json:"color_enabled"`
+	Sort         string `json:"sort"`
+}
+
+func contextCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("context", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the context as JSON")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
+		return
+	}
+
+	info := contextInfo{
+		DB:           state.TaskStore.dbPath,
+		Profile:      appName(),
+		User:         currentUser(),
+		Color:        state.Config.Color,
+		ColorEnabled: state.Config.ColorEnabled(state.IO.Out),
+		Sort:         state.Config.Sort,
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(state.IO.Out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	color := info.Color
+	if info.ColorEnabled {
+		color += " (enabled)"
+	} else {
+		color += " (disabled)"
+	}
+
+	fmt.Fprintln(state.IO.Out, "db:      ", info.DB)
+	fmt.Fprintln(state.IO.Out, "profile: ", info.Profile)
+	fmt.Fprintln(state.IO.Out, "user:    ", info.User)
+	fmt.Fprintln(state.IO.Out, "color:   ", color)
+	fmt.Fprintln(state.IO.Out, "sort:    ", info.Sort)
+	return
+}
+
+func configCommand(state *CommandState) (err error) {
+	if len(state.Args) == 0 {
+		err = ErrUnknownConfigAction
+		return
+	}
+
+	action, args := state.Args[0], state.Args[1:]
+	switch action {
+	case "get":
+		if len(args) != 1 {
+			err = ErrOnlyOneArgumentAllowed
+			return
+		}
+
+		var value string
+		if value, err = state.Config.Get(args[0]); err != nil {
+			return
+		}
+		fmt.Fprintln(state.IO.Out, value)
+	case "set":
+		if len(args) != 2 {
+			err = ErrOnlyTwoArgumentsAllowed
+			return
+		}
+
+		// validate against a copy so a bad value is never written
+		config := state.Config
+		if err = config.Set(args[0], args[1]); err != nil {
+			return
+		}
+
+		var file string
+		if file, err = userConfigPath(); err != nil {
+			return
+		}
+
+		if err = writeConfigFileValue(file, args[0], args[1]); err != nil {
+			return
+		}
+		fmt.Fprintf(state.IO.Out, "%s set to %s in %s\n", args[0], args[1], file)
+	case "list":
+		if len(args) != 0 {
+			err = ErrNoArgumentsAllowed
+			return
+		}
+
+		for _, key := range configKeys {
+			value, _ := state.Config.Get(key)
+			fmt.Fprintf(state.IO.Out, "%s = %s\n", key, value)
+		}
+		for _, name := range slices.Sorted(maps.Keys(state.Config.Fields)) {
+			fmt.Fprintf(state.IO.Out, "%s%s = %s\n", fieldConfigPrefix, name, state.Config.Fields[name])
+		}
+	default:
+		err = ErrUnknownConfigAction
+	}
+
+	return
+}
+
+func startCommand(state *CommandState) (err error) {
+	return trackTime(state, true)
+}
+
+func stopCommand(state *CommandState) (err error) {
+	return trackTime(state, false)
+}
+
+// trackTime opens a session on the task given as argument when start is
+// set, and closes its open session otherwise.
+func trackTime(state *CommandState, start bool) (err error) {
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	now := time.Now()
+	task.Sessions = slices.Clone(task.Sessions)
+
+	if start {
+		if task.Tracking() {
+			err = ErrAlreadyTracking
+			return
+		}
+		task.Sessions = append(task.Sessions, Session{Start: now})
+	} else {
+		if !task.Tracking() {
+			err = ErrNotTracking
+			return
+		}
+		task.Sessions[len(task.Sessions)-1].End = &now
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	if start {
+		fmt.Fprintln(state.IO.Out, "Time tracking started")
+	} else {
+		fmt.Fprintln(state.IO.Out, "Time tracking stopped, session lasted", formatSpent(now.Sub(task.Sessions[len(task.Sessions)-1].Start)))
+	}
+	return
+}
+
+func timeCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("time", flag.ContinueOnError)
+	week := flags.Bool("week", false, "only count the time spent since monday, for report")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	now := time.Now()
+	layout := state.Config.TimeLayout()
+
+	if state.Args[0] == "report" {
+		var from time.Time
+		if *week {
+			// weeks start on monday
+			days := (int(now.Weekday()) + 6) % 7
+			from = time.Date(now.Year(), now.Month(), now.Day()-days, 0, 0, 0, 0, now.Location())
+		}
+
+		var total time.Duration
+		for _, task := range state.TaskStore.Tasks {
+			spent := task.TimeSpent(from, time.Time{}, now)
+			if spent == 0 {
+				continue
+			}
+			total += spent
+			fmt.Fprintf(state.IO.Out, "%8s    #%d %s\n", formatSpent(spent), task.Id, task.Description)
+		}
+		fmt.Fprintf(state.IO.Out, "%8s    total\n", formatSpent(total))
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		err = ErrUnknownTimeAction
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	for _, session := range task.Sessions {
+		end, spent := "now", now.Sub(session.Start)
+		if session.End != nil {
+			end, spent = session.End.Format(layout), session.End.Sub(session.Start)
+		}
+		fmt.Fprintf(state.IO.Out, "%s - %s    %s\n", session.Start.Format(layout), end, formatSpent(spent))
+	}
+	fmt.Fprintln(state.IO.Out, "total:", formatSpent(task.TimeSpent(time.Time{}, time.Time{}, now)))
+	return
+}
+
+func templateCommand(state *CommandState) (err error) {
+	if len(state.Args) == 0 {
+		err = ErrUnknownTemplateAction
+		return
+	}
+
+	action, args := state.Args[0], state.Args[1:]
+
+	var templates map[string]TaskTemplate
+	if templates, err = state.TaskStore.LoadTemplates(); err != nil {
+		return
+	}
+
+	switch action {
+	case "save":
+		if len(args) != 2 {
+			err = ErrOnlyTwoArgumentsAllowed
+			return
+		}
+		if !validTag(args[0]) {
+			err = ErrInvalidTemplateName
+			return
+		}
+
+		var id uint64
+		if id, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+			return
+		}
+
+		var task Task
+		if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+			return
+		}
+
+		templates[args[0]] = newTaskTemplate(task)
+		if err = state.TaskStore.SaveTemplates(templates); err != nil {
+			return
+		}
+		fmt.Fprintln(state.IO.Out, "Template saved successfully:", args[0])
+	case "list":
+		if len(args) != 0 {
+			err = ErrNoArgumentsAllowed
+			return
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(templates)) {
+			fmt.Fprintf(state.IO.Out, "%-20s %s\n", name, templates[name].Description)
+		}
+	case "delete":
+		if len(args) != 1 {
+			err = ErrOnlyOneArgumentAllowed
+			return
+		}
+		if _, ok := templates[args[0]]; !ok {
+			err = ErrTemplateNotFound
+			return
+		}
+
+		delete(templates, args[0])
+		if err = state.TaskStore.SaveTemplates(templates); err != nil {
+			return
+		}
+		fmt.Fprintln(state.IO.Out, "Template deleted successfully:", args[0])
+	default:
+		err = ErrUnknownTemplateAction
+	}
+
+	return
+}
+
+func checkCommand(state *CommandState) (err error) {
+	if len(state.Args) != 3 {
+		if len(state.Args) == 0 {
+			err = ErrUnknownCheckAction
+		} else {
+			err = ErrMissingCheckItem
+		}
+		return
+	}
+
+	action, args := state.Args[0], state.Args[1:]
+	if action != "add" && action != "done" && action != "undo" {
+		err = ErrUnknownCheckAction
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	task.Checklist = slices.Clone(task.Checklist)
+
+	if action == "add" {
+		text := strings.TrimSpace(args[1])
+		if text == "" {
+			err = ErrEmptyDescription
+			return
+		}
+		task.Checklist = append(task.Checklist, ChecklistItem{Text: text})
+	} else {
+		// items are numbered from 1, the way show lists them
+		var n int
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 || n > len(task.Checklist) {
+			err = ErrInvalidCheckItem
+			return
+		}
+		task.Checklist[n-1].Done = action == "done"
+	}
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task checklist updated successfully", task.checklistProgress())
+	return
+}
+
+func attachCommand(state *CommandState) (err error) {
+	if len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	// the file is copied before the task is saved, so a failed save at
+	// worst leaves an unused blob behind
+	var attachment Attachment
+	if target := state.Args[1]; isURL(target) {
+		attachment = Attachment{Name: target, URL: target, AddedAt: time.Now()}
+	} else if attachment, err = state.TaskStore.AttachFile(target); err != nil {
+		return
+	}
+
+	task.Attachments = append(slices.Clone(task.Attachments), attachment)
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Attachment added successfully:", attachment.Name)
+	return
+}
+
+func openCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("open", flag.ContinueOnError)
+	printOnly := flags.Bool("print", false, "print the URL or file path instead of opening it")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	if len(task.Attachments) == 0 {
+		err = ErrNoAttachments
+		return
+	}
+
+	target := task.Attachments[0].URL
+	if target == "" {
+		target = state.TaskStore.blobPath(task.Attachments[0])
+	}
+
+	if *printOnly {
+		fmt.Fprintln(state.IO.Out, target)
+		return
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", target)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	return cmd.Run()
+}
+
+func setCommand(state *CommandState) (err error) {
+	if len(state.Args) < 2 {
+		err = ErrMissingAssignments
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	// every assignment is checked before the task changes, and an empty
+	// value removes the field
+	task.Custom = maps.Clone(task.Custom)
+	for _, assignment := range state.Args[1:] {
+		name, value, ok := strings.Cut(assignment, "=")
+		if !ok {
+			err = ErrInvalidFieldAssignment
+			return
+		}
+
+		if _, declared := state.Config.Fields[name]; !declared {
+			err = fmt.Errorf("%w: %s", ErrUnknownField, name)
+			return
+		}
+
+		if value == "" {
+			delete(task.Custom, name)
+			continue
+		}
+
+		var parsed any
+		if parsed, err = state.Config.CustomValue(name, value); err != nil {
+			return
+		}
+		if task.Custom == nil {
+			task.Custom = make(map[string]any)
+		}
+		task.Custom[name] = parsed
+	}
+	if len(task.Custom) == 0 {
+		task.Custom = nil
+	}
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task fields updated successfully")
+	return
+}
+
+func assignCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("assign", flag.ContinueOnError)
+	clearAssignee := flags.Bool("clear", false, "leave the task unassigned")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if *clearAssignee && len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	} else if !*clearAssignee && len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var assignee string
+	if !*clearAssignee {
+		if assignee = strings.TrimSpace(state.Args[1]); assignee == "" {
+			err = ErrEmptyAssignee
+			return
+		}
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	task.Assignee = assignee
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	if *clearAssignee {
+		fmt.Fprintln(state.IO.Out, "Task unassigned successfully")
+	} else {
+		fmt.Fprintln(state.IO.Out, "Task assigned to", assignee)
+	}
+	return
+}
+
+func estimateCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("estimate", flag.ContinueOnError)
+	clearEstimate := flags.Bool("clear", false, "remove the estimate of the task")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if *clearEstimate && len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	} else if !*clearEstimate && len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var estimate time.Duration
+	if !*clearEstimate {
+		if estimate, err = parseHumanDuration(state.Args[1]); err != nil {
+			return
+		}
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	task.Estimate = estimate
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	if *clearEstimate {
+		fmt.Fprintln(state.IO.Out, "Task estimate cleared successfully")
+	} else {
+		fmt.Fprintln(state.IO.Out, "Task estimate set to", formatSpent(estimate))
+	}
+	return
+}
+
+func reportCommand(state *CommandState) (err error) {
+	if len(state.Args) != 1 || state.Args[0] != "workload" {
+		err = ErrUnknownReportAction
+		return
+	}
+
+	now := time.Now()
+	byStatus := make(map[string]time.Duration)
+	byTag := make(map[string]time.Duration)
+	byProject := make(map[string]time.Duration)
+	var total time.Duration
+
+	for _, task := range state.TaskStore.Tasks {
+		remaining := task.Remaining(now)
+		if remaining == 0 {
+			continue
+		}
+
+		total += remaining
+		byStatus[task.Status.String()] += remaining
+		for _, tag := range normalizeTags(task.Tags) {
+			byTag[tag] += remaining
+		}
+		byProject[cmp.Or(task.Project, "(none)")] += remaining
+	}
+
+	for _, group := range []struct {
+		name   string
+		totals map[string]time.Duration
+	}{{"status", byStatus}, {"tag", byTag}, {"project", byProject}} {
+		fmt.Fprintln(state.IO.Out, group.name)
+		for _, key := range slices.Sorted(maps.Keys(group.totals)) {
+			fmt.Fprintf(state.IO.Out, "    %-20s %8s\n", key, formatSpent(group.totals[key]))
+		}
+	}
+	fmt.Fprintf(state.IO.Out, "%-24s %8s\n", "total", formatSpent(total))
+	return
+}
+
+func remindCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("remind", flag.ContinueOnError)
+	clearRemind := flags.Bool("clear", false, "remove the reminder of the task")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if *clearRemind && len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	} else if !*clearRemind && len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var remindAt *time.Time
+	if !*clearRemind {
+		var at time.Time
+		if at, err = parseRemindTime(state.Args[1], time.Now()); err != nil {
+			return
+		}
+		remindAt = &at
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	task.RemindAt = remindAt
+
+	if !state.TaskStore.Changed(task) {
+		fmt.Fprintln(state.IO.Out, "No changes")
+		return
+	}
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	if *clearRemind {
+		fmt.Fprintln(state.IO.Out, "Task reminder cleared successfully")
+	} else {
+		fmt.Fprintln(state.IO.Out, "Task reminder set for", remindAt.Format(state.Config.TimeLayout()))
+	}
+	return
+}
+
+// remindTimeLayouts are the dates and times a reminder can be set at.
+var remindTimeLayouts = []string{"2006-01-02 15:04", "2006-01-02T15:04", time.DateTime, time.DateOnly}
+
+// parseRemindTime parses when a reminder is due: a duration from now like
+// 30m or +2h, a time of day like 15:04, today or else tomorrow, or a date
+// with an optional time in the local time zone.
+func parseRemindTime(value string, now time.Time) (at time.Time, err error) {
+	if duration, durationErr := time.ParseDuration(strings.TrimPrefix(value, "+")); durationErr == nil && duration > 0 {
+		return now.Add(duration), nil
+	}
+
+	if clock, clockErr := time.ParseInLocation("15:04", value, now.Location()); clockErr == nil {
+		at = time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return
+	}
+
+	for _, layout := range remindTimeLayouts {
+		if at, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return
+		}
+	}
+
+	err = ErrInvalidRemindTime
+	return
+}
+
+// notification is a desktop notification the daemon gives once.
+type notification struct {
+	// key tells the notifications apart so none is given twice
+	key      string
+	title    string
+	body     string
+	reminder bool
+	taskId   TaskId
+}
+
+// dueNotifications returns the notifications of the tasks whose reminder
+// time has come, and of those not done whose due date is today.
+func dueNotifications(store *TaskStore, now time.Time) (notifications []notification) {
+	for _, task := range store.Tasks {
+		if task.RemindAt != nil && !now.Before(*task.RemindAt) {
+			notifications = append(notifications, notification{
+				key:      fmt.Sprintf("remind %d %d", task.Id, task.RemindAt.Unix()),
+				title:    "Reminder",
+				body:     fmt.Sprintf("#%d %s", task.Id, task.Description),
+				reminder: true,
+				taskId:   task.Id,
+			})
+		}
+
+		if task.DueAt != nil && task.Status != TaskStatusDone && !now.Before(*task.DueAt) && now.Before(task.DueAt.AddDate(0, 0, 1)) {
+			notifications = append(notifications, notification{
+				key:    fmt.Sprintf("due %d %s", task.Id, task.DueAt.Format(time.DateOnly)),
+				title:  "Due today",
+				body:   fmt.Sprintf("#%d %s", task.Id, task.Description),
+				taskId: task.Id,
+			})
+		}
+	}
+	return
+}
+
+// notify shows a desktop notification with notify-send, or with osascript
+// on macOS.
+func notify(title, body string) error {
+	if runtime.GOOS == "darwin" {
+		quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+		script := fmt.Sprintf(`display notification "%s" with title "%s"`, quote(body), quote(title))
+		return exec.Command("osascript", "-e", script).Run()
+	}
+	return exec.Command("notify-send", title, body).Run()
+}
+
+func notifyDaemonCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("notify-daemon", flag.ContinueOnError)
+	interval := flags.Duration("interval", 30*time.Second, "how often the store is checked")
+	once := flags.Bool("once", false, "check the store once and exit")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
+		return
+	}
+
+	// given remembers the notifications already shown by this daemon, as
+	// due dates stay due for the whole day and clearing a reminder may
+	// fail on a frozen store
+	given := make(map[string]bool)
+	warned := false
+
+	for {
+		if err = state.TaskStore.Reload(); err != nil {
+			return
+		}
+
+		var reminded []TaskId
+		for _, n := range dueNotifications(state.TaskStore, time.Now()) {
+			if given[n.key] {
+				continue
+			}
+			given[n.key] = true
+
+			if n.reminder {
+				reminded = append(reminded, n.taskId)
+			}
+
+			fmt.Fprintln(state.IO.Out, time.Now().Format(state.Config.TimeLayout()), n.title+":", n.body)
+			if notifyErr := notify(n.title, n.body); notifyErr != nil && !warned {
+				fmt.Fprintln(state.IO.Err, "Warning: cannot show desktop notifications:", notifyErr)
+				warned = true
+			}
+		}
+
+		// reminders are given once, even across restarts of the daemon
+		if len(reminded) != 0 {
+			if clearErr := state.TaskStore.ClearReminders(reminded); clearErr != nil {
+				fmt.Fprintln(state.IO.Err, "Warning: cannot clear the given reminders:", clearErr)
+			}
+		}
+
+		if *once {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func noteCommand(state *CommandState) (err error) {
+	if len(state.Args) == 0 {
+		err = ErrUnknownNoteAction
+		return
+	}
+
+	action, args := state.Args[0], state.Args[1:]
+
+	switch action {
+	case "add":
+		// without a text argument the note is read from the input, so
+		// it can span several lines
+		if len(args) != 1 && len(args) != 2 {
+			err = ErrOnlyTwoArgumentsAllowed
+			return
+		}
+	case "list":
+		if len(args) != 1 {
+			err = ErrOnlyOneArgumentAllowed
+			return
+		}
+	default:
+		err = ErrUnknownNoteAction
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	if action == "list" {
+		writeNotes(state, task.Notes)
+		return
+	}
+
+	var text string
+	if len(args) == 2 {
+		text = args[1]
+	} else {
+		var data []byte
+		if data, err = io.ReadAll(state.IO.In); err != nil {
+			return
+		}
+		text = string(data)
+	}
+
+	if text = strings.TrimSpace(text); text == "" {
+		err = ErrEmptyNote
+		return
+	}
+
+	task.Notes = append(slices.Clone(task.Notes), Note{Text: text, CreatedAt: time.Now()})
+
+	if err = state.TaskStore.Update(task); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task note added successfully")
+	return
+}
+
+// writeNotes writes each note under its creation time, its lines indented.
+func writeNotes(state *CommandState, notes []Note) {
+	for _, note := range notes {
+		fmt.Fprintln(state.IO.Out, "   ", note.CreatedAt.Format(state.Config.TimeLayout()))
+		for _, line := range strings.Split(note.Text, "\n") {
+			fmt.Fprintln(state.IO.Out, "       ", line)
+		}
+	}
+}
+
+func dependsCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("depends", flag.ContinueOnError)
+	remove := flags.Bool("remove", false, "stop the task from waiting for the other")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 2 {
+		err = ErrOnlyTwoArgumentsAllowed
+		return
+	}
+
+	var id, on uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+	if on, err = strconv.ParseUint(state.Args[1], 10, 64); err != nil {
+		return
+	}
+
+	if *remove {
+		var task Task
+		if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+			return
+		}
+
+		task.DependsOn = slices.DeleteFunc(slices.Clone(task.DependsOn), func(dependency TaskId) bool {
+			return dependency == TaskId(on)
+		})
+
+		if !state.TaskStore.Changed(task) {
+			fmt.Fprintln(state.IO.Out, "No changes")
+			return
+		}
+
+		if err = state.TaskStore.Update(task); err != nil {
+			return
+		}
+
+		fmt.Fprintln(state.IO.Out, "Task dependency removed successfully")
+		return
+	}
+
+	if err = state.TaskStore.AddDependency(TaskId(id), TaskId(on)); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "Task dependency added successfully")
+	return
+}
+
+func projectCommand(state *CommandState) (err error) {
+	if len(state.Args) == 0 {
+		err = ErrUnknownProjectAction
+		return
+	}
+
+	action := state.Args[0]
+	flags := flag.NewFlagSet("project "+action, flag.ContinueOnError)
+	reassign := flags.String("reassign", "", "move the tasks of the deleted project to this one instead of leaving them without project")
+
+	var args []string
+	if args, err = parseFlags(flags, state.Args[1:]); err != nil {
+		return
+	}
+
+	switch action {
+	case "list":
+		if len(args) != 0 {
+			err = ErrNoArgumentsAllowed
+			return
+		}
+
+		counts := state.TaskStore.ProjectCounts()
+		for _, project := range slices.Sorted(maps.Keys(counts)) {
+			fmt.Fprintf(state.IO.Out, "%-20s %d\n", project, counts[project])
+		}
+	case "rename":
+		if len(args) != 2 {
+			err = ErrOnlyTwoArgumentsAllowed
+			return
+		}
+		if !validProject(args[1]) {
+			err = ErrInvalidProject
+			return
+		}
+
+		var count int
+		if count, err = state.TaskStore.MoveProject(args[0], args[1]); err != nil {
+			return
+		}
+		if count == 0 && args[0] != args[1] {
+			err = ErrProjectNotFound
+			return
+		}
+		fmt.Fprintf(state.IO.Out, "Project renamed on %d tasks\n", count)
+	case "delete":
+		if len(args) != 1 {
+			err = ErrOnlyOneArgumentAllowed
+			return
+		}
+		if *reassign != "" && !validProject(*reassign) {
+			err = ErrInvalidProject
+			return
+		}
+
+		var count int
+		if count, err = state.TaskStore.MoveProject(args[0], *reassign); err != nil {
+			return
+		}
+		if count == 0 && args[0] != *reassign {
+			err = ErrProjectNotFound
+			return
+		}
+		if *reassign != "" {
+			fmt.Fprintf(state.IO.Out, "Project deleted, %d tasks moved to %s\n", count, *reassign)
+		} else {
+			fmt.Fprintf(state.IO.Out, "Project deleted, %d tasks left without project\n", count)
+		}
+	default:
+		err = ErrUnknownProjectAction
+	}
+
+	return
+}
+
+func idleCommand(state *CommandState) (err error) {
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var d time.Duration
+	if d, err = parseHumanDuration(state.Args[0]); err != nil {
+		return
+	}
+
+	tasks := state.TaskStore.IdleSince(time.Now(), d)
+	return tableRenderer{state.RenderOptions()}.Render(state.IO.Out, tasks)
+}
+
+func searchCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	flags.Bool("json", false, "print the matching tasks as a JSON array")
+	flags.Bool("ndjson", false, "print the matching tasks as one JSON object per line")
+	words := flags.Bool("word", false, "only match the query as a whole word")
+	failIfEmpty := flags.Bool("fail-if-empty", false, "exit with an error status when no task matches")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var rendererName string
+	if rendererName, err = chooseRenderer(flags, "matches", []rendererFlag{{"json", "json"}, {"ndjson", "ndjson"}}); err != nil {
+		return
+	}
+
+	query := state.Args[0]
+	tasks := state.TaskStore.Search(query, *words)
+
+	if rendererName == "matches" {
+		options := state.RenderOptions()
+		// matched case-insensitively, like the search
+		options.Highlight = regexp.MustCompile("(?i)" + regexp.QuoteMeta(query))
+		options.HighlightWords = *words
+		err = writeSearchMatches(state.IO.Out, tasks, strings.ToLower(query), *words, options)
+	} else {
+		var renderer Renderer
+		if renderer, err = NewRenderer(rendererName, state.RenderOptions()); err != nil {
+			return
+		}
+		err = renderer.Render(state.IO.Out, tasks)
+	}
+	if err != nil {
+		return
+	}
+
+	if *failIfEmpty && len(tasks) == 0 {
+		err = ErrNoTasksFound
+	}
+	return
+}
+
+// writeSearchMatches writes a line per task found by search, followed by
+// its tags and note lines matching query, given in lower case, with the
+// matches highlighted.
+func writeSearchMatches(w io.Writer, tasks []Task, query string, words bool, options RenderOptions) (err error) {
+	for _, task := range tasks {
+		if _, err = fmt.Fprintf(w, "#%d [%s] %s\n", task.Id, task.Status.String(), options.highlight(options.description(task))); err != nil {
+			return
+		}
+
+		for _, tag := range task.Tags {
+			if !searchMatch(tag, query, words) {
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "    tag:  %s\n", options.highlight(tag)); err != nil {
+				return
+			}
+		}
+
+		for _, note := range task.Notes {
+			for _, line := range strings.Split(note.Text, "\n") {
+				line = strings.TrimSuffix(line, "\r")
+				if !searchMatch(line, query, words) {
+					continue
+				}
+				if _, err = fmt.Fprintf(w, "    note: %s\n", options.highlight(line)); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	return
+}
+
+func listCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	summary := flags.Bool("summary", false, "print a footer with the task count per status")
+	flags.String("renderer", "table", "output format: table, box, ascii, plain, csv, json, ndjson, json-seq, oneline, porcelain or tree")
+	flags.Bool("json", false, "print the tasks as JSON, same as --renderer=json")
+	flags.Bool("json-seq", false, "print the tasks as an RFC 7464 JSON text sequence, same as --renderer=json-seq")
+	flags.Bool("tree", false, "print subtasks indented under their parent, which shows how many of them are done, same as --renderer=tree")
+	flags.Bool("table", false, "draw a table with box-drawing borders, same as --renderer=box")
+	asASCII := flags.Bool("ascii", false, "draw a table with +, - and | borders, same as --renderer=ascii")
+	timeEpoch := flags.Bool("time-epoch", false, "encode JSON timestamps as Unix seconds")
+	rawTime := flags.Bool("json-raw-time", false, "encode JSON timestamps as stored, with their original offset")
+	camel := flags.Bool("camel", false, "use camelCase JSON keys")
+	compactJSON := flags.Bool("compact-json", false, "leave unset optional fields out of the JSON tasks")
+	withSchema := flags.Bool("with-schema", false, "wrap the JSON tasks in an envelope naming their schema")
+	withMeta := flags.Bool("with-meta", false, "wrap the JSON tasks in an envelope holding the next id of the store")
+	includeArchived := flags.Bool("include-archived", false, "add the matching archived tasks to the JSON envelope, in an \"archived\" array")
+	where := flags.String("where", "", "only list the tasks matching the expression")
+	full := flags.Bool("full", false, "do not truncate long descriptions")
+	rowNumbers := flags.Bool("row-numbers", false, "number the listed tasks from 1 in the table")
+	prettyDates := flags.Bool("pretty-dates", false, "show table timestamps with their weekday, such as Mon 2024-06-03 15:04")
+	highlight := flags.String("highlight", "", "show the matches of the query in descriptions in inverse bold, when colors are enabled")
+	sinceDays := flags.Int("since-days", 0, "only list the tasks created or updated within the last N days")
+	mine := flags.Bool("mine", false, "only list the tasks assigned to the current user")
+	assignee := flags.String("assignee", "", "only list the tasks assigned to this person")
+	pinned := flags.Bool("pinned", false, "only list the pinned tasks")
+	tag := flags.String("tag", "", "only list the tasks with this tag")
+	project := flags.String("project", "", "only list the tasks of this project")
+	custom := flags.String("custom", "", "comma separated custom fields to show as table columns")
+	all := flags.Bool("all", false, "also list the tasks waiting for their wait date")
+	archived := flags.Bool("archived", false, "list the archived tasks instead of the live ones")
+	failIfEmpty := flags.Bool("fail-if-empty", false, "exit with an error status when no task is listed")
+	noPager := flags.Bool("no-pager", false, "do not page the output when it goes to a terminal")
+	offset := flags.Int("offset", 0, "skip the first N tasks")
+	limit := flags.Int("limit", 0, "show at most N tasks, 0 for no limit")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	// filter narrows and sorts the tasks of a store the way the flags ask,
+	// so archived tasks are selected like the live ones
+	filter := func(store *TaskStore) (tasks []Task, err error) {
+		if tasks, err = selectTasks(store, state.Args, *where); err != nil {
+			return
+		}
+
+		// waiting tasks are hidden unless asked for
+		if !*all && !filtersWaiting(strings.Join(state.Args, " ")+" "+*where) {
+			now := time.Now()
+			tasks = filterTasks(tasks, func(task Task) bool {
+				return !task.Waiting(now)
+			})
+		}
+
+		if flagPassed(flags, "since-days") {
+			if tasks, err = filterSinceDays(tasks, time.Now(), *sinceDays); err != nil {
+				return
+			}
+		}
+
+		if *mine {
+			me := currentUser()
+			tasks = filterTasks(tasks, func(task Task) bool {
+				return task.Assignee == me
+			})
+		}
+
+		if *assignee != "" {
+			tasks = filterTasks(tasks, func(task Task) bool {
+				return task.Assignee == *assignee
+			})
+		}
+
+		if *pinned {
+			tasks = filterTasks(tasks, func(task Task) bool {
+				return task.Pinned
+			})
+		}
+
+		if *tag != "" {
+			tasks = filterTasks(tasks, func(task Task) bool {
+				return slices.Contains(task.Tags, *tag)
+			})
+		}
+
+		if *project != "" {
+			tasks = filterTasks(tasks, func(task Task) bool {
+				return task.Project == *project
+			})
+		}
+
+		if tasks, err = sortTasks(tasks, state.Config.Sort); err != nil {
+			return
+		}
+		tasks = pinnedFirst(tasks)
+		return
+	}
+
+	var tasks []Task
+	source := state.TaskStore
+	if *archived {
+		if *includeArchived {
+			err = fmt.Errorf("%w: --archived, --include-archived", ErrConflictingFlags)
+			return
+		}
+		if source, err = state.TaskStore.OpenArchive(); err != nil {
+			return
+		}
+	}
+
+	if tasks, err = filter(source); err != nil {
+		return
+	}
+
+	// timestamps are written in UTC, --json-raw-time keeps the offset they
+	// are stored with, which Unix seconds do not have
+	if *rawTime && *timeEpoch {
+		err = fmt.Errorf("%w: --json-raw-time, --time-epoch", ErrConflictingFlags)
+		return
+	}
+
+	view := viewOptions{TimeEpoch: *timeEpoch, RawTime: *rawTime, Camel: *camel, Schema: *withSchema, Compact: *compactJSON}
+	if *withMeta {
+		view.Meta = &state.TaskStore.Meta
+	}
+
+	if flagPassed(flags, "offset") || flagPassed(flags, "limit") {
+		total := len(tasks)
+		if tasks, err = paginateTasks(tasks, *offset, *limit); err != nil {
+			return
+		}
+		view.Page = &PageInfo{Total: total, Offset: *offset, Limit: *limit}
+	}
+
+	var rendererName string
+	if rendererName, err = chooseRenderer(flags, "table", listRendererFlags); err != nil {
+		return
+	}
+
+	// --ascii only changes the borders of the box table, which it implies
+	if *asASCII {
+		if rendererName != "table" && rendererName != "box" {
+			err = fmt.Errorf("%w: --ascii only applies to --table", ErrConflictingFlags)
+			return
+		}
+		rendererName = "ascii"
+	}
+
+	if *includeArchived && rendererName != "json" {
+		err = fmt.Errorf("%w: --include-archived only applies to --json", ErrConflictingFlags)
+		return
+	}
+
+	if *includeArchived {
+		var archive *TaskStore
+		if archive, err = state.TaskStore.OpenArchive(); err != nil {
+			return
+		}
+		if view.Archived, err = filter(archive); err != nil {
+			return
+		}
+		// an empty archive still gets its array, telling it was looked at
+		if view.Archived == nil {
+			view.Archived = []Task{}
+		}
+	}
+
+	options := state.RenderOptions()
+	options.Full = *full
+	options.RowNumbers = *rowNumbers
+	options.PrettyDates = *prettyDates
+	options.View = view
+	if *custom != "" {
+		options.CustomColumns = strings.Split(*custom, ",")
+		for _, name := range options.CustomColumns {
+			if _, declared := state.Config.Fields[name]; !declared {
+				err = fmt.Errorf("%w: %s", ErrUnknownField, name)
+				return
+			}
+		}
+	}
+	if *highlight != "" {
+		// matched case-insensitively, like search
+		options.Highlight = regexp.MustCompile("(?i)" + regexp.QuoteMeta(*highlight))
+	}
+
+	var renderer Renderer
+	if renderer, err = NewRenderer(rendererName, options); err != nil {
+		return
+	}
+
+	if !*noPager {
+		stopPager := state.startPager()
+		defer func() {
+			if pagerErr := stopPager(); err == nil {
+				err = pagerErr
+			}
+		}()
+	}
+
+	if err = renderer.Render(state.IO.Out, tasks); err != nil {
+		return
+	}
+
+	if *summary {
+		fmt.Fprintln(state.IO.Out, statusSummary(tasks))
+	}
+
+	if *failIfEmpty && len(tasks) == 0 {
+		err = ErrNoTasksFound
+	}
+	return
+}
+
+// rendererFlag is a flag choosing the renderer, such as --json. Flags
+// without a renderer, such as --renderer, hold its name as their value.
+type rendererFlag struct {
+	flag     string
+	renderer string
+}
+
+var listRendererFlags = []rendererFlag{
+	{"renderer", ""},
+	{"json", "json"},
+	{"json-seq", "json-seq"},
+	{"tree", "tree"},
+	{"table", "box"},
+}
+
+// chooseRenderer returns the renderer chosen by the given flags, or
+// fallback when none of them is given. Giving more than one is an error
+// naming them.
+func chooseRenderer(flags *flag.FlagSet, fallback string, choices []rendererFlag) (renderer string, err error) {
+	renderer = fallback
+
+	var given []string
+	for _, choice := range choices {
+		value := flags.Lookup(choice.flag).Value.String()
+		if !flagPassed(flags, choice.flag) || value == "false" {
+			continue
+		}
+
+		given = append(given, "--"+choice.flag)
+		renderer = cmp.Or(choice.renderer, value)
+	}
+
+	if len(given) > 1 {
+		err = fmt.Errorf("%w: %s", ErrConflictingFlags, strings.Join(given, ", "))
+	}
+	return
+}
+
+// Renderer writes a list of tasks in one of the output formats of list.
+type Renderer interface {
+	Render(w io.Writer, tasks []Task) error
+}
+
+type RenderOptions struct {
+	// Full disables truncating long descriptions.
+	Full bool
+	// Color enables ANSI colors in formats meant for humans.
+	Color bool
+	// RowNumbers adds a leading column numbering the rendered rows from 1.
+	RowNumbers bool
+	// TimeFormat is the layout of timestamps in formats meant for humans.
+	TimeFormat string
+	// DescWidth is the number of runes descriptions are truncated to, 0
+	// meaning no limit. It is ignored when Full is set.
+	DescWidth int
+	// PrettyDates shows timestamps with their weekday instead of using
+	// TimeFormat.
+	PrettyDates bool
+	// Highlight, when set along with Color, matches the parts of the
+	// descriptions shown in inverse bold.
+	Highlight *regexp.Regexp
+	// HighlightWords only highlights the matches of Highlight that are
+	// whole words.
+	HighlightWords bool
+	// CustomColumns are the custom fields shown as columns in the table,
+	// after the built-in ones.
+	CustomColumns []string
+	View          viewOptions
+}
+
+var renderersMap = map[string]func(RenderOptions) Renderer{
+	"table":     func(options RenderOptions) Renderer { return tableRenderer{options} },
+	"plain":     func(options RenderOptions) Renderer { return plainRenderer{options} },
+	"csv":       func(options RenderOptions) Renderer { return csvRenderer{} },
+	"json":      func(options RenderOptions) Renderer { return jsonRenderer{options} },
+	"ndjson":    func(options RenderOptions) Renderer { return ndjsonRenderer{options} },
+	"json-seq":  func(options RenderOptions) Renderer { return jsonSeqRenderer{options} },
+	"oneline":   func(options RenderOptions) Renderer { return onelineRenderer{options} },
+	"porcelain": func(options RenderOptions) Renderer { return porcelainRenderer{} },
+	"tree":      func(options RenderOptions) Renderer { return treeRenderer{options} },
+	"box":       func(options RenderOptions) Renderer { return boxRenderer{options, unicodeBoxStyle} },
+	"ascii":     func(options RenderOptions) Renderer { return boxRenderer{options, asciiBoxStyle} },
+}
+
+func NewRenderer(name string, options RenderOptions) (renderer Renderer, err error) {
+	newRenderer, ok := renderersMap[name]
+	if !ok {
+		err = ErrUnknownRenderer
+		return
+	}
+
+	return newRenderer(options), nil
+}
+
+// formatTime formats a timestamp for the formats meant for humans.
+func (options RenderOptions) formatTime(t time.Time) string {
+	if options.PrettyDates {
+		return formatPrettyDate(t)
+	}
+	return t.Format(options.TimeFormat)
+}
+
+// prettyDateLayout is the layout of --pretty-dates, such as
+// "Mon 2024-06-03 15:04".
+const prettyDateLayout = "Mon 2006-01-02 15:04"
+
+func formatPrettyDate(t time.Time) string {
+	return t.Format(prettyDateLayout)
+}
+
+func (options RenderOptions) description(task Task) string {
+	if options.Full || options.DescWidth == 0 {
+		return task.Description
+	}
+	return truncateDescription(task.Description, options.DescWidth)
+}
+
+// highlightStart and highlightEnd wrap the highlighted parts of the
+// descriptions, drawing them in inverse bold.
+const (
+	highlightStart = "\x1b[1;7m"
+	highlightEnd   = "\x1b[0m"
+)
+
+// highlight wraps the matches of the Highlight query in str with escape
+// codes, leaving str as is without color.
+func (options RenderOptions) highlight(str string) string {
+	if !options.Color || options.Highlight == nil {
+		return str
+	}
+	if !options.HighlightWords {
+		return options.Highlight.ReplaceAllStringFunc(str, func(match string) string {
+			return highlightStart + match + highlightEnd
+		})
+	}
+
+	var highlighted strings.Builder
+	last := 0
+	for _, match := range options.Highlight.FindAllStringIndex(str, -1) {
+		if !wholeWord(str, match[0], match[1]) {
+			continue
+		}
+		highlighted.WriteString(str[last:match[0]])
+		highlighted.WriteString(highlightStart + str[match[0]:match[1]] + highlightEnd)
+		last = match[1]
+	}
+	highlighted.WriteString(str[last:])
+	return highlighted.String()
+}
+
+// tableRenderer writes the tasks as padded columns under a header.
+type tableRenderer struct {
+	options RenderOptions
+}
+
+func (renderer tableRenderer) Render(w io.Writer, tasks []Task) (err error) {
+	maxStatusLen := max(displayWidth(TaskStatusTodo.String()), displayWidth(TaskStatusInProgress.String()), displayWidth(TaskStatusDone.String()))
+	dateLen := max(displayWidth(renderer.options.formatTime(time.Now())), displayWidth("created at"))
+	rowLen := len(strconv.Itoa(len(tasks)))
+	idLen := displayWidth("id")
+	// the due and notes columns are only shown when some task has a due
+	// date or notes, and the time column when time was tracked on one
+	hasDue, hasNotes, hasTime := false, false, false
+	for _, task := range tasks {
+		idLen = max(idLen, len(strconv.FormatUint(uint64(task.Id), 10)))
+		hasDue = hasDue || task.DueAt != nil
+		hasNotes = hasNotes || len(task.Notes) != 0
+		hasTime = hasTime || len(task.Sessions) != 0
+	}
+	notesLen := displayWidth("notes")
+	dueLen := len(time.DateOnly)
+	now := time.Now()
+
+	// the time and custom columns are as wide as their widest cell
+	timeLen := displayWidth("time")
+	if hasTime {
+		for _, task := range tasks {
+			timeLen = max(timeLen, len(formatSpent(task.TimeSpent(time.Time{}, time.Time{}, now))))
+		}
+	}
+	customLens := make([]int, len(renderer.options.CustomColumns))
+	for i, name := range renderer.options.CustomColumns {
+		customLens[i] = displayWidth(name)
+		for _, task := range tasks {
+			customLens[i] = max(customLens[i], displayWidth(formatCustomValue(task.Custom[name])))
+		}
+	}
+
+	// pad fills a cell up to width columns plus the gap between columns,
+	// measuring by display width so wide characters keep columns aligned
+	pad := func(cell string, width int) string {
+		return cell + "    " + strings.Repeat(" ", max(0, width-displayWidth(cell)))
+	}
+
+	{
+		header := strings.Builder{}
+		if renderer.options.RowNumbers {
+			header.WriteString(pad("#", rowLen))
+		}
+		header.WriteString(pad("id", idLen))
+		header.WriteString(pad("status", maxStatusLen))
+		header.WriteString(pad("created at", dateLen))
+		header.WriteString(pad("updated at", dateLen))
+		if hasDue {
+			header.WriteString(pad("due", dueLen))
+		}
+		if hasNotes {
+			header.WriteString(pad("notes", notesLen))
+		}
+		if hasTime {
+			header.WriteString(pad("time", timeLen))
+		}
+		for i, name := range renderer.options.CustomColumns {
+			header.WriteString(pad(name, customLens[i]))
+		}
+		header.WriteString("description")
+		if _, err = fmt.Fprintln(w, header.String()); err != nil {
+			return
+		}
+	}
+
+	for i, task := range tasks {
+		id := strconv.FormatUint(uint64(task.Id), 10)
+		status := task.Status.String()
+
+		body := strings.Builder{}
+		body.Grow(64 + min(len(task.Description), 4*maxDescriptionWidth))
+		if renderer.options.RowNumbers {
+			body.WriteString(pad(strconv.Itoa(i+1), rowLen))
+		}
+		body.WriteString(pad(id, idLen))
+		if renderer.options.Color {
+			// the escape codes take no columns, so pad by the bare status
+			body.WriteString(colorizeStatus(task.Status))
+			body.WriteString(strings.TrimPrefix(pad(status, maxStatusLen), status))
+		} else {
+			body.WriteString(pad(status, maxStatusLen))
+		}
+		body.WriteString(pad(renderer.options.formatTime(task.CreatedAt), dateLen))
+		body.WriteString(pad(renderer.options.formatTime(task.UpdatedAt), dateLen))
+		if hasDue {
+			due := "-"
+			if task.DueAt != nil {
+				due = task.DueAt.Format(time.DateOnly)
+			}
+			if renderer.options.Color && task.Overdue(now) {
+				// overdue dates are red, padded by their bare width
+				body.WriteString("\x1b[31m" + due + "\x1b[0m")
+				body.WriteString(strings.TrimPrefix(pad(due, dueLen), due))
+			} else {
+				body.WriteString(pad(due, dueLen))
+			}
+		}
+		if hasNotes {
+			body.WriteString(pad(strconv.Itoa(len(task.Notes)), notesLen))
+		}
+		if hasTime {
+			body.WriteString(pad(formatSpent(task.TimeSpent(time.Time{}, time.Time{}, now)), timeLen))
+		}
+		for i, name := range renderer.options.CustomColumns {
+			body.WriteString(pad(formatCustomValue(task.Custom[name]), customLens[i]))
+		}
+		if renderer.options.Color && task.Color != "" {
+			body.WriteString(colorDot(task.Color) + " ")
+		}
+		if progress := task.checklistProgress(); progress != "" {
+			body.WriteString(progress + " ")
+		}
+		body.WriteString(renderer.options.highlight(renderer.options.description(task)))
+		if _, err = fmt.Fprintln(w, body.String()); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+var statusColorsMap = map[TaskStatus]string{
+	TaskStatusTodo:       "\x1b[33m",
+	TaskStatusInProgress: "\x1b[34m",
+	TaskStatusDone:       "\x1b[32m",
+}
+
+// taskColorsMap holds the colors a task can be labeled with.
+var taskColorsMap = map[string]string{
+	"red":     "\x1b[31m",
+	"green":   "\x1b[32m",
+	"yellow":  "\x1b[33m",
+	"blue":    "\x1b[34m",
+	"magenta": "\x1b[35m",
+	"cyan":    "\x1b[36m",
+	"white":   "\x1b[37m",
+}
+
+func colorDot(color string) string {
+	return taskColorsMap[color] + "●" + "\x1b[0m"
+}
+
+func colorizeStatus(status TaskStatus) string {
+	return statusColorsMap[status] + status.String() + "\x1b[0m"
+}
+
+// plainRenderer writes the id, status and description of each task
+// separated by tabs, without a header, for use with cut and friends.
+type plainRenderer struct {
+	options RenderOptions
+}
+
+func (renderer plainRenderer) Render(w io.Writer, tasks []Task) (err error) {
+	for _, task := range tasks {
+		if _, err = fmt.Fprintf(w, "%d\t%s\t%s\n", task.Id, task.Status.String(), renderer.options.description(task)); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// csvRenderer writes the tasks as CSV with a header row, in the layout
+// read back by the import command.
+type csvRenderer struct{}
+
+func (csvRenderer) Render(w io.Writer, tasks []Task) (err error) {
+	writer := csv.NewWriter(w)
+	if err = writer.Write([]string{"id", "description", "status", "created_at", "updated_at"}); err != nil {
+		return
+	}
+
+	for _, task := range tasks {
+		record := []string{
+			strconv.FormatUint(uint64(task.Id), 10),
+			task.Description,
+			task.Status.String(),
+			task.CreatedAt.Format(time.RFC3339),
+			task.UpdatedAt.Format(time.RFC3339),
+		}
+		if err = writer.Write(record); err != nil {
+			return
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+type jsonRenderer struct {
+	options RenderOptions
+}
+
+func (renderer jsonRenderer) Render(w io.Writer, tasks []Task) error {
+	return writeTasksJSON(w, tasks, renderer.options.View)
+}
+
+type ndjsonRenderer struct {
+	options RenderOptions
+}
+
+func (renderer ndjsonRenderer) Render(w io.Writer, tasks []Task) error {
+	return writeTasksNDJSON(w, tasks, renderer.options.View)
+}
+
+type jsonSeqRenderer struct {
+	options RenderOptions
+}
+
+func (renderer jsonSeqRenderer) Render(w io.Writer, tasks []Task) error {
+	return writeTasksJSONSeq(w, tasks, renderer.options.View)
+}
+
+// onelineRenderer writes each task as a short sentence-like line.
+type onelineRenderer struct {
+	options RenderOptions
+}
+
+func (renderer onelineRenderer) Render(w io.Writer, tasks []Task) (err error) {
+	for _, task := range tasks {
+		if _, err = fmt.Fprintf(w, "#%d [%s] %s\n", task.Id, task.Status.String(), renderer.options.highlight(renderer.options.description(task))); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// boxStyle holds the characters a boxRenderer draws its borders with. The
+// corners and junctions are named after their row, top, middle or bottom,
+// and column, left, middle or right.
+type boxStyle struct {
+	horizontal, vertical                  string
+	topLeft, topMiddle, topRight          string
+	middleLeft, middleMiddle, middleRight string
+	bottomLeft, bottomMiddle, bottomRight string
+}
+
+var unicodeBoxStyle = boxStyle{
+	"─", "│",
+	"┌", "┬", "┐",
+	"├", "┼", "┤",
+	"└", "┴", "┘",
+}
+
+var asciiBoxStyle = boxStyle{
+	"-", "|",
+	"+", "+", "+",
+	"+", "+", "+",
+	"+", "+", "+",
+}
+
+// maxBoxDescriptionWidth is the width past which descriptions wrap in the
+// box renderer.
+const maxBoxDescriptionWidth = 40
+
+// boxRenderer writes the tasks as a table with borders, its columns as
+// wide as their content. Descriptions are wrapped over several lines
+// instead of being truncated.
+type boxRenderer struct {
+	options RenderOptions
+	style   boxStyle
+}
+
+func (renderer boxRenderer) Render(w io.Writer, tasks []Task) (err error) {
+	header := []string{"id", "status", "created at", "updated at", "description"}
+	if renderer.options.RowNumbers {
+		header = slices.Insert(header, 0, "#")
+	}
+
+	// every cell is a list of lines, only descriptions have more than one
+	rows := make([][][]string, 0, len(tasks)+1)
+	headerRow := make([][]string, len(header))
+	for i, title := range header {
+		headerRow[i] = []string{title}
+	}
+	rows = append(rows, headerRow)
+
+	for i, task := range tasks {
+		description := wrapText(task.Description, maxBoxDescriptionWidth)
+		for j, line := range description {
+			description[j] = renderer.options.highlight(line)
+		}
+
+		row := [][]string{
+			{strconv.FormatUint(uint64(task.Id), 10)},
+			{task.Status.String()},
+			{renderer.options.formatTime(task.CreatedAt)},
+			{renderer.options.formatTime(task.UpdatedAt)},
+			description,
+		}
+		if renderer.options.RowNumbers {
+			row = slices.Insert(row, 0, []string{strconv.Itoa(i + 1)})
+		}
+		rows = append(rows, row)
+	}
+
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for i, cell := range row {
+			for _, line := range cell {
+				widths[i] = max(widths[i], displayWidth(line))
+			}
+		}
+	}
+
+	style := renderer.style
+	border := func(left, middle, right string) string {
+		segments := make([]string, len(widths))
+		for i, width := range widths {
+			segments[i] = strings.Repeat(style.horizontal, width+2)
+		}
+		return left + strings.Join(segments, middle) + right
+	}
+
+	var table strings.Builder
+	table.WriteString(border(style.topLeft, style.topMiddle, style.topRight) + "\n")
+
+	for i, row := range rows {
+		if i == 1 {
+			table.WriteString(border(style.middleLeft, style.middleMiddle, style.middleRight) + "\n")
+		}
+
+		height := 0
+		for _, cell := range row {
+			height = max(height, len(cell))
+		}
+
+		for lineIndex := range height {
+			table.WriteString(style.vertical)
+			for column, cell := range row {
+				line := ""
+				if lineIndex < len(cell) {
+					line = cell[lineIndex]
+				}
+				table.WriteString(" " + line + strings.Repeat(" ", widths[column]-displayWidth(line)) + " ")
+				table.WriteString(style.vertical)
+			}
+			table.WriteString("\n")
+		}
+	}
+
+	table.WriteString(border(style.bottomLeft, style.bottomMiddle, style.bottomRight) + "\n")
+
+	_, err = io.WriteString(w, table.String())
+	return
+}
+
+// wrapText splits str into lines at most width columns wide, breaking between
+// words when possible and keeping the line breaks it already has.
+func wrapText(str string, width int) (lines []string) {
+	for _, paragraph := range strings.Split(str, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			// words longer than a line are split wherever they reach the width
+			for displayWidth(word) > width {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				head, tail := cutWidth(word, width)
+				lines = append(lines, head)
+				word = tail
+			}
+
+			switch {
+			case line == "":
+				line = word
+			case displayWidth(line)+1+displayWidth(word) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	return
+}
+
+// wideRanges lists the code points that terminals draw two columns wide:
+// the East Asian Wide and Fullwidth blocks plus the emoji pictographs.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115f},   // Hangul Jamo initials
+	{0x231a, 0x231b},   // watch, hourglass
+	{0x23e9, 0x23ec},   // media controls
+	{0x23f0, 0x23f0},   // alarm clock
+	{0x23f3, 0x23f3},   // hourglass with flowing sand
+	{0x25fd, 0x25fe},   // small squares
+	{0x2614, 0x2615},   // umbrella, hot beverage
+	{0x2648, 0x2653},   // zodiac
+	{0x267f, 0x267f},   // wheelchair
+	{0x2693, 0x2693},   // anchor
+	{0x26a1, 0x26a1},   // high voltage
+	{0x26aa, 0x26ab},   // circles
+	{0x26bd, 0x26be},   // balls
+	{0x26c4, 0x26c5},   // snowman, sun behind cloud
+	{0x26ce, 0x26ce},   // ophiuchus
+	{0x26d4, 0x26d4},   // no entry
+	{0x26ea, 0x26ea},   // church
+	{0x26f2, 0x26f3},   // fountain, golf
+	{0x26f5, 0x26f5},   // sailboat
+	{0x26fa, 0x26fa},   // tent
+	{0x26fd, 0x26fd},   // fuel pump
+	{0x2705, 0x2705},   // check mark button
+	{0x270a, 0x270b},   // raised fists
+	{0x2728, 0x2728},   // sparkles
+	{0x274c, 0x274c},   // cross mark
+	{0x274e, 0x274e},   // cross mark button
+	{0x2753, 0x2755},   // question and exclamation marks
+	{0x2757, 0x2757},   // exclamation mark
+	{0x2795, 0x2797},   // plus, minus, divide
+	{0x27b0, 0x27b0},   // curly loop
+	{0x27bf, 0x27bf},   // double curly loop
+	{0x2b1b, 0x2b1c},   // large squares
+	{0x2b50, 0x2b50},   // star
+	{0x2b55, 0x2b55},   // circle
+	{0x2e80, 0x303e},   // CJK radicals, symbols and punctuation
+	{0x3041, 0x33ff},   // kana, bopomofo, CJK compatibility
+	{0x3400, 0x4dbf},   // CJK extension A
+	{0x4e00, 0x9fff},   // CJK unified ideographs
+	{0xa000, 0xa4cf},   // Yi
+	{0xa960, 0xa97f},   // Hangul Jamo extended A
+	{0xac00, 0xd7a3},   // Hangul syllables
+	{0xf900, 0xfaff},   // CJK compatibility ideographs
+	{0xfe10, 0xfe19},   // vertical forms
+	{0xfe30, 0xfe6f},   // CJK compatibility forms, small forms
+	{0xff00, 0xff60},   // fullwidth forms
+	{0xffe0, 0xffe6},   // fullwidth signs
+	{0x16fe0, 0x18aff}, // Tangut
+	{0x1b000, 0x1b2ff}, // kana supplement, Nushu
+	{0x1f004, 0x1f004}, // mahjong tile
+	{0x1f0cf, 0x1f0cf}, // joker
+	{0x1f18e, 0x1f18e}, // AB button
+	{0x1f191, 0x1f19a}, // squared words
+	{0x1f200, 0x1f2ff}, // enclosed ideographic supplement
+	{0x1f300, 0x1f64f}, // pictographs, emoticons
+	{0x1f680, 0x1f6ff}, // transport and map symbols
+	{0x1f7e0, 0x1f7eb}, // large colored circles and squares
+	{0x1f90c, 0x1f9ff}, // supplemental pictographs
+	{0x1fa70, 0x1faff}, // symbols and pictographs extended A
+	{0x20000, 0x3fffd}, // CJK extensions B and beyond
+}
+
+// runeWidth returns how many terminal columns r takes: 0 for combining
+// marks and format characters, 2 for wide characters and 1 otherwise.
+func runeWidth(r rune) int {
+	switch {
+	case r == 0, unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r < 0x1100:
+		return 1
+	}
+	if _, found := slices.BinarySearchFunc(wideRanges, r, func(span [2]rune, r rune) int {
+		switch {
+		case span[1] < r:
+			return -1
+		case span[0] > r:
+			return 1
+		}
+		return 0
+	}); found {
+		return 2
+	}
+	return 1
+}
+
+// displayWidth returns how many terminal columns s takes, so text mixing
+// ASCII, CJK and emoji can be padded into aligned columns. ANSI escape
+// sequences, such as colors and highlights, take none.
+func displayWidth(s string) (width int) {
+	inEscape := false
+	for i, r := range s {
+		switch {
+		case inEscape:
+			// a CSI sequence ends with a byte in the @ to ~ range
+			if i > 0 && s[i-1] != '\x1b' && r >= '@' && r <= '~' {
+				inEscape = false
+			}
+		case r == '\x1b':
+			inEscape = true
+		default:
+			width += runeWidth(r)
+		}
+	}
+	return
+}
+
+// cutWidth splits s after as many runes as fit in width columns, always
+// keeping at least one rune in head so callers make progress.
+func cutWidth(s string, width int) (head, tail string) {
+	used := 0
+	for i, r := range s {
+		w := runeWidth(r)
+		if used+w > width && i > 0 {
+			return s[:i], s[i:]
+		}
+		used += w
+	}
+	return s, ""
+}
+
+// treeRenderer writes each task followed by its subtasks, indented one
+// level deeper and with a checkbox telling whether they are done. Tasks
+// with subtasks end with how many of the listed ones are done. Tasks whose
+// parent is not in the list are shown at the top level.
+type treeRenderer struct {
+	options RenderOptions
+}
+
+func (renderer treeRenderer) Render(w io.Writer, tasks []Task) (err error) {
+	children := make(map[TaskId][]Task)
+	listed := make(map[TaskId]bool, len(tasks))
+	for _, task := range tasks {
+		listed[task.Id] = true
+	}
+
+	var roots []Task
+	for _, task := range tasks {
+		if task.ParentId != 0 && listed[task.ParentId] && task.ParentId != task.Id {
+			children[task.ParentId] = append(children[task.ParentId], task)
+		} else {
+			roots = append(roots, task)
+		}
+	}
+
+	// visited guards against parent cycles in hand edited stores
+	visited := make(map[TaskId]bool, len(tasks))
+
+	// rollup counts the done and total subtasks under task, at any depth,
+	// leaving out the ones already listed above it in a parent cycle
+	var rollup func(task Task, seen map[TaskId]bool) (done, total int)
+	rollup = func(task Task, seen map[TaskId]bool) (done, total int) {
+		for _, child := range children[task.Id] {
+			if seen[child.Id] || visited[child.Id] {
+				continue
+			}
+			seen[child.Id] = true
+
+			total++
+			if child.Status == TaskStatusDone {
+				done++
+			}
+			childDone, childTotal := rollup(child, seen)
+			done += childDone
+			total += childTotal
+		}
+		return
+	}
+
+	var render func(task Task, depth int) error
+	render = func(task Task, depth int) (err error) {
+		if visited[task.Id] {
+			return
+		}
+		visited[task.Id] = true
+
+		description := renderer.options.highlight(renderer.options.description(task))
+		if done, total := rollup(task, map[TaskId]bool{task.Id: true}); total > 0 {
+			description += fmt.Sprintf(" (%d/%d done, %d%%)", done, total, done*100/total)
+		}
+		if depth == 0 {
+			_, err = fmt.Fprintf(w, "#%d [%s] %s\n", task.Id, task.Status.String(), description)
+		} else {
+			checkbox := "[ ]"
+			if task.Status == TaskStatusDone {
+				checkbox = "[x]"
+			}
+			_, err = fmt.Fprintf(w, "%s%s #%d %s\n", strings.Repeat("    ", depth), checkbox, task.Id, description)
+		}
+		if err != nil {
+			return
+		}
+
+		for _, child := range children[task.Id] {
+			if err = render(child, depth+1); err != nil {
+				return
+			}
+		}
+
+		return
+	}
+
+	for _, task := range roots {
+		if err = render(task, 0); err != nil {
+			return
+		}
+	}
+
+	// tasks in a parent cycle have no root above them, they are listed
+	// from the first of them instead of being left out
+	for _, task := range tasks {
+		if err = render(task, 0); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// porcelainRenderer writes a format meant for scripts that is kept stable
+// across versions: tab separated id, status, RFC 3339 UTC timestamps and
+// description, with backslashes, tabs and newlines escaped.
+type porcelainRenderer struct{}
+
+var porcelainEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"\t", "\\t",
+	"\r", "\\r",
+	"\n", "\\n",
+)
+
+func (porcelainRenderer) Render(w io.Writer, tasks []Task) (err error) {
+	for _, task := range tasks {
+		if _, err = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			task.Id,
+			task.Status.String(),
+			task.CreatedAt.UTC().Format(time.RFC3339),
+			task.UpdatedAt.UTC().Format(time.RFC3339),
+			porcelainEscaper.Replace(task.Description),
+		); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// maxDescriptionWidth is the default number of runes of a description
+// shown in the task table, see the desc_width setting.
+const maxDescriptionWidth = 80
+
+// truncateDescription cuts desc to at most width runes, followed by how
+// many runes were left out. It never decodes the description into a rune
+// slice, so huge descriptions do not allocate more than short ones.
+func truncateDescription(desc string, width int) string {
+	count := 0
+	for i := range desc {
+		if count == width {
+			return desc[:i] + fmt.Sprintf("…(+%d chars)", utf8.RuneCountInString(desc[i:]))
+		}
+		count++
+	}
+
+	return desc
+}
+
+// paginateTasks returns the tasks left after skipping offset of them,
+// keeping at most limit. A limit of 0 keeps every task.
+func paginateTasks(tasks []Task, offset, limit int) ([]Task, error) {
+	if offset < 0 {
+		return nil, ErrInvalidOffset
+	}
+	if limit < 0 {
+		return nil, ErrInvalidLimit
+	}
+
+	tasks = tasks[min(offset, len(tasks)):]
+	if limit > 0 && limit < len(tasks) {
+		tasks = tasks[:limit]
+	}
+
+	return tasks, nil
+}
+
+// parseHumanDuration parses a duration such as "7d", "2w" or "1d12h". On
+// top of the units of time.ParseDuration it accepts d for days and w for
+// weeks, which must come first.
+func parseHumanDuration(str string) (d time.Duration, err error) {
+	rest := str
+	for _, unit := range []struct {
+		suffix string
+		length time.Duration
+	}{{"w", 7 * 24 * time.Hour}, {"d", 24 * time.Hour}} {
+		before, after, found := strings.Cut(rest, unit.suffix)
+		if !found {
+			continue
+		}
+
+		var n uint64
+		if n, err = strconv.ParseUint(before, 10, 32); err != nil {
+			err = ErrInvalidDuration
+			return
+		}
+		d += time.Duration(n) * unit.length
+		rest = after
+	}
+
+	if rest != "" {
+		var clock time.Duration
+		if clock, err = time.ParseDuration(rest); err != nil || clock < 0 {
+			err = ErrInvalidDuration
+			return
+		}
+		d += clock
+	} else if rest == str {
+		err = ErrInvalidDuration
+	}
+
+	return
+}
+
+// selectTasks returns the tasks matching the filter expression given as
+// arguments, such as a status or `status=todo and tag=work`, and the
+// --where expression accepted by the listing commands. The arguments are
+// joined with spaces, so the expression does not need quoting unless it
+// has parentheses or quoted values.
+func selectTasks(store *TaskStore, args []string, where string) (tasks []Task, err error) {
+	tasks = store.Tasks
+
+	for _, expr := range []string{strings.Join(args, " "), where} {
+		if expr == "" {
+			continue
+		}
+
+		var predicate TaskPredicate
+		if predicate, err = parseWhere(expr, store); err != nil {
+			return
+		}
+
+		tasks = filterTasks(tasks, predicate)
+	}
+
+	return
+}
+
+// currentUser returns the name of the user running the command, taken from
+// $USER or else from the operating system.
+func currentUser() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return ""
+}
+
+// filterSinceDays keeps the tasks created or updated within the last days
+// days before now. As a task is updated when created, only its last update
+// is looked at.
+func filterSinceDays(tasks []Task, now time.Time, days int) ([]Task, error) {
+	if days <= 0 {
+		return nil, ErrInvalidDays
+	}
+
+	cutoff := now.AddDate(0, 0, -days)
+	return filterTasks(tasks, func(task Task) bool {
+		return !task.UpdatedAt.Before(cutoff)
+	}), nil
+}
+
+func countCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("count", flag.ContinueOnError)
+	where := flags.String("where", "", "only count the tasks matching the expression")
+	sinceDays := flags.Int("since-days", 0, "only count the tasks created or updated within the last N days")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	var tasks []Task
+	if tasks, err = selectTasks(state.TaskStore, state.Args, *where); err != nil {
+		return
+	}
+
+	if flagPassed(flags, "since-days") {
+		if tasks, err = filterSinceDays(tasks, time.Now(), *sinceDays); err != nil {
+			return
+		}
+	}
+
+	fmt.Fprintln(state.IO.Out, len(tasks))
+	return
+}
+
+func statusSummary(tasks []Task) string {
+	counts := countByStatus(tasks)
+	return fmt.Sprintf("— %d %s, %d %s, %d %s",
+		counts[TaskStatusTodo], TaskStatusTodo.String(),
+		counts[TaskStatusInProgress], TaskStatusInProgress.String(),
+		counts[TaskStatusDone], TaskStatusDone.String(),
+	)
+}
+
+type TextStats struct {
+	Runes int
+	Words int
+	Lines int
+}
+
+func describeText(str string) (stats TextStats) {
+	stats.Runes = utf8.RuneCountInString(str)
+	stats.Words = len(strings.Fields(str))
+	if str != "" {
+		stats.Lines = strings.Count(strings.TrimSuffix(str, "\n"), "\n") + 1
+	}
+
+	return
+}
+
+func showCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("show", flag.ContinueOnError)
+	withStats := flags.Bool("stats", false, "print character, word and line counts of the description")
+	withHistory := flags.Bool("history", false, "print the status transitions of the task")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	fmt.Fprintln(state.IO.Out, "id:         ", task.Id)
+	fmt.Fprintln(state.IO.Out, "status:     ", task.Status.String())
+	fmt.Fprintln(state.IO.Out, "priority:   ", task.Priority.String())
+	if task.Project != "" {
+		fmt.Fprintln(state.IO.Out, "project:    ", task.Project)
+	}
+	if task.Assignee != "" {
+		fmt.Fprintln(state.IO.Out, "assignee:   ", task.Assignee)
+	}
+	for _, name := range slices.Sorted(maps.Keys(task.Custom)) {
+		fmt.Fprintf(state.IO.Out, "%-12s %s\n", name+":", formatCustomValue(task.Custom[name]))
+	}
+	if task.Recurrence != "" {
+		fmt.Fprintln(state.IO.Out, "every:      ", task.Recurrence)
+	}
+	if len(task.DependsOn) != 0 {
+		ids := make([]string, len(task.DependsOn))
+		for i, id := range normalizeIds(task.DependsOn) {
+			ids[i] = "#" + strconv.FormatUint(uint64(id), 10)
+		}
+		blocked := ""
+		if task.Blocked(state.TaskStore) {
+			blocked = " (blocked)"
+		}
+		fmt.Fprintln(state.IO.Out, "depends on: ", strings.Join(ids, " ")+blocked)
+	}
+	fmt.Fprintln(state.IO.Out, "created at: ", task.CreatedAt.Format(state.Config.TimeLayout()))
+	fmt.Fprintln(state.IO.Out, "updated at: ", task.UpdatedAt.Format(state.Config.TimeLayout()))
+	if task.DueAt != nil {
+		fmt.Fprintln(state.IO.Out, "due:        ", task.DueAt.Format(time.DateOnly))
+	} else {
+		fmt.Fprintln(state.IO.Out, "due:        ", "-")
+	}
+	if task.Estimate != 0 {
+		fmt.Fprintln(state.IO.Out, "estimate:   ", formatSpent(task.Estimate), "("+formatSpent(task.Remaining(time.Now()))+" left)")
+	}
+	if task.WaitUntil != nil {
+		fmt.Fprintln(state.IO.Out, "wait until: ", task.WaitUntil.Format(time.DateOnly))
+	}
+	if task.CompletedAt != nil {
+		fmt.Fprintln(state.IO.Out, "completed:  ", task.CompletedAt.Format(state.Config.TimeLayout()))
+	}
+	if task.RemindAt != nil {
+		fmt.Fprintln(state.IO.Out, "remind at:  ", task.RemindAt.Format(state.Config.TimeLayout()))
+	}
+	fmt.Fprintln(state.IO.Out, "description:", task.Description)
+
+	if len(task.Checklist) != 0 {
+		fmt.Fprintln(state.IO.Out)
+		fmt.Fprintln(state.IO.Out, "checklist:  ", task.checklistProgress())
+		for i, item := range task.Checklist {
+			checkbox := "[ ]"
+			if item.Done {
+				checkbox = "[x]"
+			}
+			fmt.Fprintf(state.IO.Out, "    %d. %s %s\n", i+1, checkbox, item.Text)
+		}
+	}
+
+	if len(task.Attachments) != 0 {
+		fmt.Fprintln(state.IO.Out)
+		fmt.Fprintln(state.IO.Out, "attachments:")
+		for _, attachment := range task.Attachments {
+			if attachment.URL != "" {
+				fmt.Fprintln(state.IO.Out, "   ", attachment.URL)
+			} else {
+				fmt.Fprintf(state.IO.Out, "    %s (%s)\n", attachment.Name, formatSize(attachment.Size))
+			}
+		}
+	}
+
+	if len(task.Notes) != 0 {
+		fmt.Fprintln(state.IO.Out)
+		fmt.Fprintln(state.IO.Out, "notes:")
+		writeNotes(state, task.Notes)
+	}
+
+	if *withStats {
+		stats := describeText(task.Description)
+		fmt.Fprintln(state.IO.Out)
+		fmt.Fprintln(state.IO.Out, "characters: ", stats.Runes)
+		fmt.Fprintln(state.IO.Out, "words:      ", stats.Words)
+		fmt.Fprintln(state.IO.Out, "lines:      ", stats.Lines)
+	}
+
+	if *withHistory {
+		fmt.Fprintln(state.IO.Out)
+		fmt.Fprintln(state.IO.Out, "history:")
+		for _, change := range task.StatusHistory {
+			if change.Note == "" {
+				fmt.Fprintln(state.IO.Out, "   ", change.At.Format(state.Config.TimeLayout()), change.Status.String())
+			} else {
+				fmt.Fprintln(state.IO.Out, "   ", change.At.Format(state.Config.TimeLayout()), change.Status.String(), "-", change.Note)
+			}
+		}
+	}
+
+	return
+}
+
+func historyCommand(state *CommandState) (err error) {
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	var id uint64
+	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+		return
+	}
+
+	var task Task
+	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+		return
+	}
+
+	if len(task.History) == 0 {
+		fmt.Fprintln(state.IO.Out, "No history")
+		return
+	}
+
+	for _, change := range task.History {
+		fmt.Fprintf(state.IO.Out, "%s    %s: %s -> %s\n", change.At.Format(state.Config.TimeLayout()), change.Field, formatFieldValue(change.Field, change.Before), formatFieldValue(change.Field, change.After))
+	}
+
+	return
+}
+
+// formatFieldValue formats a value of field in the change history, (none)
+// standing for an unset field and statuses, stored as numbers, by name.
+func formatFieldValue(field string, value json.RawMessage) string {
+	if len(value) == 0 || string(value) == "null" {
+		return "(none)"
+	}
+
+	var status TaskStatus
+	if field == "status" && json.Unmarshal(value, &status) == nil {
+		return status.String()
+	}
+	return string(value)
+}
+
+func exportCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	icsPath := flags.String("ics", "", "write tasks as iCalendar VTODO entries")
+	zipPath := flags.String("zip", "", "write a backup of the tasks, their journal, the archive, the templates, the attachments and the config file")
+	sqlPath := flags.String("sql", "", "write a SQL script creating and filling a tasks table, for sqlite3")
+	templatePath := flags.String("template", "", "render the store through a Go template file into the file given as argument")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if *templatePath != "" {
+		if len(state.Args) != 1 {
+			err = ErrTemplateOutput
+			return
+		}
+
+		out := state.Args[0]
+		ext := strings.ToLower(path.Ext(out))
+		html := ext == ".html" || ext == ".htm"
+		// a failing template must not leave a truncated output file behind
+		var rendered bytes.Buffer
+		if err = state.TaskStore.ExportTemplate(&rendered, *templatePath, html); err != nil {
+			return
+		}
+		if err = exportFile(out, func(w io.Writer) (err error) {
+			_, err = w.Write(rendered.Bytes())
+			return
+		}); err != nil {
+			return
+		}
+		fmt.Fprintln(state.IO.Out, "Tasks exported to", out)
+		return
+	}
+
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
+		return
+	}
+
+	if *icsPath == "" && *zipPath == "" && *sqlPath == "" {
+		err = ErrNoExportFormat
+		return
+	}
+
+	if *icsPath != "" {
+		if err = exportFile(*icsPath, state.TaskStore.ExportICS); err != nil {
+			return
+		}
+		fmt.Fprintln(state.IO.Out, "Tasks exported to", *icsPath)
+	}
+
+	if *zipPath != "" {
+		if err = exportFile(*zipPath, state.TaskStore.ExportBackup); err != nil {
+			return
+		}
+		fmt.Fprintln(state.IO.Out, "Backup written to", *zipPath)
+	}
+
+	if *sqlPath != "" {
+		if err = exportFile(*sqlPath, state.TaskStore.ExportSQL); err != nil {
+			return
+		}
+		fmt.Fprintln(state.IO.Out, "Tasks exported to", *sqlPath)
+	}
+
+	return
+}
+
+// exportFile creates the file at name and writes it with export.
+func exportFile(name string, export func(io.Writer) error) (err error) {
+	var file *os.File
+	if file, err = os.Create(name); err != nil {
+		return
+	}
+
+	if err = export(file); err != nil {
+		file.Close()
+		return
+	}
+
+	return file.Close()
+}
+
+func importCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "format of the file, csv or json (default: from the file extension)")
+	mergeStrategy := flags.String("merge-strategy", "skip", "how to handle tasks whose description already exists: skip, overwrite or rename")
+	fromZip := flags.Bool("zip", false, "restore a backup written by export --zip, replacing the tasks, journal, archive, templates and config file and adding the attachments")
+	yes := flags.Bool("yes", false, "restore a backup without confirmation")
+	clampTimes := flags.Bool("clamp-times", false, "move updated_at up to created_at when it is earlier, instead of failing")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
+		return
+	}
+
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
+		return
+	}
+
+	if *fromZip {
+		return restoreBackup(state, state.Args[0], *yes)
+	}
+
+	var strategy MergeStrategy
+	if strategy = NewMergeStrategy(*mergeStrategy); !strategy.Valid() {
+		err = ErrInvalidMergeStrategy
+		return
+	}
+
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(path.Ext(state.Args[0])), ".")
+	}
+
+	var file *os.File
+	if file, err = os.Open(state.Args[0]); err != nil {
+		return
+	}
+	defer file.Close()
+
+	var tasks []Task
+	var warnings []string
+	switch *format {
+	case "json":
+		tasks, warnings, err = readImportJSON(file, *clampTimes)
+	case "csv":
+		tasks, warnings, err = readImportCSV(file, *clampTimes)
+	default:
+		err = ErrUnknownImportFormat
+	}
+	if err != nil {
+		return
+	}
+
+	for _, warning := range warnings {
+		fmt.Fprintln(state.IO.Err, "Warning:", warning)
+	}
+
+	var result ImportResult
+	if result, err = state.TaskStore.Import(tasks, strategy); err != nil {
 		return
 	}
-	store.dbPath = path.Join(dir, "task", "task.json")
 
+	fmt.Fprintf(state.IO.Out, "Tasks imported successfully: %d created, %d overwritten, %d skipped\n",
+		result.Created, result.Overwritten, result.Skipped)
 	return
 }
 
-func (store *TaskStore) Load() (err error) {
-	if err = os.MkdirAll(path.Dir(store.dbPath), os.ModePerm); err != nil {
+func restoreBackup(state *CommandState, file string, yes bool) (err error) {
+	var contents map[string][]byte
+	if contents, err = state.TaskStore.ReadBackup(file); err != nil {
 		return
 	}
 
-	if _, err = os.Stat(store.dbPath); err != nil {
-		if os.IsNotExist(err) {
-			err = nil
+	if !yes {
+		prompt := fmt.Sprintf("Replace the %d current tasks with the backup?", len(state.TaskStore.Tasks))
+		if yes, err = state.confirm(prompt); err != nil || !yes {
+			fmt.Fprintln(state.IO.Out, "Aborted")
+			return
 		}
+	}
+
+	if err = state.TaskStore.RestoreBackup(contents); err != nil {
 		return
 	}
 
-	var data []byte
-	if data, err = os.ReadFile(store.dbPath); err != nil {
+	fmt.Fprintln(state.IO.Out, "Backup restored from", file)
+	return
+}
+
+// storeDiffView is how a StoreDiff is shown in the JSON output.
+type storeDiffView struct {
+	Added    []taskView   `json:"added"`
+	Removed  []taskView   `json:"removed"`
+	Modified []TaskChange `json:"modified"`
+}
+
+func diffCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the differences as JSON")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
 		return
 	}
 
-	if err = json.Unmarshal(data, store); err != nil {
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
 		return
 	}
 
-	return
-}
+	// a backup is either a store file or an archive from export --zip
+	var backup *TaskStore
+	if strings.ToLower(path.Ext(state.Args[0])) == ".zip" {
+		var contents map[string][]byte
+		if contents, err = state.TaskStore.ReadBackup(state.Args[0]); err != nil {
+			return
+		}
+		backup = &TaskStore{Tasks: make([]Task, 0)}
+		if err = json.Unmarshal(contents["task.json"], backup); err != nil {
+			return
+		}
+	} else {
+		if _, err = os.Stat(state.Args[0]); err != nil {
+			return
+		}
+		if backup, err = OpenTaskStore(state.Args[0]); err != nil {
+			return
+		}
+	}
 
-func (store *TaskStore) Save() (err error) {
-	var data []byte
-	if data, err = json.Marshal(store); err != nil {
+	var diff StoreDiff
+	if diff, err = DiffStores(state.TaskStore, backup); err != nil {
 		return
 	}
 
-	if err = os.WriteFile(store.dbPath, data, os.ModePerm); err != nil {
+	if *asJSON {
+		view := storeDiffView{Added: []taskView{}, Removed: []taskView{}, Modified: diff.Modified}
+		if view.Modified == nil {
+			view.Modified = []TaskChange{}
+		}
+		for _, task := range diff.Added {
+			view.Added = append(view.Added, newTaskView(task, viewOptions{}))
+		}
+		for _, task := range diff.Removed {
+			view.Removed = append(view.Removed, newTaskView(task, viewOptions{}))
+		}
+
+		encoder := json.NewEncoder(state.IO.Out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(view)
+	}
+
+	if len(diff.Added)+len(diff.Removed)+len(diff.Modified) == 0 {
+		fmt.Fprintln(state.IO.Out, "No differences")
 		return
 	}
 
+	for _, task := range diff.Added {
+		fmt.Fprintf(state.IO.Out, "+ #%d %s\n", task.Id, state.RenderOptions().description(task))
+	}
+	for _, task := range diff.Removed {
+		fmt.Fprintf(state.IO.Out, "- #%d %s\n", task.Id, state.RenderOptions().description(task))
+	}
+	for _, change := range diff.Modified {
+		task, _ := backup.GetById(change.Id)
+		fmt.Fprintf(state.IO.Out, "~ #%d %s: %s\n", change.Id, state.RenderOptions().description(task), strings.Join(change.Fields, ", "))
+	}
+
 	return
 }
 
-func (store *TaskStore) Create(task Task) (newTask Task, err error) {
-	if store.Exists(task) {
-		err = ErrTaskAlreadyExists
-		return
-	}
+// uiAction is what a key does in the ui command.
+type uiAction int
 
-	task.Id = TaskId(store.Meta.CurrentId)
-	store.Meta.CurrentId++
-	task.Status = TaskStatusTodo
-	task.CreatedAt = time.Now()
-	task.UpdatedAt = task.CreatedAt
+const (
+	uiNone uiAction = iota
+	uiUp
+	uiDown
+	uiCycleStatus
+	uiDelete
+	uiFilter
+	uiQuit
+)
 
-	store.Tasks = append(store.Tasks, task)
-	return task, store.Save()
+// uiKeyAction maps a key, as read from a terminal in raw mode, to the
+// action it triggers in the task list. Arrow keys arrive as escape
+// sequences, vi keys are accepted too.
+func uiKeyAction(key string) uiAction {
+	switch key {
+	case "\x1b[A", "\x1bOA", "k":
+		return uiUp
+	case "\x1b[B", "\x1bOB", "j":
+		return uiDown
+	case " ":
+		return uiCycleStatus
+	case "d":
+		return uiDelete
+	case "/":
+		return uiFilter
+	case "q", "\x03", "\x04":
+		return uiQuit
+	}
+	return uiNone
 }
 
-func (store *TaskStore) Update(task Task) (err error) {
-	if store.Exists(task) {
-		err = ErrTaskAlreadyExists
-		return
-	}
+// uiMode tells how the ui command reads the next key.
+type uiMode int
 
-	task.UpdatedAt = time.Now()
+const (
+	uiModeList uiMode = iota
+	uiModeFilter
+	uiModeConfirmDelete
+)
 
-	store.Tasks[store.Index(task.Id)] = task
-	return store.Save()
+// uiEffect is a change to the store asked by a key, applied by the caller
+// so the ui state stays free of IO.
+type uiEffect struct {
+	action uiAction
+	task   Task
 }
 
-func (store *TaskStore) Delete(task Task) (err error) {
-	index := store.Index(task.Id)
-	store.Tasks = slices.Delete(store.Tasks, index, index+1)
-	return store.Save()
+// uiState is the state of the ui command between keys: the tasks shown,
+// the selected one and what the keys currently do.
+type uiState struct {
+	all     []Task
+	shown   []Task
+	cursor  int
+	mode    uiMode
+	filter  string
+	message string
+	quit    bool
 }
 
-func (store *TaskStore) Exists(task Task) bool {
-	return slices.ContainsFunc(store.Tasks, func(v Task) bool {
-		return v.Id != task.Id && v.Description == task.Description
-	})
+func newUIState(tasks []Task) *uiState {
+	ui := &uiState{}
+	ui.setTasks(tasks)
+	return ui
 }
 
-func (store *TaskStore) Index(id TaskId) int {
-	return slices.IndexFunc(store.Tasks, func(task Task) bool {
-		return task.Id == id
+// setTasks replaces the tasks, after the store changed, keeping the filter
+// and the cursor within the shown tasks.
+func (ui *uiState) setTasks(tasks []Task) {
+	ui.all = tasks
+	query := strings.ToLower(ui.filter)
+	ui.shown = filterTasks(tasks, func(task Task) bool {
+		return strings.Contains(strings.ToLower(task.Description), query)
 	})
+	ui.cursor = max(0, min(ui.cursor, len(ui.shown)-1))
 }
 
-func (store *TaskStore) GetById(id TaskId) (task Task, err error) {
-	index := store.Index(id)
-	if index == -1 {
-		err = ErrTaskDoesNotExist
+func (ui *uiState) selected() (task Task, ok bool) {
+	if len(ui.shown) == 0 {
 		return
 	}
-
-	task = store.Tasks[index]
-	return
+	return ui.shown[ui.cursor], true
 }
 
-func (store *TaskStore) GetByStatus(status TaskStatus) (tasks []Task) {
-	for _, task := range store.Tasks {
-		if task.Status == status {
-			tasks = append(tasks, task)
+// handleKey moves the ui to its next state for key, returning the change
+// to make to the store if any.
+func (ui *uiState) handleKey(key string) (effect *uiEffect) {
+	ui.message = ""
+
+	switch ui.mode {
+	case uiModeFilter:
+		switch key {
+		case "\r", "\n":
+			ui.mode = uiModeList
+		case "\x1b":
+			ui.mode = uiModeList
+			ui.filter = ""
+		case "\x7f", "\b":
+			if runes := []rune(ui.filter); len(runes) > 0 {
+				ui.filter = string(runes[:len(runes)-1])
+			}
+		default:
+			if utf8.ValidString(key) && !strings.ContainsFunc(key, unicode.IsControl) {
+				ui.filter += key
+			}
 		}
-	}
+		ui.setTasks(ui.all)
+		return
 
-	return
-}
+	case uiModeConfirmDelete:
+		ui.mode = uiModeList
+		if task, ok := ui.selected(); ok && (key == "y" || key == "Y") {
+			return &uiEffect{uiDelete, task}
+		}
+		ui.message = "Not deleted"
+		return
+	}
 
-type CommandState struct {
-	TaskStore *TaskStore
-	Args      []string
-}
+	switch uiKeyAction(key) {
+	case uiUp:
+		ui.cursor = max(0, ui.cursor-1)
+	case uiDown:
+		ui.cursor = max(0, min(ui.cursor+1, len(ui.shown)-1))
+	case uiCycleStatus:
+		if task, ok := ui.selected(); ok {
+			status, ok := nextStatus(task.Status)
+			if !ok {
+				status = TaskStatusTodo
+			}
+			task.SetStatus(status, time.Now(), "")
+			return &uiEffect{uiCycleStatus, task}
+		}
+	case uiDelete:
+		if _, ok := ui.selected(); ok {
+			ui.mode = uiModeConfirmDelete
+		}
+	case uiFilter:
+		ui.mode = uiModeFilter
+	case uiQuit:
+		ui.quit = true
+	}
 
-func NewCommandState(args []string) (state *CommandState, err error) {
-	state = new(CommandState)
-	state.Args = args
-	state.TaskStore, err = NewTaskStore()
 	return
 }
 
-func helpCommand(*CommandState) (err error) {
-	fmt.Print(`USAGE: task [command] [args]
+// render draws the ui on a terminal in raw mode, which needs \r\n line
+// endings, with the selected task highlighted.
+func (ui *uiState) render(w io.Writer, options RenderOptions) error {
+	var screen strings.Builder
+	screen.WriteString("\x1b[H\x1b[2J")
+	screen.WriteString("↑/↓ move  space status  d delete  / filter  q quit\r\n\r\n")
 
-COMMANDS:
-	help       show this message
-	add        add a new task
-	update     update a task
-	delete     delete a task
-	mark       change a task status
-	list       list all tasks
+	for i, task := range ui.shown {
+		line := fmt.Sprintf("  %-4d %-12s %s", task.Id, task.Status.String(), options.description(task))
+		if i == ui.cursor {
+			line = "\x1b[7m>" + line[1:] + "\x1b[0m"
+		}
+		screen.WriteString(line + "\r\n")
+	}
+	if len(ui.shown) == 0 {
+		screen.WriteString("  No tasks found\r\n")
+	}
 
-EXAMPLES:
-	task help
+	screen.WriteString("\r\n")
+	switch ui.mode {
+	case uiModeFilter:
+		screen.WriteString("/" + ui.filter)
+	case uiModeConfirmDelete:
+		task, _ := ui.selected()
+		fmt.Fprintf(&screen, "Delete task %d? [y/N]", task.Id)
+	default:
+		if ui.filter != "" {
+			screen.WriteString("filter: " + ui.filter + "  ")
+		}
+		screen.WriteString(ui.message)
+	}
 
-	task-cli add "Buy groceries"
-	task-cli update 1 "Buy groceries and cook dinner"
-	task-cli delete 1
+	_, err := io.WriteString(w, screen.String())
+	return err
+}
 
-	task-cli mark 1 done
-	task-cli mark 1 todo
-	task-cli mark 1 in-progress
+// rawTerminal switches the terminal on stdin to raw mode with stty, so
+// keys are read as they are typed, and returns the function restoring it.
+func rawTerminal(stdin *os.File) (restore func() error, err error) {
+	stty := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = stdin
+		return cmd.Output()
+	}
 
-	task-cli list
-	task-cli list done
-	task-cli list todo
-	task-cli list in-progress
-`)
-	return
+	var saved []byte
+	if saved, err = stty("-g"); err != nil {
+		err = fmt.Errorf("%w: %w", ErrNotTerminal, err)
+		return
+	}
+	if _, err = stty("raw", "-echo"); err != nil {
+		return
+	}
+
+	return func() error {
+		_, err := stty(strings.TrimSpace(string(saved)))
+		return err
+	}, nil
 }
 
-func addCommand(state *CommandState) (err error) {
-	if len(state.Args) != 1 {
-		err = ErrOnlyOneArgumentAllowed
+func uiCommand(state *CommandState) (err error) {
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
 		return
 	}
 
-	var task Task
-	task.Description = state.Args[0]
+	stdin, ok := state.IO.In.(*os.File)
+	if !ok || !isTerminal(stdin) || !isTerminal(state.IO.Out) {
+		err = ErrNotTerminal
+		return
+	}
 
-	if task, err = state.TaskStore.Create(task); err != nil {
+	var restore func() error
+	if restore, err = rawTerminal(stdin); err != nil {
+		return
+	}
+	defer func() {
+		// leave the cursor below the ui and the terminal as it was
+		fmt.Fprint(state.IO.Out, "\r\n")
+		if restoreErr := restore(); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	var tasks []Task
+	if tasks, err = sortTasks(state.TaskStore.Tasks, state.Config.Sort); err != nil {
 		return
 	}
+	ui := newUIState(tasks)
+	options := state.RenderOptions()
+
+	key := make([]byte, 16)
+	for !ui.quit {
+		if err = ui.render(state.IO.Out, options); err != nil {
+			return
+		}
+
+		var n int
+		if n, err = stdin.Read(key); err != nil {
+			return
+		}
+
+		effect := ui.handleKey(string(key[:n]))
+		if effect == nil {
+			continue
+		}
+
+		// store errors, such as a frozen store, are shown instead of ending
+		// the session
+		var effectErr error
+		switch effect.action {
+		case uiCycleStatus:
+			if effectErr = state.TaskStore.Update(effect.task); effectErr == nil {
+				ui.message = fmt.Sprintf("Task %d marked %s", effect.task.Id, effect.task.Status.String())
+			}
+		case uiDelete:
+			if effectErr = state.TaskStore.Delete(effect.task); effectErr == nil {
+				ui.message = fmt.Sprintf("Task %d deleted", effect.task.Id)
+			}
+		}
+		if effectErr != nil {
+			ui.message = "Error: " + effectErr.Error()
+		}
 
-	fmt.Printf("Task added successfully: (ID: %d)\n", task.Id)
+		if tasks, err = sortTasks(state.TaskStore.Tasks, state.Config.Sort); err != nil {
+			return
+		}
+		ui.setTasks(tasks)
+	}
 
 	return
 }
 
-func updateCommand(state *CommandState) (err error) {
-	if len(state.Args) != 2 {
-		err = ErrOnlyTwoArgumentsAllowed
+var seedDescriptions = []string{
+	"Write the quarterly report",
+	"Review the open pull requests",
+	"Fix the leaking kitchen tap",
+	"Plan the team offsite",
+	"Call the dentist",
+	"Buy groceries",
+	"Clean the garage",
+	"Update the README",
+	"Prepare the demo slides",
+	"Read the design doc",
+	"Renew the passport",
+	"File the tax forms",
+	"Water the plants",
+	"Back up the laptop",
+	"Book flights for the conference",
+}
+
+// seedDays is how many days back the timestamps of seeded tasks go.
+const seedDays = 14
+
+// seedTasks returns count made up tasks with random descriptions and
+// statuses, created and updated over the seedDays before now.
+func seedTasks(count int, now time.Time, r *rand.Rand) []Task {
+	tasks := make([]Task, 0, count)
+	for range count {
+		createdAt := now.Add(-time.Duration(r.Int64N(int64(seedDays * 24 * time.Hour))))
+		updatedAt := createdAt.Add(time.Duration(r.Int64N(int64(now.Sub(createdAt)) + 1)))
+		tasks = append(tasks, Task{
+			Description: seedDescriptions[r.IntN(len(seedDescriptions))],
+			Status:      TaskStatus(r.IntN(int(TaskStatusDone)) + 1),
+			Priority:    TaskPriorityLow + TaskPriority(r.IntN(len(taskPriorityMapToString))),
+			CreatedAt:   createdAt,
+			UpdatedAt:   updatedAt,
+		})
+	}
+	return tasks
+}
+
+// seedCommand fills the store with made up tasks for demos and trying
+// things out. It is left out of the help on purpose.
+func seedCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	count := flags.Int("count", 20, "number of tasks to add")
+	force := flags.Bool("force", false, "seed a store that already has tasks")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
 		return
 	}
 
-	var id uint64
-	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
 		return
 	}
 
-	var task Task
-	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+	if *count < 0 {
+		err = ErrInvalidLimit
 		return
 	}
 
-	task.Description = state.Args[1]
+	if len(state.TaskStore.Tasks) > 0 && !*force {
+		err = ErrSeedNonEmpty
+		return
+	}
 
-	if err = state.TaskStore.Update(task); err != nil {
+	r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	tasks := seedTasks(*count, time.Now(), r)
+
+	var result ImportResult
+	if result, err = state.TaskStore.Import(tasks, MergeStrategyRename); err != nil {
 		return
 	}
 
-	fmt.Println("Task updated successfully")
+	fmt.Fprintf(state.IO.Out, "%d tasks seeded\n", result.Created)
 	return
 }
 
-func deleteCommand(state *CommandState) (err error) {
-	if len(state.Args) != 1 {
-		err = ErrOnlyOneArgumentAllowed
+func schemaCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("schema", flag.ContinueOnError)
+	camel := flags.Bool("camel", false, "describe the output of list --json --camel")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
 		return
 	}
 
-	var id uint64
-	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
 		return
 	}
 
-	var task Task
-	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+	encoder := json.NewEncoder(state.IO.Out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(taskListSchema(*camel))
+}
+
+func freezeCommand(state *CommandState) (err error) {
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
 		return
 	}
 
-	if err = state.TaskStore.Delete(task); err != nil {
+	if err = state.TaskStore.SetFrozen(true); err != nil {
 		return
 	}
 
-	fmt.Println("Task deleted successfully")
+	fmt.Fprintln(state.IO.Out, "Store frozen")
 	return
 }
 
-func markCommand(state *CommandState) (err error) {
-	if len(state.Args) != 2 {
-		err = ErrOnlyTwoArgumentsAllowed
+func unfreezeCommand(state *CommandState) (err error) {
+	if len(state.Args) != 0 {
+		err = ErrNoArgumentsAllowed
 		return
 	}
 
-	var id uint64
-	if id, err = strconv.ParseUint(state.Args[0], 10, 64); err != nil {
+	if err = state.TaskStore.SetFrozen(false); err != nil {
 		return
 	}
 
-	var status TaskStatus
-	if status = NewTaskStatus(state.Args[1]); !status.Valid() {
-		err = ErrInvalidTaskStatus
+	fmt.Fprintln(state.IO.Out, "Store unfrozen")
+	return
+}
+
+func replayCommand(state *CommandState) (err error) {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	force := flags.Bool("force", false, "replace the tasks of a non-empty store")
+
+	if state.Args, err = parseFlags(flags, state.Args); err != nil {
 		return
 	}
 
-	var task Task
-	if task, err = state.TaskStore.GetById(TaskId(id)); err != nil {
+	if len(state.Args) != 1 {
+		err = ErrOnlyOneArgumentAllowed
 		return
 	}
 
-	task.Status = status
+	if len(state.TaskStore.Tasks) > 0 && !*force {
+		err = ErrStoreNotEmpty
+		return
+	}
 
-	if err = state.TaskStore.Update(task); err != nil {
+	var file *os.File
+	if file, err = os.Open(state.Args[0]); err != nil {
 		return
 	}
+	defer file.Close()
 
-	fmt.Println("Task status updated to", task.Status.String())
-	return
-}
+	if err = state.TaskStore.Replay(file); err != nil {
+		return
+	}
 
-func listCommand(state *CommandState) (err error) {
-	if len(state.Args) > 1 {
-		err = ErrOnlyOneArgumentAllowed
+	// the replayed journal already holds every mutation, write the store
+	// without journaling it again
+	if err = state.TaskStore.write(); err != nil {
 		return
 	}
 
-	var tasks []Task
+	fmt.Fprintf(state.IO.Out, "Store rebuilt successfully: %d tasks\n", len(state.TaskStore.Tasks))
+	return
+}
 
-	if len(state.Args) == 0 {
-		tasks = state.TaskStore.Tasks
-	} else {
-		var status TaskStatus
-		if status = NewTaskStatus(state.Args[0]); !status.Valid() {
-			err = ErrInvalidTaskStatus
-			return
-		}
+// commandNames are the sorted names of the commands. It is filled by init,
+// as commands using it cannot refer to commandsMap without an
+// initialization cycle.
+var commandNames []string
 
-		tasks = state.TaskStore.GetByStatus(status)
-	}
+func init() {
+	commandNames = slices.Sorted(maps.Keys(commandsMap))
+}
 
-	maxStatusLen := max(len(TaskStatusTodo.String()), len(TaskStatusInProgress.String()), len(TaskStatusDone.String()))
-	dateLen := len(time.Now().Format(time.DateTime))
+var commandsMap = map[string]func(*CommandState) error{
+	"help":          helpCommand,
+	"add":           addCommand,
+	"update":        updateCommand,
+	"delete":        deleteCommand,
+	"mark":          markCommand,
+	"archive":       archiveCommand,
+	"restore":       restoreCommand,
+	"mark-all":      markAllCommand,
+	"touch":         touchCommand,
+	"color":         colorCommand,
+	"pin":           pinCommand,
+	"priority":      priorityCommand,
+	"bump":          bumpCommand,
+	"lower":         lowerCommand,
+	"unpin":         unpinCommand,
+	"due":           dueCommand,
+	"project":       projectCommand,
+	"depends":       dependsCommand,
+	"note":          noteCommand,
+	"remind":        remindCommand,
+	"template":      templateCommand,
+	"check":         checkCommand,
+	"attach":        attachCommand,
+	"open":          openCommand,
+	"set":           setCommand,
+	"assign":        assignCommand,
+	"estimate":      estimateCommand,
+	"report":        reportCommand,
+	"start":         startCommand,
+	"stop":          stopCommand,
+	"time":          timeCommand,
+	"notify-daemon": notifyDaemonCommand,
+	"tag":           tagCommand,
+	"untag":         untagCommand,
+	"rename-tag":    renameTagCommand,
+	"tags":          tagsCommand,
+	"move-to":       moveToCommand,
+	"edit":          editCommand,
+	"prune-index":   pruneIndexCommand,
+	"doctor":        doctorCommand,
+	"context":       contextCommand,
+	"diff":          diffCommand,
+	"history":       historyCommand,
+	"seed":          seedCommand,
+	"ui":            uiCommand,
+	"idle":          idleCommand,
+	"list":          listCommand,
+	"config":        configCommand,
+	"count":         countCommand,
+	"search":        searchCommand,
+	"stats":         statsCommand,
+	"show":          showCommand,
+	"export":        exportCommand,
+	"import":        importCommand,
+	"schema":        schemaCommand,
+	"replay":        replayCommand,
+	"freeze":        freezeCommand,
+	"unfreeze":      unfreezeCommand,
+}
 
-	{
-		header := strings.Builder{}
-		header.WriteString("id")
-		header.WriteString("    ")
-		header.WriteString("status")
-		header.WriteString("    " + strings.Repeat(" ", maxStatusLen-len("status")))
-		header.WriteString("created at")
-		header.WriteString("    " + strings.Repeat(" ", dateLen-len("created at")))
-		header.WriteString("updated at")
-		header.WriteString("    " + strings.Repeat(" ", dateLen-len("updated at")))
-		header.WriteString("description")
-		fmt.Println(header.String())
+// exitInternalError is the exit code used when a command panics, matching
+// EX_SOFTWARE from sysexits.h.
+const exitInternalError = 70
+
+// exitCode returns the code the process exits with after a command
+// returned err.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrInternal):
+		return exitInternalError
+	default:
+		return 1
 	}
+}
 
-	currentId := strconv.FormatUint(state.TaskStore.Meta.CurrentId, 10)
+// runCommand runs commandFn, turning a panic into ErrInternal so users get
+// a clean message instead of a stack trace. The stack is still written to
+// the error stream when verbose is set.
+func runCommand(commandFn func(*CommandState) error, state *CommandState, verbose bool) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if verbose {
+				fmt.Fprintf(state.IO.Err, "panic: %v\n\n%s\n", r, debug.Stack())
+			}
+			err = ErrInternal
+		}
+	}()
 
-	for _, task := range tasks {
-		id := strconv.FormatUint(uint64(task.Id), 10)
-		status := task.Status.String()
+	return commandFn(state)
+}
 
-		body := strings.Builder{}
-		body.WriteString(id)
-		idLen := len(currentId) - len(id)
-		if idLen == 0 {
-			idLen = 1
+// maxSuggestionDistance is the largest edit distance between a mistyped
+// command and a known one for the latter to be suggested.
+const maxSuggestionDistance = 2
+
+func suggestCommand(input string, known []string) (suggestion string, ok bool) {
+	best := maxSuggestionDistance + 1
+	for _, command := range known {
+		if distance := levenshtein(input, command); distance < best {
+			best = distance
+			suggestion = command
 		}
-		body.WriteString("    " + strings.Repeat(" ", idLen))
-		body.WriteString(status)
-		body.WriteString("    " + strings.Repeat(" ", maxStatusLen-len(status)))
-		body.WriteString(task.CreatedAt.Format(time.DateTime))
-		body.WriteString("    ")
-		body.WriteString(task.UpdatedAt.Format(time.DateTime))
-		body.WriteString("    ")
-		body.WriteString(task.Description)
-		fmt.Println(body.String())
 	}
 
-	return
+	return suggestion, best <= maxSuggestionDistance
 }
 
-var commandsMap = map[string]func(*CommandState) error{
-	"help":   helpCommand,
-	"add":    addCommand,
-	"update": updateCommand,
-	"delete": deleteCommand,
-	"mark":   markCommand,
-	"list":   listCommand,
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
 }
 
 func main() {
-	if len(os.Args) <= 1 {
-		commandsMap["help"](nil)
+	options, args, err := parseGlobalOptions(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for key, value := range options.Config {
+		if err = config.Set(key, value); err != nil {
+			log.Fatalf("--%s: %s", strings.ReplaceAll(key, "_", "-"), err)
+		}
+	}
+
+	if _, ok := localeTimeLayout(config.Locale); config.Locale != "" && !ok {
+		fmt.Fprintf(os.Stderr, "Warning: unknown locale %q, showing timestamps as %s\n", config.Locale, config.TimeFormat)
 	}
 
-	command := os.Args[1]
+	if len(args) == 0 {
+		args = []string{"help"}
+	}
+
+	command := args[0]
 	if commandFn, ok := commandsMap[command]; !ok {
+		if suggestion, ok := suggestCommand(command, commandNames); ok {
+			log.Fatalf("invalid command: %s, did you mean '%s'?", command, suggestion)
+		}
 		log.Fatal("invalid command: ", command)
-	} else if state, err := NewCommandState(os.Args[2:]); err != nil {
+	} else if state, err := NewCommandState(args[1:], IO{In: os.Stdin, Out: os.Stdout, Err: os.Stderr}, config); err != nil {
+		log.Fatal(err)
+	} else if err = state.OpenOutput(options.Output); err != nil {
 		log.Fatal(err)
 	} else if err = state.TaskStore.Load(); err != nil {
 		log.Fatal(err)
-	} else if err = commandFn(state); err != nil {
+	} else if err = runCommand(commandFn, state, options.Verbose); err != nil {
+		log.Print(err)
+		os.Exit(exitCode(err))
+	} else if err = state.Close(); err != nil {
 		log.Fatal(err)
 	}
 }
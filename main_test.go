@@ -0,0 +1,4909 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStore opens a store in a temporary directory, holding tasks.
+func newTestStore(t *testing.T, tasks ...Task) *TaskStore {
+	t.Helper()
+
+	store, err := OpenTaskStore(filepath.Join(t.TempDir(), "task.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) > 0 {
+		if _, err = store.CreateMany(tasks); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// whereTestTasks are the tasks filter expressions are matched against.
+func whereTestTasks() []Task {
+	past := time.Date(2000, 1, 1, 12, 0, 0, 0, time.Local)
+	future := time.Date(2100, 1, 1, 12, 0, 0, 0, time.Local)
+
+	return []Task{
+		{Id: 1, Description: "a", Status: TaskStatusTodo, Priority: TaskPriorityLow, Tags: []string{"work"}, Project: "home", DueAt: &past},
+		{Id: 2, Description: "b", Status: TaskStatusInProgress, Priority: TaskPriorityHigh, Tags: []string{"work", "urgent"}, DueAt: &future, Pinned: true},
+		{Id: 3, Description: "c", Status: TaskStatusDone, CompletedAt: &past},
+	}
+}
+
+// taskIds returns the ids of tasks in order.
+func taskIds(tasks []Task) (ids []TaskId) {
+	for _, task := range tasks {
+		ids = append(ids, task.Id)
+	}
+	return
+}
+
+// runTestCommand runs commandFn on store with args and the default
+// settings, returning what it wrote.
+func runTestCommand(t *testing.T, commandFn func(*CommandState) error, store *TaskStore, args ...string) (string, error) {
+	t.Helper()
+	return runTestCommandInput(t, commandFn, store, "", args...)
+}
+
+// runTestCommandInput is runTestCommand reading the answers to prompts
+// from input.
+func runTestCommandInput(t *testing.T, commandFn func(*CommandState) error, store *TaskStore, input string, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	state := &CommandState{
+		TaskStore: store,
+		Args:      args,
+		IO:        IO{In: strings.NewReader(input), Out: &out, Err: &out},
+		Config:    defaultConfig(),
+	}
+	err := commandFn(state)
+	return out.String(), err
+}
+
+// decodeTaskViews writes tasks as JSON with options and decodes them back
+// into generic objects.
+func decodeTaskViews(t *testing.T, tasks []Task, options viewOptions) (views []map[string]any) {
+	t.Helper()
+
+	var out bytes.Buffer
+	if err := writeTasksJSON(&out, tasks, options); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(out.Bytes(), &views); err != nil {
+		t.Fatalf("%v in:\n%s", err, out.String())
+	}
+	return
+}
+
+// renderTestTasks are the tasks every renderer is given in the tests.
+func renderTestTasks() []Task {
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	return []Task{
+		{Id: 1, Description: "buy milk", Status: TaskStatusTodo, CreatedAt: created, UpdatedAt: created},
+		{Id: 2, Description: `ship, "it"`, Status: TaskStatusDone, CreatedAt: created, UpdatedAt: created.Add(time.Hour)},
+	}
+}
+
+// renderTasks renders the tasks of renderTestTasks with the renderer
+// named name.
+func renderTasks(t *testing.T, name string, options RenderOptions) string {
+	t.Helper()
+
+	renderer, err := NewRenderer(name, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err = renderer.Render(&out, renderTestTasks()); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// chdir changes the working directory to dir for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// clearConfigEnv unsets the TASK_ variables of the settings for the rest
+// of the test, so the environment of the user does not leak in.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+
+	for _, key := range configKeys {
+		name := "TASK_" + strings.ToUpper(key)
+		// Setenv restores the variable once the test ends
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+// jsonEqual reports whether the JSON documents are the same once decoded.
+func jsonEqual(t *testing.T, data json.RawMessage, want string) bool {
+	t.Helper()
+
+	var a, b any
+	if err := json.Unmarshal(data, &a); err != nil {
+		return false
+	}
+	if err := json.Unmarshal([]byte(want), &b); err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// listedIds runs list --json with args and returns the ids of the tasks it
+// lists, in order.
+func listedIds(t *testing.T, store *TaskStore, args ...string) (ids []TaskId) {
+	t.Helper()
+
+	out, err := runTestCommand(t, listCommand, store, append([]string{"--json"}, args...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var views []struct {
+		Id TaskId `json:"id"`
+	}
+	if err = json.Unmarshal([]byte(out), &views); err != nil {
+		t.Fatalf("%v in:\n%s", err, out)
+	}
+	for _, view := range views {
+		ids = append(ids, view.Id)
+	}
+	return
+}
+
+func TestStepStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		step   func(TaskStatus) (TaskStatus, bool)
+		status TaskStatus
+		want   TaskStatus
+		moved  bool
+	}{
+		{"next todo", nextStatus, TaskStatusTodo, TaskStatusInProgress, true},
+		{"next in-progress", nextStatus, TaskStatusInProgress, TaskStatusDone, true},
+		{"next done", nextStatus, TaskStatusDone, TaskStatusDone, false},
+		{"prev done", prevStatus, TaskStatusDone, TaskStatusInProgress, true},
+		{"prev in-progress", prevStatus, TaskStatusInProgress, TaskStatusTodo, true},
+		{"prev todo", prevStatus, TaskStatusTodo, TaskStatusTodo, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, moved := tt.step(tt.status)
+			if got != tt.want || moved != tt.moved {
+				t.Errorf("got (%v, %v), want (%v, %v)", got, moved, tt.want, tt.moved)
+			}
+		})
+	}
+}
+
+func TestExportICS(t *testing.T) {
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	due := time.Date(2024, 3, 8, 17, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		task Task
+		want []string
+	}{
+		{
+			name: "todo",
+			task: Task{Id: 1, Description: "buy milk", Status: TaskStatusTodo},
+			want: []string{"UID:1@task", "SUMMARY:buy milk", "STATUS:NEEDS-ACTION", "DTSTAMP:20240301T093000Z"},
+		},
+		{
+			name: "in progress with due date",
+			task: Task{Id: 2, Description: "write report", Status: TaskStatusInProgress, DueAt: &due},
+			want: []string{"STATUS:IN-PROCESS", "DUE:20240308T170000Z"},
+		},
+		{
+			name: "done",
+			task: Task{Id: 3, Description: "ship", Status: TaskStatusDone},
+			want: []string{"STATUS:COMPLETED"},
+		},
+		{
+			name: "escaped text",
+			task: Task{Id: 4, Description: "a, b; c\\d\nnext", Status: TaskStatusTodo},
+			want: []string{`SUMMARY:a\, b\; c\\d\nnext`},
+		},
+		{
+			name: "folded line",
+			task: Task{Id: 5, Description: strings.Repeat("é", 40), Status: TaskStatusTodo},
+			want: []string{"SUMMARY:" + strings.Repeat("é", 33) + "\r\n " + strings.Repeat("é", 7)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.CreatedAt = created
+			tt.task.UpdatedAt = created
+			store := &TaskStore{Tasks: []Task{tt.task}}
+
+			var out bytes.Buffer
+			if err := store.ExportICS(&out); err != nil {
+				t.Fatal(err)
+			}
+
+			ics := out.String()
+			if !strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(ics, "END:VCALENDAR\r\n") {
+				t.Errorf("not a calendar:\n%s", ics)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(ics, want+"\r\n") {
+					t.Errorf("missing %q in:\n%s", want, ics)
+				}
+			}
+			if tt.task.DueAt == nil && strings.Contains(ics, "DUE:") {
+				t.Errorf("unexpected DUE in:\n%s", ics)
+			}
+		})
+	}
+}
+
+func TestNewTaskStorePath(t *testing.T) {
+	tests := []struct {
+		appName string
+		want    string
+	}{
+		{"", "task/task.json"},
+		{"fork", "fork/task.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.appName, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("TASK_APP_NAME", tt.appName)
+			dir, err := os.UserConfigDir()
+			if err != nil {
+				t.Skip(err)
+			}
+
+			store, err := NewTaskStore()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := path.Join(dir, tt.want); store.dbPath != want {
+				t.Errorf("got %s, want %s", store.dbPath, want)
+			}
+		})
+	}
+}
+
+func TestListSummary(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "— 1 todo, 1 in-progress, 2 done"},
+		{[]string{"done"}, "— 0 todo, 0 in-progress, 2 done"},
+		{[]string{"--tag", "work"}, "— 1 todo, 0 in-progress, 1 done"},
+		{[]string{"--where", "status!=done"}, "— 1 todo, 1 in-progress, 0 done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			store := newTestStore(t,
+				Task{Description: "a", Tags: []string{"work"}},
+				Task{Description: "b", Status: TaskStatusInProgress},
+				Task{Description: "c", Status: TaskStatusDone, Tags: []string{"work"}},
+				Task{Description: "d", Status: TaskStatusDone},
+			)
+
+			out, err := runTestCommand(t, listCommand, store, append([]string{"--summary"}, tt.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(out, "\n"+tt.want+"\n") {
+				t.Errorf("got:\n%s\nwant the footer %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		input    string
+		deleted  bool
+		archived int
+		nextId   uint64
+		wantErr  error
+	}{
+		{"confirmed", []string{"--all"}, "y\nyes\n", true, 1, 4, nil},
+		{"confirmed by flags", []string{"--all", "--yes", "--force"}, "", true, 1, 4, nil},
+		{"aborted first", []string{"--all"}, "n\n", false, 1, 4, nil},
+		{"aborted second", []string{"--all"}, "y\nn\n", false, 1, 4, nil},
+		{"aborted without answers", []string{"--all"}, "", false, 1, 4, nil},
+		{"second question still asked", []string{"--all", "--yes"}, "", false, 1, 4, nil},
+		{"reset ids past the archive", []string{"--all", "--reset-ids", "--yes", "--force"}, "", true, 1, 3, nil},
+		{"reset ids with the archive", []string{"--all", "--reset-ids", "--include-archived", "--yes", "--force"}, "", true, 0, 1, nil},
+		{"reset ids without all", []string{"--reset-ids"}, "", false, 1, 4, ErrResetIdsWithoutAll},
+		{"archive without all", []string{"--include-archived"}, "", false, 1, 4, ErrIncludeArchivedWithoutAll},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a"}, Task{Description: "b"}, Task{Description: "c"})
+			if _, err := store.Archive(2); err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := runTestCommandInput(t, deleteCommand, store, tt.input, tt.args...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+
+			if deleted := len(store.Tasks) == 0; deleted != tt.deleted {
+				t.Errorf("deleted = %v, want %v:\n%s", deleted, tt.deleted, out)
+			}
+			if tt.wantErr == nil && !tt.deleted && !strings.Contains(out, "Aborted") {
+				t.Errorf("got:\n%s\nwant Aborted", out)
+			}
+			if store.Meta.CurrentId != tt.nextId {
+				t.Errorf("next id is %d, want %d", store.Meta.CurrentId, tt.nextId)
+			}
+
+			archive, err := store.OpenArchive()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(archive.Tasks) != tt.archived {
+				t.Errorf("%d archived tasks left, want %d", len(archive.Tasks), tt.archived)
+			}
+		})
+	}
+}
+
+func TestListOutput(t *testing.T) {
+	for _, args := range [][]string{
+		{"list", "--output", "f.txt"},
+		{"--output=f.txt", "list"},
+	} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			options, rest, err := parseGlobalOptions(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(rest, []string{"list"}) {
+				t.Fatalf("got arguments %v, want [list]", rest)
+			}
+
+			file := filepath.Join(t.TempDir(), options.Output)
+			// the file is truncated, not appended to
+			if err = os.WriteFile(file, []byte("stale\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			var stdout, stderr bytes.Buffer
+			state := &CommandState{
+				TaskStore: newTestStore(t, Task{Description: "buy milk"}),
+				IO:        IO{In: strings.NewReader(""), Out: &stdout, Err: &stderr},
+				Config:    defaultConfig(),
+			}
+			if err = state.OpenOutput(file); err != nil {
+				t.Fatal(err)
+			}
+			if err = listCommand(state); err != nil {
+				t.Fatal(err)
+			}
+			if err = state.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out := string(data); !strings.HasPrefix(out, "id ") || !strings.Contains(out, "buy milk") || strings.Contains(out, "stale") {
+				t.Errorf("got the file:\n%s\nwant the task table", out)
+			}
+			if stdout.Len() != 0 || stderr.Len() != 0 {
+				t.Errorf("got %q on stdout and %q on stderr, want nothing", stdout.String(), stderr.String())
+			}
+		})
+	}
+}
+
+func TestCommandOutput(t *testing.T) {
+	store := newTestStore(t)
+
+	// the steps run in order against the same store
+	steps := []struct {
+		commandFn func(*CommandState) error
+		args      []string
+		want      string
+		wantErr   error
+	}{
+		{addCommand, []string{"buy milk"}, "Task added successfully: (ID: 1)\n", nil},
+		{addCommand, []string{"walk the dog"}, "Task added successfully: (ID: 2)\n", nil},
+		{addCommand, []string{"buy milk"}, "", ErrTaskAlreadyExists},
+		{markCommand, []string{"1", "in-progress"}, "Task status updated to in-progress\n", nil},
+		{markCommand, []string{"1", "++"}, "Task status updated to done\n", nil},
+		{markCommand, []string{"1", "++"}, "Task is already done\n", nil},
+		{markCommand, []string{"1", "done"}, "No changes\n", nil},
+		{markCommand, []string{"2", "--"}, "Task is already todo\n", nil},
+		{markCommand, []string{"2", "later"}, "", ErrInvalidTaskStatus},
+		{markCommand, []string{"3", "done"}, "", ErrTaskDoesNotExist},
+		{listCommand, []string{"done"}, "buy milk", nil},
+		{listCommand, []string{"--json", "todo"}, `"description": "walk the dog"`, nil},
+	}
+
+	for i, step := range steps {
+		out, err := runTestCommand(t, step.commandFn, store, step.args...)
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("step %d %v: got %v, want %v", i, step.args, err, step.wantErr)
+		}
+		if !strings.Contains(out, step.want) {
+			t.Errorf("step %d %v: got %q, want %q", i, step.args, out, step.want)
+		}
+	}
+}
+
+func TestSuggestCommand(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		ok    bool
+	}{
+		{"lsit", "list", true},
+		{"ad", "add", true},
+		{"delet", "delete", true},
+		{"marc", "mark", true},
+		{"list", "list", true},
+		{"xyzzy", "", false},
+		{"", "", false},
+	}
+
+	known := []string{"add", "delete", "list", "mark", "search"}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := suggestCommand(tt.input, known)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("suggestCommand(%q) = (%q, %v), want (%q, %v)", tt.input, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestJSONTimeEncoding(t *testing.T) {
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	updated := created.Add(90 * time.Minute)
+
+	tests := []struct {
+		name             string
+		options          viewOptions
+		created, updated any
+	}{
+		{"rfc3339", viewOptions{}, "2024-03-01T09:30:00Z", "2024-03-01T11:00:00Z"},
+		{"epoch", viewOptions{TimeEpoch: true}, float64(1709285400), float64(1709290800)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := Task{Id: 1, Description: "a", Status: TaskStatusTodo, CreatedAt: created, UpdatedAt: updated}
+			view := decodeTaskViews(t, []Task{task}, tt.options)[0]
+
+			if view["created_at"] != tt.created || view["updated_at"] != tt.updated {
+				t.Errorf("got created_at %#v and updated_at %#v, want %#v and %#v",
+					view["created_at"], view["updated_at"], tt.created, tt.updated)
+			}
+		})
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name      string
+		commandFn func(*CommandState) error
+		verbose   bool
+		want      error
+		stack     bool
+		code      int
+	}{
+		{"success", func(*CommandState) error { return nil }, false, nil, false, 0},
+		{"error", func(*CommandState) error { return errFailed }, false, errFailed, false, 1},
+		{"panic", func(*CommandState) error { panic("boom") }, false, ErrInternal, false, 70},
+		{"panic verbose", func(*CommandState) error { panic("boom") }, true, ErrInternal, true, 70},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			state := &CommandState{IO: IO{Err: &stderr}}
+
+			err := runCommand(tt.commandFn, state, tt.verbose)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+			if stack := strings.Contains(stderr.String(), "panic: boom"); stack != tt.stack {
+				t.Errorf("stack written = %v, want %v:\n%s", stack, tt.stack, stderr.String())
+			}
+			// 70 is EX_SOFTWARE from sysexits.h
+			if code := exitCode(err); code != tt.code {
+				t.Errorf("got exit code %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestJSONCamelKeys(t *testing.T) {
+	due := time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)
+	task := Task{Id: 2, Description: "b", Status: TaskStatusTodo, ParentId: 1, DueAt: &due, DependsOn: []TaskId{1}}
+
+	tests := []struct {
+		name    string
+		options viewOptions
+		want    []string
+	}{
+		{"snake case", viewOptions{}, []string{"created_at", "updated_at", "parent_id", "due_at", "depends_on"}},
+		{"camel case", viewOptions{Camel: true}, []string{"createdAt", "updatedAt", "parentId", "dueAt", "dependsOn"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			view := decodeTaskViews(t, []Task{task}, tt.options)[0]
+			for _, key := range tt.want {
+				if _, ok := view[key]; !ok {
+					t.Errorf("missing key %q", key)
+				}
+			}
+			if tt.options.Camel {
+				for key := range view {
+					if strings.Contains(key, "_") {
+						t.Errorf("got the snake case key %q", key)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDescribeText(t *testing.T) {
+	tests := []struct {
+		name string
+		str  string
+		want TextStats
+	}{
+		{"empty", "", TextStats{}},
+		{"one word", "milk", TextStats{Runes: 4, Words: 1, Lines: 1}},
+		{"multi-byte runes", "café crème", TextStats{Runes: 10, Words: 2, Lines: 1}},
+		{"extra spaces", "  buy   milk  ", TextStats{Runes: 14, Words: 2, Lines: 1}},
+		{"two lines", "buy\nmilk", TextStats{Runes: 8, Words: 2, Lines: 2}},
+		{"trailing newline", "buy milk\n", TextStats{Runes: 9, Words: 2, Lines: 1}},
+		{"blank line", "buy\n\nmilk", TextStats{Runes: 9, Words: 2, Lines: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describeText(tt.str); got != tt.want {
+				t.Errorf("describeText(%q) = %+v, want %+v", tt.str, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkStatusHistory(t *testing.T) {
+	tests := []struct {
+		name  string
+		marks [][]string
+		want  []TaskStatus
+	}{
+		{"no marks", nil, []TaskStatus{TaskStatusTodo}},
+		{"forward", [][]string{{"in-progress"}, {"done"}}, []TaskStatus{TaskStatusTodo, TaskStatusInProgress, TaskStatusDone}},
+		{"steps", [][]string{{"++"}, {"++"}, {"--"}}, []TaskStatus{TaskStatusTodo, TaskStatusInProgress, TaskStatusDone, TaskStatusInProgress}},
+		{"same status twice", [][]string{{"done"}, {"done"}}, []TaskStatus{TaskStatusTodo, TaskStatusDone}},
+		{"reopened", [][]string{{"done"}, {"todo"}}, []TaskStatus{TaskStatusTodo, TaskStatusDone, TaskStatusTodo}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a"})
+			for _, mark := range tt.marks {
+				if _, err := runTestCommand(t, markCommand, store, "1", mark[0]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reopened, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			history := reopened.Tasks[0].StatusHistory
+
+			var got []TaskStatus
+			for i, change := range history {
+				got = append(got, change.Status)
+				if i > 0 && change.At.Before(history[i-1].At) {
+					t.Errorf("change %d at %v is before the previous one", i, change.At)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSynthesizesStatusHistory(t *testing.T) {
+	tests := []struct {
+		name string
+		task string
+		want StatusChange
+	}{
+		{
+			"todo since its creation",
+			`{"id": 1, "description": "a", "status": 1, "created_at": "2024-03-01T09:00:00Z", "updated_at": "2024-03-02T09:00:00Z"}`,
+			StatusChange{Status: TaskStatusTodo, At: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+		},
+		{
+			"done at its last update",
+			`{"id": 1, "description": "a", "status": 3, "created_at": "2024-03-01T09:00:00Z", "updated_at": "2024-03-02T09:00:00Z"}`,
+			StatusChange{Status: TaskStatusDone, At: time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "task.json")
+			data := `{"meta": {"current_id": 2}, "tasks": [` + tt.task + `]}`
+			if err := os.WriteFile(dbPath, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := OpenTaskStore(dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			history := store.Tasks[0].StatusHistory
+			if len(history) != 1 || history[0].Status != tt.want.Status || !history[0].At.Equal(tt.want.At) {
+				t.Errorf("got %+v, want [%+v]", history, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWhere(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []TaskId
+	}{
+		{"status=todo", []TaskId{1}},
+		{"status!=done", []TaskId{1, 2}},
+		{"priority>=high", []TaskId{2}},
+		{"priority<medium", []TaskId{1}},
+		{"todo or done", []TaskId{1, 3}},
+		{"tag=work and not pinned", []TaskId{1}},
+		{"status=done or (tag=work and priority=high)", []TaskId{2, 3}},
+		{"not (status=todo or status=done)", []TaskId{2}},
+		{"status=done and priority=low", nil},
+		{"overdue", []TaskId{1}},
+		{"due<2050-01-01", []TaskId{1}},
+		{"due=2000-01-01", []TaskId{1}},
+		{"due=none", []TaskId{3}},
+		{"completed<today", []TaskId{3}},
+		{"project='home'", []TaskId{1}},
+		{`tag="urgent"`, []TaskId{2}},
+	}
+
+	store := &TaskStore{Tasks: whereTestTasks()}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			predicate, err := parseWhere(tt.expr, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []TaskId
+			for _, task := range store.Tasks {
+				if predicate(task) {
+					got = append(got, task.Id)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWhereErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+	}{
+		{"status=", 7},
+		{"statu=todo", 0},
+		{"status=later", 7},
+		{"(todo", 5},
+		{"todo )", 5},
+		{"todo !", 5},
+		{"tag<work", 3},
+		{"due>none", 3},
+		{"frobbed", 0},
+		{"project='home", 8},
+	}
+
+	store := &TaskStore{}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseWhere(tt.expr, store)
+
+			var whereErr *WhereError
+			if !errors.As(err, &whereErr) {
+				t.Fatalf("got %v, want a WhereError", err)
+			}
+			if whereErr.Pos != tt.pos {
+				t.Errorf("got position %d, want %d: %v", whereErr.Pos, tt.pos, err)
+			}
+		})
+	}
+}
+
+func TestTruncateDescription(t *testing.T) {
+	huge := strings.Repeat("x", 100_000)
+
+	tests := []struct {
+		name  string
+		desc  string
+		width int
+		want  string
+	}{
+		{"short", "buy milk", 80, "buy milk"},
+		{"exact width", "buy milk", 8, "buy milk"},
+		{"cut", "buy milk", 3, "buy…(+5 chars)"},
+		{"multi-byte runes", "ééééé", 2, "éé…(+3 chars)"},
+		{"empty", "", 80, ""},
+		{"huge", huge, 80, strings.Repeat("x", 80) + "…(+99920 chars)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateDescription(tt.desc, tt.width); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// only the cut description is allocated, never a copy of the whole
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	truncateDescription(huge, 80)
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1024 {
+		t.Errorf("truncating a 100k description allocated %d bytes", allocated)
+	}
+}
+
+func TestImportMergeStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy MergeStrategy
+		result   ImportResult
+		want     []string
+		status   TaskStatus
+		project  string
+	}{
+		{"skip", MergeStrategySkip, ImportResult{Created: 1, Skipped: 1}, []string{"buy milk", "buy milk (2)", "walk the dog"}, TaskStatusTodo, ""},
+		{"overwrite", MergeStrategyOverwrite, ImportResult{Created: 1, Overwritten: 1}, []string{"buy milk", "buy milk (2)", "walk the dog"}, TaskStatusDone, "home"},
+		{"rename", MergeStrategyRename, ImportResult{Created: 2}, []string{"buy milk", "buy milk (2)", "buy milk (3)", "walk the dog"}, TaskStatusTodo, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "buy milk"}, Task{Description: "buy milk (2)"})
+
+			result, err := store.Import([]Task{
+				{Description: "buy milk", Status: TaskStatusDone, Project: "home"},
+				{Description: "walk the dog"},
+			}, tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.result {
+				t.Errorf("got %+v, want %+v", result, tt.result)
+			}
+
+			var got []string
+			for _, task := range store.Tasks {
+				got = append(got, task.Description)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			existing := store.Tasks[0]
+			if existing.Id != 1 {
+				t.Errorf("the existing task has the id %d, want 1", existing.Id)
+			}
+			if existing.Status != tt.status {
+				t.Errorf("the existing task is %v, want %v", existing.Status, tt.status)
+			}
+			if existing.Project != tt.project {
+				t.Errorf("the existing task is in the project %q, want %q", existing.Project, tt.project)
+			}
+			recorded := slices.ContainsFunc(existing.History, func(change FieldChange) bool {
+				return change.Field == "project"
+			})
+			if recorded != (tt.project != "") {
+				t.Errorf("got the history %+v", existing.History)
+			}
+		})
+	}
+}
+
+func TestFrozenStore(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		wantErr error
+	}{
+		{"add", []string{"c"}, ErrStoreFrozen},
+		{"update", []string{"1", "A"}, ErrStoreFrozen},
+		{"delete", []string{"1"}, ErrStoreFrozen},
+		{"mark", []string{"1", "done"}, ErrStoreFrozen},
+		{"mark-all", []string{"done", "--yes"}, ErrStoreFrozen},
+		{"priority", []string{"1", "high"}, ErrStoreFrozen},
+		{"tag", []string{"1", "work"}, ErrStoreFrozen},
+		{"archive", []string{"1"}, ErrStoreFrozen},
+		{"list", nil, nil},
+		{"show", []string{"1"}, nil},
+		{"search", []string{"a"}, nil},
+		{"count", nil, nil},
+		{"tags", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a"}, Task{Description: "b"})
+			if _, err := runTestCommand(t, freezeCommand, store); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := runTestCommand(t, commandsMap[tt.command], store, tt.args...); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+
+			// nothing reached the file either
+			reopened, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reopened.Tasks; len(got) != 2 || got[0].Description != "a" || got[0].Status != TaskStatusTodo || len(got[0].Tags) != 0 {
+				t.Errorf("the store changed: %+v", got)
+			}
+		})
+	}
+
+	t.Run("unfreeze", func(t *testing.T) {
+		store := newTestStore(t, Task{Description: "a"})
+		for _, commandFn := range []func(*CommandState) error{freezeCommand, unfreezeCommand} {
+			if _, err := runTestCommand(t, commandFn, store); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := runTestCommand(t, addCommand, store, "b"); err != nil {
+			t.Errorf("adding to an unfrozen store: %v", err)
+		}
+	})
+}
+
+func TestRenderers(t *testing.T) {
+	tests := []struct {
+		renderer string
+		want     string
+	}{
+		{"table", `id    status         created at             updated at             description
+1     todo           2024-03-01 09:30:00    2024-03-01 09:30:00    buy milk
+2     done           2024-03-01 09:30:00    2024-03-01 10:30:00    ship, "it"
+`},
+		{"box", `┌────┬────────┬─────────────────────┬─────────────────────┬─────────────┐
+│ id │ status │ created at          │ updated at          │ description │
+├────┼────────┼─────────────────────┼─────────────────────┼─────────────┤
+│ 1  │ todo   │ 2024-03-01 09:30:00 │ 2024-03-01 09:30:00 │ buy milk    │
+│ 2  │ done   │ 2024-03-01 09:30:00 │ 2024-03-01 10:30:00 │ ship, "it"  │
+└────┴────────┴─────────────────────┴─────────────────────┴─────────────┘
+`},
+		{"ascii", `+----+--------+---------------------+---------------------+-------------+
+| id | status | created at          | updated at          | description |
++----+--------+---------------------+---------------------+-------------+
+| 1  | todo   | 2024-03-01 09:30:00 | 2024-03-01 09:30:00 | buy milk    |
+| 2  | done   | 2024-03-01 09:30:00 | 2024-03-01 10:30:00 | ship, "it"  |
++----+--------+---------------------+---------------------+-------------+
+`},
+		{"plain", "1\ttodo\tbuy milk\n2\tdone\tship, \"it\"\n"},
+		{"csv", `id,description,status,created_at,updated_at
+1,buy milk,todo,2024-03-01T09:30:00Z,2024-03-01T09:30:00Z
+2,"ship, ""it""",done,2024-03-01T09:30:00Z,2024-03-01T10:30:00Z
+`},
+		{"oneline", "#1 [todo] buy milk\n#2 [done] ship, \"it\"\n"},
+		{"porcelain", "1\ttodo\t2024-03-01T09:30:00Z\t2024-03-01T09:30:00Z\tbuy milk\n" +
+			"2\tdone\t2024-03-01T09:30:00Z\t2024-03-01T10:30:00Z\tship, \"it\"\n"},
+		{"tree", "#1 [todo] buy milk\n#2 [done] ship, \"it\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.renderer, func(t *testing.T) {
+			if got := renderTasks(t, tt.renderer, RenderOptions{TimeFormat: time.DateTime}); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONRenderers(t *testing.T) {
+	tests := []struct {
+		renderer string
+		// records splits the output into one JSON text per task
+		records func(out string) []string
+	}{
+		{"json", func(out string) (records []string) {
+			var views []json.RawMessage
+			if json.Unmarshal([]byte(out), &views) != nil {
+				return nil
+			}
+			for _, view := range views {
+				records = append(records, string(view))
+			}
+			return
+		}},
+		{"ndjson", func(out string) []string {
+			return strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		}},
+		{"json-seq", func(out string) []string {
+			return strings.Split(out, "\x1e")[1:]
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.renderer, func(t *testing.T) {
+			out := renderTasks(t, tt.renderer, RenderOptions{})
+
+			records := tt.records(out)
+			if len(records) != 2 {
+				t.Fatalf("got %d records, want 2:\n%s", len(records), out)
+			}
+			for i, record := range records {
+				var view struct {
+					Id          TaskId `json:"id"`
+					Description string `json:"description"`
+				}
+				if err := json.Unmarshal([]byte(record), &view); err != nil {
+					t.Fatalf("record %d: %v", i, err)
+				}
+				if want := renderTestTasks()[i]; view.Id != want.Id || view.Description != want.Description {
+					t.Errorf("record %d is task %d %q, want %d %q", i, view.Id, view.Description, want.Id, want.Description)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name               string
+		userFile, workFile string
+		env                map[string]string
+		args               []string
+		want               map[string]string
+	}{
+		{
+			name: "defaults",
+			want: map[string]string{"sort": "id", "color": "auto", "desc_width": "80", "default_status": "todo"},
+		},
+		{
+			name:     "user file",
+			userFile: "sort = created\n# a comment\n\ncolor=never\n",
+			want:     map[string]string{"sort": "created", "color": "never", "desc_width": "80"},
+		},
+		{
+			name:     "working directory file over user file",
+			userFile: "sort = created\ncolor = never\n",
+			workFile: "sort = updated\n",
+			want:     map[string]string{"sort": "updated", "color": "never"},
+		},
+		{
+			name:     "env over files",
+			userFile: "sort = created\ndesc_width = 40\n",
+			workFile: "sort = updated\n",
+			env:      map[string]string{"TASK_SORT": "status", "TASK_DEFAULT_STATUS": "done"},
+			want:     map[string]string{"sort": "status", "desc_width": "40", "default_status": "done"},
+		},
+		{
+			name:     "flags over env",
+			workFile: "color = never\n",
+			env:      map[string]string{"TASK_SORT": "status", "TASK_COLOR": "always"},
+			args:     []string{"list", "--sort", "description", "--desc-width=20"},
+			want:     map[string]string{"sort": "description", "color": "always", "desc_width": "20"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configHome, workDir := t.TempDir(), t.TempDir()
+			t.Setenv("XDG_CONFIG_HOME", configHome)
+			t.Setenv("TASK_APP_NAME", "")
+			clearConfigEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			chdir(t, workDir)
+
+			userFile, err := userConfigPath()
+			if err != nil {
+				t.Skip(err)
+			}
+			for file, data := range map[string]string{userFile: tt.userFile, configFileName: tt.workFile} {
+				if data == "" {
+					continue
+				}
+				if err = os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err = os.WriteFile(file, []byte(data), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config, err := loadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			options, _, err := parseGlobalOptions(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range options.Config {
+				if err = config.Set(key, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for key, want := range tt.want {
+				if got, _ := config.Get(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		workFile string
+		env      map[string]string
+		wantErr  error
+	}{
+		{"line without =", "sort\n", nil, ErrInvalidConfigLine},
+		{"invalid value in file", "sort = size\n", nil, ErrInvalidSortKey},
+		{"unknown key in file", "colour = never\n", nil, ErrUnknownConfigKey},
+		{"invalid value in env", "", map[string]string{"TASK_COLOR": "sometimes"}, ErrInvalidColorSetting},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			clearConfigEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			workDir := t.TempDir()
+			chdir(t, workDir)
+			if err := os.WriteFile(configFileName, []byte(tt.workFile), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := loadConfig(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	journal := `{"at": "2024-03-01T09:00:00Z", "op": "create", "task": {"id": 1, "description": "a", "status": 1, "created_at": "2024-03-01T09:00:00Z", "updated_at": "2024-03-01T09:00:00Z"}}
+{"at": "2024-03-01T09:01:00Z", "op": "create", "task": {"id": 2, "description": "b", "status": 1, "created_at": "2024-03-01T09:01:00Z", "updated_at": "2024-03-01T09:01:00Z"}}
+
+{"at": "2024-03-01T09:02:00Z", "op": "update", "task": {"id": 1, "description": "a", "status": 3, "created_at": "2024-03-01T09:00:00Z", "updated_at": "2024-03-01T09:02:00Z"}}
+{"at": "2024-03-01T09:03:00Z", "op": "create", "task": {"id": 3, "description": "c", "status": 1, "created_at": "2024-03-01T09:03:00Z", "updated_at": "2024-03-01T09:03:00Z"}}
+{"at": "2024-03-01T09:04:00Z", "op": "delete", "id": 2}
+`
+
+	tests := []struct {
+		name    string
+		journal string
+		want    []TaskId
+		nextId  uint64
+		wantErr error
+		line    string
+	}{
+		{"known sequence", journal, []TaskId{1, 3}, 4, nil, ""},
+		{"meta", journal + `{"at": "2024-03-01T09:05:00Z", "op": "meta", "meta": {"current_id": 10}}` + "\n", []TaskId{1, 3}, 10, nil, ""},
+		{"empty", "", nil, 1, nil, ""},
+		{"corrupt line", journal + "{\"op\": \n", nil, 0, nil, "journal line 7"},
+		{"unknown operation", journal + `{"op": "rename", "id": 1}` + "\n", nil, 0, ErrInvalidJournalEntry, "journal line 7"},
+		{"update of a missing task", journal + `{"op": "update", "task": {"id": 2, "description": "b"}}` + "\n", nil, 0, ErrTaskDoesNotExist, "journal line 7"},
+		{"delete of a missing task", `{"op": "delete", "id": 1}` + "\n", nil, 0, ErrTaskDoesNotExist, "journal line 1"},
+		{"create without a task", `{"op": "create"}` + "\n", nil, 0, ErrInvalidJournalEntry, "journal line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &TaskStore{Meta: TaskStoreMeta{CurrentId: 1}, Tasks: []Task{{Id: 7, Description: "before"}}}
+
+			err := store.Replay(strings.NewReader(tt.journal))
+			if tt.line != "" {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) || !strings.HasPrefix(err.Error(), tt.line+":") {
+					t.Fatalf("got %v, want an error at %s", err, tt.line)
+				}
+				// a failed replay leaves the store alone
+				if got := taskIds(store.Tasks); !slices.Equal(got, []TaskId{7}) {
+					t.Errorf("got %v, want the store untouched", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := taskIds(store.Tasks); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if store.Meta.CurrentId != tt.nextId {
+				t.Errorf("next id is %d, want %d", store.Meta.CurrentId, tt.nextId)
+			}
+		})
+	}
+}
+
+func TestReplayJournal(t *testing.T) {
+	store := newTestStore(t, Task{Description: "a"}, Task{Description: "b"}, Task{Description: "c"})
+	for _, args := range [][]string{{"1", "done"}, {"3", "in-progress"}} {
+		if _, err := runTestCommand(t, markCommand, store, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := runTestCommand(t, deleteCommand, store, "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the journal of the store rebuilds it as saved
+	replayed := newTestStore(t)
+	if _, err := runTestCommand(t, replayCommand, replayed, store.journalPath()); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := os.ReadFile(store.dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := os.ReadFile(replayed.dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, rebuilt) {
+		t.Errorf("got:\n%s\nwant:\n%s", rebuilt, saved)
+	}
+}
+
+func TestColorCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr error
+	}{
+		{"set", []string{"1", "red"}, "red", nil},
+		{"change", []string{"1", "blue"}, "blue", nil},
+		{"clear", []string{"1", "none"}, "", nil},
+		{"unknown color", []string{"1", "mauve"}, "green", ErrInvalidTaskColor},
+		{"unknown task", []string{"2", "red"}, "green", ErrTaskDoesNotExist},
+		{"missing color", []string{"1"}, "green", ErrOnlyTwoArgumentsAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a", Color: "green"})
+
+			if _, err := runTestCommand(t, colorCommand, store, tt.args...); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+
+			reopened, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reopened.Tasks[0].Color; got != tt.want {
+				t.Errorf("got color %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColorDot(t *testing.T) {
+	tasks := []Task{{Id: 1, Description: "a", Color: "red"}, {Id: 2, Description: "b"}}
+
+	tests := []struct {
+		name  string
+		color bool
+		dots  int
+	}{
+		// only the labeled task gets a dot
+		{"colors enabled", true, 1},
+		{"colors disabled", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			renderer := tableRenderer{RenderOptions{Color: tt.color, TimeFormat: time.DateTime}}
+			if err := renderer.Render(&out, tasks); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.Count(out.String(), colorDot("red")); got != tt.dots {
+				t.Errorf("got %d red dots, want %d:\n%q", got, tt.dots, out.String())
+			}
+			if got := strings.Count(out.String(), "●"); got != tt.dots {
+				t.Errorf("got %d dots, want %d:\n%q", got, tt.dots, out.String())
+			}
+		})
+	}
+}
+
+func TestListPageEnvelope(t *testing.T) {
+	tests := []struct {
+		args  []string
+		page  *PageInfo
+		tasks []TaskId
+	}{
+		{[]string{"--json"}, nil, []TaskId{1, 2, 3, 4, 5}},
+		{[]string{"--json", "--limit", "2"}, &PageInfo{Total: 5, Offset: 0, Limit: 2}, []TaskId{1, 2}},
+		{[]string{"--json", "--offset", "3"}, &PageInfo{Total: 5, Offset: 3, Limit: 0}, []TaskId{4, 5}},
+		{[]string{"--json", "--offset", "1", "--limit", "2"}, &PageInfo{Total: 5, Offset: 1, Limit: 2}, []TaskId{2, 3}},
+		{[]string{"--json", "--offset", "9", "--limit", "2"}, &PageInfo{Total: 5, Offset: 9, Limit: 2}, nil},
+		{[]string{"--json", "--limit", "2", "todo"}, &PageInfo{Total: 3, Offset: 0, Limit: 2}, []TaskId{1, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			store := newTestStore(t,
+				Task{Description: "a"},
+				Task{Description: "b", Status: TaskStatusDone},
+				Task{Description: "c"},
+				Task{Description: "d", Status: TaskStatusDone},
+				Task{Description: "e"},
+			)
+
+			out, err := runTestCommand(t, listCommand, store, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var views []struct {
+				Id TaskId `json:"id"`
+			}
+			if tt.page == nil {
+				// without pagination flags the array stays bare
+				if err = json.Unmarshal([]byte(out), &views); err != nil {
+					t.Fatalf("%v in:\n%s", err, out)
+				}
+			} else {
+				var envelope struct {
+					Tasks json.RawMessage `json:"tasks"`
+					PageInfo
+				}
+				if err = json.Unmarshal([]byte(out), &envelope); err != nil {
+					t.Fatalf("%v in:\n%s", err, out)
+				}
+				if envelope.PageInfo != *tt.page {
+					t.Errorf("got %+v, want %+v", envelope.PageInfo, *tt.page)
+				}
+				if err = json.Unmarshal(envelope.Tasks, &views); err != nil {
+					t.Fatalf("%v in:\n%s", err, out)
+				}
+			}
+
+			var got []TaskId
+			for _, view := range views {
+				got = append(got, view.Id)
+			}
+			if !slices.Equal(got, tt.tasks) {
+				t.Errorf("got tasks %v, want %v", got, tt.tasks)
+			}
+		})
+	}
+}
+
+func TestIdleSince(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+
+	tests := []struct {
+		name    string
+		updated time.Time
+		status  TaskStatus
+		idle    bool
+	}{
+		{"just updated", now, TaskStatusTodo, false},
+		{"a second short", now.Add(-week + time.Second), TaskStatusTodo, false},
+		{"exactly d", now.Add(-week), TaskStatusTodo, true},
+		{"a second past", now.Add(-week - time.Second), TaskStatusInProgress, true},
+		{"done long ago", now.Add(-10 * week), TaskStatusDone, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &TaskStore{Tasks: []Task{{Id: 1, Status: tt.status, UpdatedAt: tt.updated}}}
+			if idle := len(store.IdleSince(now, week)) == 1; idle != tt.idle {
+				t.Errorf("idle = %v, want %v", idle, tt.idle)
+			}
+		})
+	}
+}
+
+func TestIdleSinceOrder(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	store := &TaskStore{Tasks: []Task{
+		{Id: 1, UpdatedAt: now.AddDate(0, 0, -10)},
+		{Id: 2, UpdatedAt: now.AddDate(0, 0, -30)},
+		{Id: 3, UpdatedAt: now.AddDate(0, 0, -20)},
+	}}
+
+	var got []TaskId
+	for _, task := range store.IdleSince(now, 24*time.Hour) {
+		got = append(got, task.Id)
+	}
+	if want := []TaskId{2, 3, 1}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want the longest idle first %v", got, want)
+	}
+}
+
+func TestMarkNote(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		note string
+	}{
+		{"with a note", []string{"--note", "waiting on review", "1", "in-progress"}, "waiting on review"},
+		{"note after the arguments", []string{"1", "done", "--note", "shipped"}, "shipped"},
+		{"without a note", []string{"1", "done"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a"})
+			if _, err := runTestCommand(t, markCommand, store, tt.args...); err != nil {
+				t.Fatal(err)
+			}
+
+			reopened, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			history := reopened.Tasks[0].StatusHistory
+			last := history[len(history)-1]
+			if last.Status != reopened.Tasks[0].Status || last.Note != tt.note {
+				t.Errorf("got the transition %+v, want the note %q with the status %v", last, tt.note, reopened.Tasks[0].Status)
+			}
+			if history[0].Note != "" {
+				t.Errorf("the note went to the creation too: %+v", history[0])
+			}
+
+			out, err := runTestCommand(t, showCommand, store, "--history", "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.note != "" && !strings.Contains(out, last.Status.String()+" - "+tt.note+"\n") {
+				t.Errorf("missing the note in:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestListRowNumbers(t *testing.T) {
+	tests := []struct {
+		args []string
+		ids  []string
+	}{
+		{nil, []string{"1", "2", "3", "4"}},
+		{[]string{"done"}, []string{"2", "4"}},
+		{[]string{"--offset", "1", "--limit", "2"}, []string{"2", "3"}},
+		{[]string{"--table", "done"}, []string{"2", "4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			store := newTestStore(t,
+				Task{Description: "a"},
+				Task{Description: "b", Status: TaskStatusDone},
+				Task{Description: "c"},
+				Task{Description: "d", Status: TaskStatusDone},
+			)
+
+			out, err := runTestCommand(t, listCommand, store, append([]string{"--row-numbers"}, tt.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the rows are the lines starting with their number, the
+			// columns are separated by spaces or box borders
+			var rows, ids []string
+			for _, line := range strings.Split(out, "\n") {
+				fields := strings.Fields(strings.ReplaceAll(line, "│", " "))
+				if len(fields) < 2 || fields[0] == "#" || strings.Trim(fields[0], "0123456789") != "" {
+					continue
+				}
+				rows = append(rows, fields[0])
+				ids = append(ids, fields[1])
+			}
+
+			var want []string
+			for i := range tt.ids {
+				want = append(want, fmt.Sprint(i+1))
+			}
+			if !slices.Equal(rows, want) || !slices.Equal(ids, tt.ids) {
+				t.Errorf("got rows %v for ids %v, want %v for %v:\n%s", rows, ids, want, tt.ids, out)
+			}
+		})
+	}
+}
+
+func TestArchiveDoneBefore(t *testing.T) {
+	cutoff := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	before := cutoff.Add(-time.Hour)
+	after := cutoff.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		cutoff   time.Time
+		dryRun   bool
+		archived []TaskId
+		kept     []TaskId
+	}{
+		{"archives done before the cutoff", cutoff, false, []TaskId{2, 4}, []TaskId{1, 3}},
+		{"dry run moves nothing", cutoff, true, []TaskId{2, 4}, []TaskId{1, 2, 3, 4}},
+		{"nothing old enough", before, false, nil, []TaskId{1, 2, 3, 4}},
+		{"every done task", after.Add(time.Hour), false, []TaskId{2, 3, 4}, []TaskId{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			store.Tasks = []Task{
+				{Id: 1, Description: "todo", Status: TaskStatusTodo, UpdatedAt: before},
+				{Id: 2, Description: "done before", Status: TaskStatusDone, CompletedAt: &before},
+				{Id: 3, Description: "done after", Status: TaskStatusDone, CompletedAt: &after},
+				// without a completion date, the last status change counts
+				{Id: 4, Description: "done long ago", Status: TaskStatusDone, UpdatedAt: after,
+					StatusHistory: []StatusChange{{Status: TaskStatusDone, At: before}}},
+			}
+
+			archived, err := store.ArchiveDoneBefore(tt.cutoff, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := taskIds(archived); !slices.Equal(got, tt.archived) {
+				t.Errorf("archived %v, want %v", got, tt.archived)
+			}
+			if got := taskIds(store.Tasks); !slices.Equal(got, tt.kept) {
+				t.Errorf("kept %v, want %v", got, tt.kept)
+			}
+
+			archive, err := store.OpenArchive()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.archived
+			if tt.dryRun {
+				want = nil
+			}
+			if got := taskIds(archive.Tasks); !slices.Equal(got, want) {
+				t.Errorf("archive holds %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestArchiveDoneBeforeTakenId(t *testing.T) {
+	done := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := newTestStore(t)
+	store.Tasks = []Task{{Id: 1, Description: "done", Status: TaskStatusDone, CompletedAt: &done}}
+	if _, err := store.ArchiveDoneBefore(time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// a task given the same id again must not overwrite the archived one
+	store.Tasks = []Task{{Id: 1, Description: "again", Status: TaskStatusDone, CompletedAt: &done}}
+	if _, err := store.ArchiveDoneBefore(time.Now(), false); !errors.Is(err, ErrArchivedIdTaken) {
+		t.Errorf("got %v, want %v", err, ErrArchivedIdTaken)
+	}
+	if len(store.Tasks) != 1 {
+		t.Errorf("the task was removed from the store")
+	}
+}
+
+func TestSearchJSON(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []TaskId
+	}{
+		{[]string{"--ndjson", "milk"}, []TaskId{1, 3}},
+		{[]string{"--ndjson", "MILK"}, []TaskId{1, 3}},
+		{[]string{"--ndjson", "bread"}, nil},
+		{[]string{"--json", "milk"}, []TaskId{1, 3}},
+		{[]string{"--json", "bread"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			store := newTestStore(t,
+				Task{Description: "buy milk"},
+				Task{Description: "walk the dog"},
+				Task{Description: "milk the cow", Status: TaskStatusDone},
+			)
+
+			out, err := runTestCommand(t, searchCommand, store, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var records []string
+			if tt.args[0] == "--ndjson" {
+				if out != "" {
+					records = strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+				}
+			} else {
+				var views []json.RawMessage
+				if err = json.Unmarshal([]byte(out), &views); err != nil {
+					t.Fatalf("%v in:\n%s", err, out)
+				}
+				for _, view := range views {
+					records = append(records, string(view))
+				}
+			}
+
+			var got []TaskId
+			for _, record := range records {
+				var view struct {
+					Id TaskId `json:"id"`
+				}
+				if err = json.Unmarshal([]byte(record), &view); err != nil {
+					t.Fatalf("%v in the line %q", err, record)
+				}
+				got = append(got, view.Id)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v:\n%s", got, tt.want, out)
+			}
+		})
+	}
+}
+
+func TestCompletionsByDay(t *testing.T) {
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	now := time.Date(2024, 6, 15, 10, 0, 0, 0, zone)
+
+	tests := []struct {
+		name      string
+		completed []time.Time
+		want      []int
+	}{
+		{"none", nil, []int{0, 0, 0}},
+		{"today", []time.Time{now, now.Add(-time.Hour)}, []int{0, 0, 2}},
+		{"each day", []time.Time{
+			time.Date(2024, 6, 13, 0, 0, 0, 0, zone),
+			time.Date(2024, 6, 14, 23, 59, 59, 0, zone),
+			time.Date(2024, 6, 15, 0, 0, 0, 0, zone),
+		}, []int{1, 1, 1}},
+		{"too old", []time.Time{time.Date(2024, 6, 12, 23, 59, 59, 0, zone)}, []int{0, 0, 0}},
+		// 23:00 UTC on the 13th is already the 14th in the zone of now
+		{"other zone", []time.Time{time.Date(2024, 6, 13, 23, 0, 0, 0, time.UTC)}, []int{0, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &TaskStore{Tasks: []Task{{Id: 1, Status: TaskStatusTodo, UpdatedAt: now}}}
+			for i, completed := range tt.completed {
+				store.Tasks = append(store.Tasks, Task{Id: TaskId(i + 2), Status: TaskStatusDone, CompletedAt: &completed})
+			}
+
+			counts := store.CompletionsByDay(now, len(tt.want))
+			var got []int
+			for i, count := range counts {
+				if day := now.AddDate(0, 0, i-len(tt.want)+1); count.Day.Day() != day.Day() {
+					t.Errorf("day %d is %v, want the %d", i, count.Day, day.Day())
+				}
+				got = append(got, count.Count)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("TASK_APP_NAME", "")
+	clearConfigEnv(t)
+	chdir(t, t.TempDir())
+
+	// each step runs with the settings loaded again, like a new process
+	steps := []struct {
+		args    []string
+		want    string
+		wantErr error
+	}{
+		{[]string{"get", "color"}, "auto\n", nil},
+		{[]string{"set", "color", "never"}, "color set to never in ", nil},
+		{[]string{"get", "color"}, "never\n", nil},
+		{[]string{"set", "sort", "-created"}, "sort set to -created", nil},
+		{[]string{"set", "color", "always"}, "color set to always", nil},
+		{[]string{"get", "color"}, "always\n", nil},
+		{[]string{"get", "sort"}, "-created\n", nil},
+		{[]string{"list"}, "sort = -created\ncolor = always\n", nil},
+		{[]string{"set", "colour", "never"}, "", ErrUnknownConfigKey},
+		{[]string{"get", "colour"}, "", ErrUnknownConfigKey},
+		{[]string{"set", "color", "sometimes"}, "", ErrInvalidColorSetting},
+		{[]string{"set", "sort", "size"}, "", ErrInvalidSortKey},
+		{[]string{"set", "desc_width", "-1"}, "", ErrInvalidDescWidth},
+		{[]string{"set", "default_status", "later"}, "", ErrInvalidTaskStatus},
+		{[]string{"get", "color"}, "always\n", nil},
+		{[]string{"unset", "color"}, "", ErrUnknownConfigAction},
+	}
+
+	for i, step := range steps {
+		config, err := loadConfig()
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+
+		var out bytes.Buffer
+		state := &CommandState{Args: step.args, IO: IO{Out: &out, Err: &out}, Config: config}
+		if err = configCommand(state); !errors.Is(err, step.wantErr) {
+			t.Fatalf("step %d %v: got %v, want %v", i, step.args, err, step.wantErr)
+		}
+		if !strings.Contains(out.String(), step.want) {
+			t.Errorf("step %d %v: got %q, want %q", i, step.args, out.String(), step.want)
+		}
+	}
+}
+
+func TestTreeRenderer(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []Task
+		want  string
+	}{
+		{
+			"subtasks",
+			[]Task{
+				{Id: 1, Description: "launch", Status: TaskStatusInProgress},
+				{Id: 2, Description: "write docs", Status: TaskStatusDone, ParentId: 1},
+				{Id: 3, Description: "ship", Status: TaskStatusTodo, ParentId: 1},
+				{Id: 4, Description: "buy milk", Status: TaskStatusTodo},
+			},
+			"#1 [in-progress] launch (1/2 done, 50%)\n" +
+				"    [x] #2 write docs\n" +
+				"    [ ] #3 ship\n" +
+				"#4 [todo] buy milk\n",
+		},
+		{
+			"nested",
+			[]Task{
+				{Id: 1, Description: "launch", Status: TaskStatusTodo},
+				{Id: 2, Description: "docs", Status: TaskStatusTodo, ParentId: 1},
+				{Id: 3, Description: "api docs", Status: TaskStatusDone, ParentId: 2},
+			},
+			"#1 [todo] launch (1/2 done, 50%)\n" +
+				"    [ ] #2 docs (1/1 done, 100%)\n" +
+				"        [x] #3 api docs\n",
+		},
+		{
+			"parent not listed",
+			[]Task{{Id: 2, Description: "write docs", Status: TaskStatusDone, ParentId: 1}},
+			"#2 [done] write docs\n",
+		},
+		{
+			"parent cycle",
+			[]Task{
+				{Id: 1, Description: "a", Status: TaskStatusTodo, ParentId: 2},
+				{Id: 2, Description: "b", Status: TaskStatusTodo, ParentId: 1},
+				{Id: 3, Description: "c", Status: TaskStatusTodo},
+			},
+			"#3 [todo] c\n" +
+				"#1 [todo] a (0/1 done, 0%)\n" +
+				"    [ ] #2 b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := (treeRenderer{RenderOptions{}}).Render(&out, tt.tasks); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDueCommand(t *testing.T) {
+	past := time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local)
+	future := time.Date(2100, 1, 1, 0, 0, 0, 0, time.Local)
+	yesterday := time.Now().AddDate(0, 0, -1)
+	yesterday = time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		args    []string
+		fails   bool
+		want    *time.Time
+		overdue bool
+	}{
+		{"set", []string{"1", "2100-01-01"}, false, &future, false},
+		{"set to yesterday", []string{"1", yesterday.Format(time.DateOnly)}, false, &yesterday, true},
+		{"clear", []string{"--clear", "1"}, false, nil, false},
+		// a failed command leaves the due date as it was
+		{"invalid date", []string{"1", "someday"}, true, &past, true},
+		{"clear with a date", []string{"--clear", "1", "2100-01-01"}, true, &past, true},
+		{"missing date", []string{"1"}, true, &past, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a", DueAt: &past})
+
+			if _, err := runTestCommand(t, dueCommand, store, tt.args...); (err != nil) != tt.fails {
+				t.Fatalf("got %v, want an error: %v", err, tt.fails)
+			}
+
+			reopened, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			task := reopened.Tasks[0]
+			if (task.DueAt == nil) != (tt.want == nil) || (task.DueAt != nil && !task.DueAt.Equal(*tt.want)) {
+				t.Errorf("got due date %v, want %v", task.DueAt, tt.want)
+			}
+
+			out, err := runTestCommand(t, listCommand, reopened, "--json", "overdue")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if listed := strings.Contains(out, `"id": 1`); listed != tt.overdue {
+				t.Errorf("listed as overdue = %v, want %v:\n%s", listed, tt.overdue, out)
+			}
+		})
+	}
+}
+
+func TestCompactJSONOmitsClearedDue(t *testing.T) {
+	due := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, task := range []Task{{Id: 1, Description: "a", DueAt: &due}, {Id: 1, Description: "a"}} {
+		view := decodeTaskViews(t, []Task{task}, viewOptions{Compact: true})[0]
+		if _, ok := view["due_at"]; ok != (task.DueAt != nil) {
+			t.Errorf("due_at written = %v for the due date %v", ok, task.DueAt)
+		}
+	}
+}
+
+func TestFormatPrettyDate(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{time.Date(2024, 6, 3, 15, 4, 5, 0, time.UTC), "Mon 2024-06-03 15:04"},
+		{time.Date(2024, 6, 9, 0, 0, 0, 0, time.UTC), "Sun 2024-06-09 00:00"},
+		{time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC), "Thu 2024-02-29 23:59"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatPrettyDate(tt.t); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// the table shows them with --pretty-dates
+	out := renderTasks(t, "table", RenderOptions{TimeFormat: time.DateTime, PrettyDates: true})
+	if !strings.Contains(out, "Fri 2024-03-01 09:30") || strings.Contains(out, "2024-03-01 09:30:00") {
+		t.Errorf("got:\n%s\nwant pretty dates", out)
+	}
+}
+
+func TestCreateMany(t *testing.T) {
+	tests := []struct {
+		name    string
+		tasks   []Task
+		want    []TaskId
+		wantErr error
+	}{
+		{"one", []Task{{Description: "c"}}, []TaskId{2}, nil},
+		{"several", []Task{{Description: "c"}, {Description: "d"}, {Description: "e"}}, []TaskId{2, 3, 4}, nil},
+		{"none", nil, nil, nil},
+		{"existing description", []Task{{Description: "c"}, {Description: "a"}}, nil, ErrTaskAlreadyExists},
+		{"repeated description", []Task{{Description: "c"}, {Description: "c"}}, nil, ErrTaskAlreadyExists},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			if _, err := store.Create(Task{Description: "a"}); err != nil {
+				t.Fatal(err)
+			}
+
+			created, err := store.CreateMany(tt.tasks)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if got := taskIds(created); !slices.Equal(got, tt.want) {
+				t.Errorf("created %v, want %v", got, tt.want)
+			}
+
+			// a failed batch creates none of its tasks
+			reopened, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(reopened.Tasks), 1+len(tt.want); got != want {
+				t.Errorf("saved %d tasks, want %d", got, want)
+			}
+			if got, want := reopened.Meta.CurrentId, uint64(2+len(tt.want)); got != want {
+				t.Errorf("next id is %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestCreateManyDefaults(t *testing.T) {
+	store := newTestStore(t)
+	created, err := store.CreateMany([]Task{
+		{Id: 42, Description: "todo"},
+		{Description: "done", Status: TaskStatusDone},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	todo, done := created[0], created[1]
+	if todo.Id != 1 {
+		t.Errorf("got id %d, want ids assigned by the store", todo.Id)
+	}
+	if todo.Status != TaskStatusTodo || todo.CompletedAt != nil {
+		t.Errorf("got status %v completed at %v, want todo not completed", todo.Status, todo.CompletedAt)
+	}
+	if done.CompletedAt == nil || !done.CompletedAt.Equal(done.CreatedAt) {
+		t.Errorf("a task created done was completed at %v, want its creation", done.CompletedAt)
+	}
+	if len(done.StatusHistory) != 1 || done.StatusHistory[0].Status != TaskStatusDone {
+		t.Errorf("got status history %v", done.StatusHistory)
+	}
+}
+
+func TestFilterSinceDays(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	tasks := []Task{
+		{Id: 1, UpdatedAt: now.Add(-time.Hour)},
+		{Id: 2, UpdatedAt: now.AddDate(0, 0, -3)},
+		{Id: 3, UpdatedAt: now.AddDate(0, 0, -3).Add(-time.Second)},
+		{Id: 4, UpdatedAt: now.AddDate(0, 0, -30)},
+	}
+
+	tests := []struct {
+		days int
+		want []TaskId
+		err  error
+	}{
+		{1, []TaskId{1}, nil},
+		{3, []TaskId{1, 2}, nil},
+		{4, []TaskId{1, 2, 3}, nil},
+		{30, []TaskId{1, 2, 3, 4}, nil},
+		{0, nil, ErrInvalidDays},
+		{-2, nil, ErrInvalidDays},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.days), func(t *testing.T) {
+			got, err := filterSinceDays(tasks, now, tt.days)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if ids := taskIds(got); !slices.Equal(ids, tt.want) {
+				t.Errorf("got %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestListWithSchema(t *testing.T) {
+	for _, camel := range []bool{false, true} {
+		t.Run(fmt.Sprint("camel=", camel), func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a"})
+
+			args, schemaArgs := []string{"--json", "--with-schema"}, []string(nil)
+			if camel {
+				args, schemaArgs = append(args, "--camel"), []string{"--camel"}
+			}
+
+			out, err := runTestCommand(t, listCommand, store, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var envelope struct {
+				Schema string            `json:"$schema"`
+				Tasks  []json.RawMessage `json:"tasks"`
+			}
+			if err = json.Unmarshal([]byte(out), &envelope); err != nil {
+				t.Fatalf("%v in:\n%s", err, out)
+			}
+
+			schemaOut, err := runTestCommand(t, schemaCommand, store, schemaArgs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var schema struct {
+				Id string `json:"$id"`
+			}
+			if err = json.Unmarshal([]byte(schemaOut), &schema); err != nil {
+				t.Fatalf("%v in:\n%s", err, schemaOut)
+			}
+
+			if envelope.Schema == "" || envelope.Schema != schema.Id {
+				t.Errorf("got $schema %q, want the schema id %q", envelope.Schema, schema.Id)
+			}
+			if len(envelope.Tasks) != 1 {
+				t.Errorf("got %d tasks, want 1", len(envelope.Tasks))
+			}
+		})
+	}
+
+	// without the flag the array stays bare
+	out, err := runTestCommand(t, listCommand, newTestStore(t, Task{Description: "a"}), "--json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "$schema") || !strings.HasPrefix(out, "[") {
+		t.Errorf("got:\n%s\nwant a bare array", out)
+	}
+}
+
+func TestRenameTag(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		count    int
+		want     [][]string
+	}{
+		{"rename", "work", "office", 2, [][]string{{"office"}, {"home", "office"}, {"job", "urgent"}, nil}},
+		{"keeps tags sorted", "home", "zoo", 1, [][]string{{"work"}, {"work", "zoo"}, {"job", "urgent"}, nil}},
+		{"merge into existing tag", "work", "home", 2, [][]string{{"home"}, {"home"}, {"job", "urgent"}, nil}},
+		{"unknown tag", "nope", "office", 0, [][]string{{"work"}, {"home", "work"}, {"job", "urgent"}, nil}},
+		{"same tag", "work", "work", 0, [][]string{{"work"}, {"home", "work"}, {"job", "urgent"}, nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			store.Tasks = []Task{
+				{Id: 1, Description: "a", Tags: []string{"work"}},
+				{Id: 2, Description: "b", Tags: []string{"home", "work"}},
+				{Id: 3, Description: "c", Tags: []string{"job", "urgent"}},
+				{Id: 4, Description: "d"},
+			}
+
+			count, err := store.RenameTag(tt.old, tt.new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.count {
+				t.Errorf("changed %d tasks, want %d", count, tt.count)
+			}
+			for i, task := range store.Tasks {
+				if !slices.Equal(task.Tags, tt.want[i]) {
+					t.Errorf("task %d has tags %v, want %v", task.Id, task.Tags, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTagCounts(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []Task
+		want  map[string]int
+	}{
+		{"no tasks", nil, map[string]int{}},
+		{"untagged", []Task{{Id: 1}}, map[string]int{}},
+		{"tagged", []Task{
+			{Id: 1, Tags: []string{"work"}},
+			{Id: 2, Tags: []string{"home", "work"}},
+			{Id: 3, Tags: []string{"home"}, Status: TaskStatusDone},
+			{Id: 4},
+		}, map[string]int{"home": 2, "work": 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &TaskStore{Tasks: tt.tasks}
+			if got := store.TagCounts(); !maps.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSavePreservesUnknownFields(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "task.json")
+	data := `{
+  "meta": {"current_id": 3},
+  "owner": {"name": "ana"},
+  "tasks": [
+    {"id": 1, "description": "a", "status": 1, "reminder": "tomorrow"},
+    {"id": 2, "description": "b", "status": 3, "votes": [1, 2]}
+  ]
+}`
+	if err := os.WriteFile(dbPath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenTaskStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a change through the store must not drop what it does not know
+	if _, err = store.Create(Task{Description: "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Owner json.RawMessage              `json:"owner"`
+		Tasks []map[string]json.RawMessage `json:"tasks"`
+	}
+	if err = json.Unmarshal(saved, &got); err != nil {
+		t.Fatalf("%v in:\n%s", err, saved)
+	}
+
+	if !jsonEqual(t, got.Owner, `{"name": "ana"}`) {
+		t.Errorf("got owner %s", got.Owner)
+	}
+	if len(got.Tasks) != 3 {
+		t.Fatalf("got %d tasks, want 3", len(got.Tasks))
+	}
+	if !jsonEqual(t, got.Tasks[0]["reminder"], `"tomorrow"`) {
+		t.Errorf("got reminder %s", got.Tasks[0]["reminder"])
+	}
+	if !jsonEqual(t, got.Tasks[1]["votes"], `[1, 2]`) {
+		t.Errorf("got votes %s", got.Tasks[1]["votes"])
+	}
+	if _, ok := got.Tasks[2]["reminder"]; ok {
+		t.Errorf("the new task got the fields of another: %v", got.Tasks[2])
+	}
+}
+
+func TestPaginateTasks(t *testing.T) {
+	tasks := []Task{{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4}, {Id: 5}}
+
+	tests := []struct {
+		name          string
+		offset, limit int
+		want          []TaskId
+		wantErr       error
+	}{
+		{"everything", 0, 0, []TaskId{1, 2, 3, 4, 5}, nil},
+		{"limit 0 keeps every task", 2, 0, []TaskId{3, 4, 5}, nil},
+		{"first page", 0, 2, []TaskId{1, 2}, nil},
+		{"middle page", 2, 2, []TaskId{3, 4}, nil},
+		{"last page", 4, 2, []TaskId{5}, nil},
+		{"past the end", 10, 2, nil, nil},
+		{"limit above count", 0, 10, []TaskId{1, 2, 3, 4, 5}, nil},
+		{"negative limit", 0, -1, nil, ErrInvalidLimit},
+		{"negative offset", -1, 0, nil, ErrInvalidOffset},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := paginateTasks(tasks, tt.offset, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if got := taskIds(page); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoveTo(t *testing.T) {
+	store := newTestStore(t, Task{Description: "a"}, Task{Description: "b", Tags: []string{"x"}})
+	targetPath := filepath.Join(t.TempDir(), "work.json")
+	target, err := OpenTaskStore(targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = target.CreateMany([]Task{{Description: "c"}, {Description: "d"}, {Description: "e"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runTestCommand(t, moveToCommand, store, targetPath, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "Task moved successfully: (ID: 4)\n" {
+		t.Errorf("got %q", out)
+	}
+
+	source, err := OpenTaskStore(store.dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := taskIds(source.Tasks); !slices.Equal(ids, []TaskId{1}) {
+		t.Errorf("got source tasks %v, want [1]", ids)
+	}
+
+	if target, err = OpenTaskStore(targetPath); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := target.GetById(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Description != "b" || !slices.Equal(moved.Tags, []string{"x"}) {
+		t.Errorf("got moved task %+v", moved)
+	}
+}
+
+func TestMoveToDropsDependencies(t *testing.T) {
+	store := newTestStore(t, Task{Description: "a"}, Task{Description: "b"})
+	if err := store.AddDependency(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	// task 1 of the target is not the task 1 of the store
+	target := newTestStore(t, Task{Description: "c"})
+
+	moved, err := store.MoveTo(target, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved.DependsOn) != 0 {
+		t.Errorf("the moved task depends on %v, want nothing", moved.DependsOn)
+	}
+
+	task, err := target.GetById(moved.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.DependsOn) != 0 || task.Blocked(target) {
+		t.Errorf("got the moved task %+v blocked in the target", task)
+	}
+}
+
+func TestMoveToCopiesBlobs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(file, []byte("remember the milk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newTestStore(t, Task{Description: "a"})
+	attachment, err := store.AttachFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := store.Tasks[0]
+	task.Attachments = []Attachment{attachment, {Name: "https://example.com", URL: "https://example.com"}}
+	if err = store.Update(task); err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestStore(t)
+	moved, err := store.MoveTo(target, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(target.blobPath(moved.Attachments[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "remember the milk" {
+		t.Errorf("got the blob %q in the target", data)
+	}
+	// other tasks of the store may share the blob
+	if _, err = os.Stat(store.blobPath(attachment)); err != nil {
+		t.Errorf("the blob left the store: %v", err)
+	}
+}
+
+func TestMoveToFailedTargetSave(t *testing.T) {
+	tests := []struct {
+		name   string
+		target func(t *testing.T) *TaskStore
+		err    error
+	}{
+		{"frozen", func(t *testing.T) *TaskStore {
+			target := newTestStore(t)
+			if err := target.SetFrozen(true); err != nil {
+				t.Fatal(err)
+			}
+			return target
+		}, ErrStoreFrozen},
+		{"unwritable", func(t *testing.T) *TaskStore {
+			// a directory in place of the target file cannot be written
+			target := newTestStore(t)
+			if err := os.Mkdir(target.dbPath, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			return target
+		}, nil},
+		{"description taken", func(t *testing.T) *TaskStore {
+			return newTestStore(t, Task{Description: "a"})
+		}, ErrTaskAlreadyExists},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a"})
+
+			_, err := store.MoveTo(tt.target(t), 1)
+			if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			source, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := taskIds(source.Tasks); !slices.Equal(ids, []TaskId{1}) {
+				t.Errorf("got source tasks %v, want the task kept", ids)
+			}
+		})
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		str   string
+		width int
+		want  []string
+	}{
+		{"buy milk", 10, []string{"buy milk"}},
+		{"buy some milk today", 10, []string{"buy some", "milk today"}},
+		{"a b c d", 3, []string{"a b", "c d"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"go abcdefgh", 4, []string{"go", "abcd", "efgh"}},
+		{"one\ntwo three", 20, []string{"one", "two three"}},
+		{"你好世界", 4, []string{"你好", "世界"}},
+		{"", 10, []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			got := wrapText(tt.str, tt.width)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			for _, line := range got {
+				if displayWidth(line) > tt.width {
+					t.Errorf("line %q is wider than %d", line, tt.width)
+				}
+			}
+		})
+	}
+}
+
+func TestBoxRendererWraps(t *testing.T) {
+	description := strings.Repeat("word ", 20)
+	tasks := []Task{{Id: 1, Status: TaskStatusTodo, Description: description}}
+
+	for _, name := range []string{"box", "ascii"} {
+		t.Run(name, func(t *testing.T) {
+			renderer, err := NewRenderer(name, RenderOptions{TimeFormat: time.DateTime})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err = renderer.Render(&out, tasks); err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			// borders, header, separator, the wrapped description and the bottom
+			if want := 3 + len(wrapText(description, maxBoxDescriptionWidth)) + 1; len(lines) != want {
+				t.Fatalf("got %d lines, want %d:\n%s", len(lines), want, out.String())
+			}
+			for _, line := range lines {
+				if displayWidth(line) != displayWidth(lines[0]) {
+					t.Errorf("line %q is not as wide as the border %q", line, lines[0])
+				}
+			}
+			if name == "ascii" && strings.ContainsAny(out.String(), "│─┌┐└┘") {
+				t.Errorf("got box drawing characters in:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestEditJSON(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub editor is a shell script")
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		err    error
+		want   []string
+	}{
+		{"valid", `sed -i 's/"buy milk"/"buy oat milk"/' "$1"`, nil, []string{"buy oat milk", "walk dog"}},
+		{"unchanged", `true`, nil, []string{"buy milk", "walk dog"}},
+		{"duplicate id", `sed -i 's/"id": 2/"id": 1/' "$1"`, ErrInvalidStore, []string{"buy milk", "walk dog"}},
+		{"invalid status", `sed -i 's/"status": 1/"status": 9/' "$1"`, ErrInvalidStore, []string{"buy milk", "walk dog"}},
+		{"current id too low", `sed -i 's/"current_id": 3/"current_id": 1/' "$1"`, ErrInvalidStore, []string{"buy milk", "walk dog"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the stub editor changes the file it is given in place
+			editor := filepath.Join(t.TempDir(), "editor")
+			if err := os.WriteFile(editor, []byte("#!/bin/sh\n"+tt.script+"\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("EDITOR", editor)
+
+			store := newTestStore(t, Task{Description: "buy milk"}, Task{Description: "walk dog"})
+			if _, err := runTestCommand(t, editCommand, store, "--json"); !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			saved, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, task := range saved.Tasks {
+				got = append(got, task.Description)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListMine(t *testing.T) {
+	tests := []struct {
+		user string
+		args []string
+		want []TaskId
+	}{
+		{"ana", []string{"--mine"}, []TaskId{1, 3}},
+		{"bob", []string{"--mine"}, []TaskId{2}},
+		{"eve", []string{"--mine"}, nil},
+		{"ana", []string{"--assignee", "bob"}, []TaskId{2}},
+		{"ana", []string{"--mine", "done"}, []TaskId{3}},
+		{"ana", nil, []TaskId{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user+" "+strings.Join(tt.args, " "), func(t *testing.T) {
+			t.Setenv("USER", tt.user)
+			store := newTestStore(t,
+				Task{Description: "a", Assignee: "ana"},
+				Task{Description: "b", Assignee: "bob"},
+				Task{Description: "c", Assignee: "ana", Status: TaskStatusDone},
+				Task{Description: "d"},
+			)
+
+			out, err := runTestCommand(t, listCommand, store, append([]string{"--json"}, tt.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var views []struct {
+				Id TaskId `json:"id"`
+			}
+			if err = json.Unmarshal([]byte(out), &views); err != nil {
+				t.Fatalf("%v in:\n%s", err, out)
+			}
+			var got []TaskId
+			for _, view := range views {
+				got = append(got, view.Id)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecomputeCurrentId(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  uint64
+		ids      []TaskId
+		archived []TaskId
+		want     uint64
+	}{
+		{"empty store", 1, nil, nil, 1},
+		{"already right", 4, []TaskId{1, 3}, nil, 4},
+		{"gap after deletes", 10, []TaskId{1, 2}, nil, 3},
+		{"behind the tasks", 2, []TaskId{1, 5}, nil, 6},
+		{"archived ids are not reused", 10, []TaskId{1}, []TaskId{7}, 8},
+		{"live ids above archived ones", 2, []TaskId{9}, []TaskId{7}, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			store.Meta.CurrentId = tt.current
+			for _, id := range tt.ids {
+				store.Tasks = append(store.Tasks, Task{Id: id, Description: fmt.Sprint(id)})
+			}
+
+			if tt.archived != nil {
+				archive, err := store.OpenArchive()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, id := range tt.archived {
+					archive.Tasks = append(archive.Tasks, Task{Id: id, Description: fmt.Sprint(id)})
+				}
+				if err = archive.Save(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			before, after, err := store.RecomputeCurrentId()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if before != tt.current || after != tt.want {
+				t.Errorf("got (%d, %d), want (%d, %d)", before, after, tt.current, tt.want)
+			}
+			if store.Meta.CurrentId != tt.want {
+				t.Errorf("next id is %d, want %d", store.Meta.CurrentId, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescWidth(t *testing.T) {
+	description := strings.Repeat("x", 100)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--desc-width", "10", "list"}, "xxxxxxxxxx…(+90 chars)"},
+		{[]string{"list", "--desc-width=30"}, strings.Repeat("x", 30) + "…(+70 chars)"},
+		{[]string{"--desc-width=0", "list"}, description},
+		{[]string{"--desc-width", "10", "list", "--full"}, description},
+		{[]string{"list"}, strings.Repeat("x", maxDescriptionWidth) + fmt.Sprintf("…(+%d chars)", 100-maxDescriptionWidth)},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			options, rest, err := parseGlobalOptions(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := defaultConfig()
+			for key, value := range options.Config {
+				if err = config.Set(key, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var out bytes.Buffer
+			state := &CommandState{
+				TaskStore: newTestStore(t, Task{Description: description}),
+				Args:      rest[1:],
+				IO:        IO{In: strings.NewReader(""), Out: &out, Err: &out},
+				Config:    config,
+			}
+			if err = listCommand(state); err != nil {
+				t.Fatal(err)
+			}
+
+			row := strings.TrimSpace(strings.Split(out.String(), "\n")[1])
+			if !strings.HasSuffix(row, "    "+tt.want) {
+				t.Errorf("got row %q, want the description %q", row, tt.want)
+			}
+		})
+	}
+
+	config := defaultConfig()
+	if err := config.Set("desc_width", "-1"); !errors.Is(err, ErrInvalidDescWidth) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidDescWidth)
+	}
+}
+
+func TestListWithMeta(t *testing.T) {
+	tests := []struct {
+		args []string
+		meta string
+	}{
+		{[]string{"--json"}, ""},
+		{[]string{"--json", "--with-meta"}, `{"current_id":4}`},
+		{[]string{"--json", "--with-meta", "--camel"}, `{"currentId":4}`},
+		// the watermark is the one of the store, whatever is listed
+		{[]string{"--json", "--with-meta", "done"}, `{"current_id":4}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a"}, Task{Description: "b"}, Task{Description: "c"})
+			if err := store.Delete(store.Tasks[1]); err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := runTestCommand(t, listCommand, store, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.meta == "" {
+				if !strings.HasPrefix(out, "[") {
+					t.Errorf("got:\n%s\nwant a bare array", out)
+				}
+				return
+			}
+
+			var envelope struct {
+				Meta  json.RawMessage   `json:"meta"`
+				Tasks []json.RawMessage `json:"tasks"`
+			}
+			if err = json.Unmarshal([]byte(out), &envelope); err != nil {
+				t.Fatalf("%v in:\n%s", err, out)
+			}
+			var meta bytes.Buffer
+			if err = json.Compact(&meta, envelope.Meta); err != nil {
+				t.Fatal(err)
+			}
+			if meta.String() != tt.meta {
+				t.Errorf("got meta %s, want %s", meta.String(), tt.meta)
+			}
+			if envelope.Tasks == nil {
+				t.Errorf("got no tasks array in:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestTouch(t *testing.T) {
+	store := newTestStore(t, Task{Description: "a", Tags: []string{"x"}}, Task{Description: "b"})
+	before := store.Tasks[0]
+	other := store.Tasks[1]
+	time.Sleep(time.Millisecond)
+
+	out, err := runTestCommand(t, touchCommand, store, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "Task touched successfully\n" {
+		t.Errorf("got %q", out)
+	}
+
+	saved, err := OpenTaskStore(store.dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := saved.Tasks[0]
+	if !after.UpdatedAt.After(before.UpdatedAt) {
+		t.Errorf("got updated at %v, want it after %v", after.UpdatedAt, before.UpdatedAt)
+	}
+
+	// once the update time is put back, nothing else may differ
+	after.UpdatedAt = before.UpdatedAt
+	want, _ := json.Marshal(before)
+	got, _ := json.Marshal(after)
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+	if !saved.Tasks[1].UpdatedAt.Equal(other.UpdatedAt) {
+		t.Errorf("the other task was touched too")
+	}
+
+	if _, err = runTestCommand(t, touchCommand, saved, "9"); !errors.Is(err, ErrTaskDoesNotExist) {
+		t.Errorf("got error %v, want %v", err, ErrTaskDoesNotExist)
+	}
+}
+
+func TestNoOpMutations(t *testing.T) {
+	tests := []struct {
+		name      string
+		commandFn func(*CommandState) error
+		args      []string
+		changed   bool
+	}{
+		{"mark the same status", markCommand, []string{"1", "done"}, false},
+		{"update the same description", updateCommand, []string{"1", "a"}, false},
+		{"update the same status", updateCommand, []string{"--status", "done", "1", "a"}, false},
+		{"mark another status", markCommand, []string{"1", "todo"}, true},
+		{"update another description", updateCommand, []string{"1", "b"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a", Status: TaskStatusDone})
+			before := store.Tasks[0].UpdatedAt
+
+			old := time.Now().Add(-time.Hour).Truncate(time.Second)
+			if err := os.Chtimes(store.dbPath, old, old); err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := runTestCommand(t, tt.commandFn, store, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if noChanges := out == "No changes\n"; noChanges == tt.changed {
+				t.Errorf("got %q", out)
+			}
+
+			info, err := os.Stat(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written := !info.ModTime().Equal(old); written != tt.changed {
+				t.Errorf("store written = %v, want %v", written, tt.changed)
+			}
+
+			saved, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bumped := !saved.Tasks[0].UpdatedAt.Equal(before); bumped != tt.changed {
+				t.Errorf("updated at bumped = %v, want %v", bumped, tt.changed)
+			}
+		})
+	}
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("TASK_APP_NAME", "")
+
+	store := newTestStore(t, Task{Description: "a", Tags: []string{"x"}}, Task{Description: "b", Status: TaskStatusDone})
+	configPath, err := userConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(configPath, []byte("sort = status\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() (files [3]string) {
+		for i, file := range []string{store.dbPath, store.journalPath(), configPath} {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[i] = string(data)
+		}
+		return
+	}
+	want := read()
+
+	zipPath := filepath.Join(t.TempDir(), "backup.zip")
+	if _, err = runTestCommand(t, exportCommand, store, "--zip", zipPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// change everything the backup holds
+	if _, err = store.Create(Task{Description: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(configPath, []byte("sort = description\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changed := read()
+
+	out, err := runTestCommandInput(t, importCommand, store, "n\n", "--zip", zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out, "Aborted\n") || read() != changed {
+		t.Fatalf("got %q, want the restore aborted and nothing touched", out)
+	}
+
+	if _, err = runTestCommandInput(t, importCommand, store, "y\n", "--zip", zipPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != want {
+		t.Errorf("got the files:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestReadBackupInvalid(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("TASK_APP_NAME", "")
+
+	tests := []struct {
+		name    string
+		entries map[string]string
+	}{
+		{"no store", map[string]string{configFileName: "sort = id\n"}},
+		{"unexpected file", map[string]string{"task.json": `{"meta":{"current_id":1},"tasks":[]}`, "../evil": "x"}},
+		{"escaping blob", map[string]string{"task.json": `{"meta":{"current_id":1},"tasks":[]}`, "task.blobs/../x": "x"}},
+		{"broken store", map[string]string{"task.json": `{"tasks": [`}},
+		{"invalid store", map[string]string{"task.json": `{"meta":{"current_id":1},"tasks":[{"id":1,"status":9}]}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			archive := zip.NewWriter(&buf)
+			for name, content := range tt.entries {
+				entry, err := archive.Create(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = entry.Write([]byte(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := archive.Close(); err != nil {
+				t.Fatal(err)
+			}
+			zipPath := filepath.Join(t.TempDir(), "backup.zip")
+			if err := os.WriteFile(zipPath, buf.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			store := newTestStore(t, Task{Description: "a"})
+			before, err := os.ReadFile(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = runTestCommand(t, importCommand, store, "--zip", "--yes", zipPath); !errors.Is(err, ErrInvalidBackup) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidBackup)
+			}
+
+			after, err := os.ReadFile(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(after, before) {
+				t.Errorf("the store was touched")
+			}
+		})
+	}
+}
+
+func TestChooseRenderer(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr error
+	}{
+		{nil, "table", nil},
+		{[]string{"--json"}, "json", nil},
+		{[]string{"--json=false"}, "table", nil},
+		{[]string{"--table"}, "box", nil},
+		{[]string{"--renderer", "csv"}, "csv", nil},
+		{[]string{"--json", "--json=false", "--tree"}, "tree", nil},
+		{[]string{"--json", "--tree"}, "", ErrConflictingFlags},
+		{[]string{"--renderer", "csv", "--json"}, "", ErrConflictingFlags},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			flags := flag.NewFlagSet("list", flag.ContinueOnError)
+			flags.String("renderer", "", "")
+			flags.Bool("json", false, "")
+			flags.Bool("json-seq", false, "")
+			flags.Bool("tree", false, "")
+			flags.Bool("table", false, "")
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := chooseRenderer(flags, "table", listRendererFlags)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChooseRendererNamesConflicts(t *testing.T) {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.Bool("json", false, "")
+	flags.Bool("tree", false, "")
+	if err := flags.Parse([]string{"--tree", "--json"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := chooseRenderer(flags, "table", []rendererFlag{{"json", "json"}, {"tree", "tree"}})
+	if err == nil || !strings.Contains(err.Error(), "--json, --tree") {
+		t.Errorf("got %v, want the conflicting flags named", err)
+	}
+}
+
+func TestPinned(t *testing.T) {
+	store := newTestStore(t,
+		Task{Description: "d"},
+		Task{Description: "c"},
+		Task{Description: "b"},
+		Task{Description: "a"},
+	)
+
+	for _, id := range []string{"3", "2"} {
+		if _, err := runTestCommand(t, pinCommand, store, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the pinned state is read back from the file
+	reopened, err := OpenTaskStore(store.dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want []TaskId
+	}{
+		{nil, []TaskId{2, 3, 1, 4}},
+		{[]string{"--pinned"}, []TaskId{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if got := listedIds(t, reopened, tt.args...); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// pinned tasks come first whatever the sort key
+	var out bytes.Buffer
+	state := &CommandState{
+		TaskStore: reopened,
+		Args:      []string{"--json"},
+		IO:        IO{In: strings.NewReader(""), Out: &out, Err: &out},
+		Config:    defaultConfig(),
+	}
+	state.Config.Sort = "description"
+	if err = listCommand(state); err != nil {
+		t.Fatal(err)
+	}
+	var views []struct {
+		Id TaskId `json:"id"`
+	}
+	if err = json.Unmarshal(out.Bytes(), &views); err != nil {
+		t.Fatal(err)
+	}
+	var got []TaskId
+	for _, view := range views {
+		got = append(got, view.Id)
+	}
+	if !slices.Equal(got, []TaskId{3, 2, 4, 1}) {
+		t.Errorf("got %v sorted by description, want [3 2 4 1]", got)
+	}
+
+	if _, err = runTestCommand(t, unpinCommand, reopened, "3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := listedIds(t, reopened); !slices.Equal(got, []TaskId{2, 1, 3, 4}) {
+		t.Errorf("got %v after unpinning, want [2 1 3 4]", got)
+	}
+	if _, err = runTestCommand(t, pinCommand, reopened, "9"); !errors.Is(err, ErrTaskDoesNotExist) {
+		t.Errorf("got error %v, want %v", err, ErrTaskDoesNotExist)
+	}
+}
+
+func TestDoctorJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		store string
+		want  []StoreIssue
+	}{
+		{"clean", `{"meta":{"current_id":3},"tasks":[{"id":1,"description":"a","status":1},{"id":2,"description":"b","status":3}]}`, []StoreIssue{}},
+		{"broken", `{"meta":{"current_id":2},"tasks":[{"id":1,"description":"a","status":1},{"id":1,"description":"b","status":7},{"id":3,"description":" ","status":1},{"id":4,"description":"a","status":3}]}`, []StoreIssue{
+			{"duplicate_id", 1, "id 1 is used more than once"},
+			{"invalid_status", 1, "task 1 has an invalid status"},
+			{"empty_description", 3, "task 3 has an empty description"},
+			{"current_id_behind", 3, "current id 2 is not past task id 3"},
+			{"duplicate_description", 4, "task 4 has the same description as task 1"},
+			{"current_id_behind", 4, "current id 2 is not past task id 4"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "task.json")
+			if err := os.WriteFile(dbPath, []byte(tt.store), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			store, err := OpenTaskStore(dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := runTestCommand(t, doctorCommand, store, "--json")
+			// the error is what makes the exit status non zero
+			if failed := errors.Is(err, ErrStoreHasIssues); failed != (len(tt.want) > 0) || (err != nil && !failed) {
+				t.Errorf("got error %v with %d issues", err, len(tt.want))
+			}
+
+			var got []StoreIssue
+			if err = json.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatalf("%v in:\n%s", err, out)
+			}
+			if got == nil || !slices.Equal(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+
+			var fields []map[string]any
+			if err = json.Unmarshal([]byte(out), &fields); err != nil {
+				t.Fatal(err)
+			}
+			for _, issue := range fields {
+				for _, key := range []string{"kind", "task_id", "detail"} {
+					if _, ok := issue[key]; !ok {
+						t.Errorf("got no %s in %v", key, issue)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAddCommandNameGuard(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		terminal bool
+		created  bool
+	}{
+		{"command name at a terminal", []string{"list"}, true, false},
+		{"forced at a terminal", []string{"--force", "list"}, true, true},
+		{"command name from a pipe", []string{"list"}, false, true},
+		{"other description at a terminal", []string{"list the groceries"}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in io.Reader = strings.NewReader("")
+			if tt.terminal {
+				// a character device stands in for the terminal, it reads
+				// as an unanswered prompt
+				file, err := os.Open(os.DevNull)
+				if err != nil || !isTerminal(file) {
+					t.Skip("no character device to stand in for a terminal")
+				}
+				defer file.Close()
+				in = file
+			}
+
+			store := newTestStore(t)
+			var out bytes.Buffer
+			state := &CommandState{
+				TaskStore: store,
+				Args:      tt.args,
+				IO:        IO{In: in, Out: &out, Err: &out},
+				Config:    defaultConfig(),
+			}
+			if err := addCommand(state); err != nil {
+				t.Fatal(err)
+			}
+
+			if created := len(store.Tasks) == 1; created != tt.created {
+				t.Errorf("task created = %v, want %v:\n%s", created, tt.created, out.String())
+			}
+			if aborted := strings.Contains(out.String(), "Aborted"); aborted == tt.created {
+				t.Errorf("got:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestJSONDeterministic(t *testing.T) {
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	tasks := []Task{{
+		Id:          1,
+		Description: "a",
+		Status:      TaskStatusTodo,
+		CreatedAt:   created,
+		UpdatedAt:   created,
+		Tags:        []string{"zeta", "alpha", "mid", "beta"},
+		DependsOn:   []TaskId{9, 3, 5},
+		Custom:      map[string]any{"z": 1, "a": "x", "m": true, "b": 2.5, "k": "y"},
+	}}
+
+	for _, options := range []viewOptions{{}, {Camel: true}, {Compact: true}} {
+		t.Run(fmt.Sprintf("%+v", options), func(t *testing.T) {
+			var first bytes.Buffer
+			if err := writeTasksJSON(&first, tasks, options); err != nil {
+				t.Fatal(err)
+			}
+			for range 50 {
+				var out bytes.Buffer
+				if err := writeTasksJSON(&out, tasks, options); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out.Bytes(), first.Bytes()) {
+					t.Fatalf("got:\n%s\nthen:\n%s", first.String(), out.String())
+				}
+			}
+
+			// the keys follow the struct, id first, not the sorted map order
+			if !strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(first.String(), "[")), `{
+    "id": 1,
+    "description": "a",`) {
+				t.Errorf("got the key order:\n%s", first.String())
+			}
+		})
+	}
+
+	view := decodeTaskViews(t, tasks, viewOptions{})[0]
+	if got := fmt.Sprint(view["tags"]); got != "[alpha beta mid zeta]" {
+		t.Errorf("got tags %s, want them sorted", got)
+	}
+	if got := fmt.Sprint(view["depends_on"]); got != "[3 5 9]" {
+		t.Errorf("got depends_on %s, want them sorted", got)
+	}
+}
+
+func TestContextCommand(t *testing.T) {
+	configHome := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "other.json")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want contextInfo
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"USER": "ana"},
+			want: contextInfo{DB: path.Join(configHome, "task", "task.json"), Profile: "task", User: "ana", Color: "auto", Sort: "id"},
+		},
+		{
+			name: "profile and env",
+			env:  map[string]string{"USER": "bob", "TASK_APP_NAME": "work", "TASK_COLOR": "always", "TASK_SORT": "status"},
+			want: contextInfo{DB: path.Join(configHome, "work", "task.json"), Profile: "work", User: "bob", Color: "always", ColorEnabled: true, Sort: "status"},
+		},
+		{
+			name: "flags over env",
+			env:  map[string]string{"USER": "bob", "TASK_APP_NAME": "work", "TASK_COLOR": "always", "TASK_SORT": "status"},
+			args: []string{"--db", dbPath, "--sort=description", "--color", "never"},
+			want: contextInfo{DB: dbPath, Profile: "work", User: "bob", Color: "never", Sort: "description"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", configHome)
+			t.Setenv("TASK_APP_NAME", "")
+			clearConfigEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			chdir(t, t.TempDir())
+
+			// resolve the settings the way main does
+			options, rest, err := parseGlobalOptions(append(tt.args, "context", "--json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := loadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range options.Config {
+				if err = config.Set(key, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var out bytes.Buffer
+			state, err := NewCommandState(rest[1:], IO{In: strings.NewReader(""), Out: &out, Err: &out}, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = contextCommand(state); err != nil {
+				t.Fatal(err)
+			}
+
+			var got contextInfo
+			if err = json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatalf("%v in:\n%s", err, out.String())
+			}
+			if got != tt.want {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailIfEmpty(t *testing.T) {
+	tests := []struct {
+		name      string
+		commandFn func(*CommandState) error
+		args      []string
+		wantErr   error
+	}{
+		{"list without the flag", listCommand, []string{"done"}, nil},
+		{"list matching", listCommand, []string{"--fail-if-empty", "todo"}, nil},
+		{"list nothing", listCommand, []string{"--fail-if-empty", "done"}, ErrNoTasksFound},
+		{"list nothing as json", listCommand, []string{"--fail-if-empty", "--json", "done"}, ErrNoTasksFound},
+		{"search without the flag", searchCommand, []string{"nope"}, nil},
+		{"search matching", searchCommand, []string{"--fail-if-empty", "milk"}, nil},
+		{"search nothing", searchCommand, []string{"--fail-if-empty", "nope"}, ErrNoTasksFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			if _, err := store.Create(Task{Description: "buy milk"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := runTestCommand(t, tt.commandFn, store, tt.args...); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "task")
+	dbPath := filepath.Join(dir, "task.json")
+
+	store, err := OpenTaskStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Tasks) != 0 || store.Meta.CurrentId != 1 {
+		t.Errorf("got %d tasks and next id %d, want an empty store", len(store.Tasks), store.Meta.CurrentId)
+	}
+	if _, err = os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("loading created the store directory: %v", err)
+	}
+
+	if _, err = store.Create(Task{Description: "buy milk"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(dbPath); err != nil {
+		t.Errorf("saving did not create the store: %v", err)
+	}
+}
+
+func BenchmarkLoad(b *testing.B) {
+	dbPath := filepath.Join(b.TempDir(), "task.json")
+	store, err := OpenTaskStore(dbPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	tasks := make([]Task, 1000)
+	for i := range tasks {
+		tasks[i] = Task{Description: fmt.Sprintf("task %d", i), Tags: []string{"work"}}
+	}
+	if _, err = store.CreateMany(tasks); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for range b.N {
+		if _, err = OpenTaskStore(dbPath); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestListPager(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub pager is a shell script")
+	}
+
+	tests := []struct {
+		name     string
+		terminal bool
+		args     []string
+		paged    bool
+	}{
+		{"terminal", true, nil, true},
+		{"no pager", true, []string{"--no-pager"}, false},
+		{"piped", false, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the stub pager copies what it is given to the file it is passed
+			dir := t.TempDir()
+			pager, paged := filepath.Join(dir, "pager"), filepath.Join(dir, "paged")
+			if err := os.WriteFile(pager, []byte("#!/bin/sh\ncat > \"$1\"\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PAGER", pager+" "+paged)
+
+			var out io.Writer = new(bytes.Buffer)
+			if tt.terminal {
+				// a character device stands in for the terminal
+				file, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+				if err != nil || !isTerminal(file) {
+					t.Skip("no character device to stand in for a terminal")
+				}
+				defer file.Close()
+				out = file
+			}
+
+			state := &CommandState{
+				TaskStore: newTestStore(t, Task{Description: "buy milk"}),
+				Args:      tt.args,
+				IO:        IO{In: strings.NewReader(""), Out: out, Err: io.Discard},
+				Config:    defaultConfig(),
+			}
+			if err := listCommand(state); err != nil {
+				t.Fatal(err)
+			}
+			if state.IO.Out != out {
+				t.Errorf("the output was not restored once the pager quit")
+			}
+
+			data, err := os.ReadFile(paged)
+			if invoked := err == nil; invoked != tt.paged {
+				t.Fatalf("pager invoked = %v, want %v", invoked, tt.paged)
+			}
+			if tt.paged && !strings.Contains(string(data), "buy milk") {
+				t.Errorf("got paged:\n%s", data)
+			}
+		})
+	}
+
+	// a pager that cannot start leaves the output direct
+	t.Setenv("PAGER", filepath.Join(t.TempDir(), "missing"))
+	file, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	state := &CommandState{IO: IO{Out: file, Err: io.Discard}}
+	if err = state.startPager()(); err != nil || state.IO.Out != file {
+		t.Errorf("got error %v and output %v, want the output left direct", err, state.IO.Out)
+	}
+}
+
+func TestMarkAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []TaskStatus
+		status   TaskStatus
+		count    int
+	}{
+		{"no tasks", nil, TaskStatusDone, 0},
+		{"all done", []TaskStatus{TaskStatusTodo, TaskStatusInProgress, TaskStatusDone}, TaskStatusDone, 2},
+		{"all todo", []TaskStatus{TaskStatusTodo, TaskStatusInProgress, TaskStatusDone}, TaskStatusTodo, 2},
+		{"nothing to change", []TaskStatus{TaskStatusInProgress, TaskStatusInProgress}, TaskStatusInProgress, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			for i, status := range tt.statuses {
+				if _, err := store.Create(Task{Description: fmt.Sprint(i), Status: status}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			unchanged := slices.Clone(store.Tasks)
+
+			count, err := store.MarkAll(tt.status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.count {
+				t.Errorf("changed %d tasks, want %d", count, tt.count)
+			}
+
+			for i, task := range store.Tasks {
+				if task.Status != tt.status {
+					t.Errorf("task %d is %v, want %v", task.Id, task.Status, tt.status)
+				}
+				if done := task.CompletedAt != nil; done != (tt.status == TaskStatusDone) {
+					t.Errorf("task %d completed at %v", task.Id, task.CompletedAt)
+				}
+				if unchanged[i].Status == tt.status && !task.UpdatedAt.Equal(unchanged[i].UpdatedAt) {
+					t.Errorf("task %d was already %v but got updated", task.Id, tt.status)
+				}
+			}
+
+			// the changes are saved
+			reopened, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, task := range reopened.Tasks {
+				if task.Status != tt.status {
+					t.Errorf("task %d saved as %v, want %v", task.Id, task.Status, tt.status)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkAllRecurring(t *testing.T) {
+	store := newTestStore(t,
+		Task{Description: "water the plants", Recurrence: "1w"},
+		Task{Description: "buy milk"},
+		Task{Description: "pay rent", Recurrence: "1m", Status: TaskStatusDone},
+	)
+
+	count, err := store.MarkAll(TaskStatusDone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("changed %d tasks, want 2", count)
+	}
+
+	// only the recurring task marked done now gets a next occurrence
+	if got := taskIds(store.Tasks); !slices.Equal(got, []TaskId{1, 2, 3, 4}) {
+		t.Fatalf("got tasks %v, want [1 2 3 4]", got)
+	}
+	done, next := store.Tasks[0], store.Tasks[3]
+	if done.Status != TaskStatusDone || done.Recurrence != "" || done.Description == "water the plants" {
+		t.Errorf("got the done occurrence %+v", done)
+	}
+	if next.Status != TaskStatusTodo || next.Recurrence != "1w" || next.Description != "water the plants" || next.DueAt == nil {
+		t.Errorf("got the next occurrence %+v", next)
+	}
+
+	reopened, err := OpenTaskStore(store.dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.Tasks) != 4 || reopened.Meta.CurrentId != 5 {
+		t.Errorf("saved %d tasks and the next id %d, want 4 and 5", len(reopened.Tasks), reopened.Meta.CurrentId)
+	}
+}
+
+func TestDiffStores(t *testing.T) {
+	base := []Task{
+		{Id: 1, Description: "a"},
+		{Id: 2, Description: "b", Tags: []string{"work"}},
+		{Id: 3, Description: "c"},
+	}
+
+	tests := []struct {
+		name     string
+		edit     func(tasks []Task) []Task
+		added    []TaskId
+		removed  []TaskId
+		modified []TaskChange
+	}{
+		{"same", func(tasks []Task) []Task { return tasks }, nil, nil, nil},
+		{"added", func(tasks []Task) []Task { return append(tasks, Task{Id: 4, Description: "d"}) }, []TaskId{4}, nil, nil},
+		{"removed", func(tasks []Task) []Task { return tasks[1:] }, nil, []TaskId{1}, nil},
+		{"modified", func(tasks []Task) []Task {
+			tasks[1].Description = "B"
+			tasks[1].Tags = nil
+			tasks[2].Pinned = true
+			return tasks
+		}, nil, nil, []TaskChange{{Id: 2, Fields: []string{"description", "tags"}}, {Id: 3, Fields: []string{"pinned"}}}},
+		{"renumbered", func(tasks []Task) []Task {
+			tasks[0].Id = 5
+			return tasks
+		}, []TaskId{5}, []TaskId{1}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &TaskStore{Tasks: slices.Clone(base)}
+			b := &TaskStore{Tasks: tt.edit(slices.Clone(base))}
+
+			diff, err := DiffStores(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := taskIds(diff.Added); !slices.Equal(got, tt.added) {
+				t.Errorf("added %v, want %v", got, tt.added)
+			}
+			if got := taskIds(diff.Removed); !slices.Equal(got, tt.removed) {
+				t.Errorf("removed %v, want %v", got, tt.removed)
+			}
+			if !slices.EqualFunc(diff.Modified, tt.modified, func(a, b TaskChange) bool {
+				return a.Id == b.Id && slices.Equal(a.Fields, b.Fields)
+			}) {
+				t.Errorf("modified %v, want %v", diff.Modified, tt.modified)
+			}
+		})
+	}
+}
+
+func TestListJSONRawTime(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "task.json")
+	data := `{"meta":{"current_id":2},"tasks":[{"id":1,"description":"a","status":1,` +
+		`"created_at":"2024-03-01T09:30:00+05:30","updated_at":"2024-03-01T10:00:00-08:00"}]}`
+	if err := os.WriteFile(dbPath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenTaskStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		createdAt string
+		updatedAt string
+	}{
+		{"utc", []string{"--json"}, "2024-03-01T04:00:00Z", "2024-03-01T18:00:00Z"},
+		{"raw", []string{"--json", "--json-raw-time"}, "2024-03-01T09:30:00+05:30", "2024-03-01T10:00:00-08:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runTestCommand(t, listCommand, store, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var views []struct {
+				CreatedAt string `json:"created_at"`
+				UpdatedAt string `json:"updated_at"`
+			}
+			if err = json.Unmarshal([]byte(out), &views); err != nil {
+				t.Fatalf("%v in:\n%s", err, out)
+			}
+			if len(views) != 1 || views[0].CreatedAt != tt.createdAt || views[0].UpdatedAt != tt.updatedAt {
+				t.Errorf("got %+v, want %s and %s", views, tt.createdAt, tt.updatedAt)
+			}
+		})
+	}
+
+	if _, err = runTestCommand(t, listCommand, store, "--json", "--json-raw-time", "--time-epoch"); !errors.Is(err, ErrConflictingFlags) {
+		t.Errorf("got error %v, want %v", err, ErrConflictingFlags)
+	}
+}
+
+func TestSeedTasks(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	oldest := now.AddDate(0, 0, -seedDays)
+
+	for _, count := range []int{0, 1, 200} {
+		t.Run(fmt.Sprint(count), func(t *testing.T) {
+			tasks := seedTasks(count, now, rand.New(rand.NewPCG(1, 2)))
+			if len(tasks) != count {
+				t.Fatalf("got %d tasks, want %d", len(tasks), count)
+			}
+
+			for i, task := range tasks {
+				if !slices.Contains(seedDescriptions, task.Description) {
+					t.Errorf("task %d has description %q", i, task.Description)
+				}
+				if !task.Status.Valid() {
+					t.Errorf("task %d has status %d", i, task.Status)
+				}
+				if _, ok := taskPriorityMapToString[task.Priority]; !ok {
+					t.Errorf("task %d has priority %d", i, task.Priority)
+				}
+				if task.CreatedAt.Before(oldest) || task.CreatedAt.After(now) {
+					t.Errorf("task %d created at %v, want within %d days before %v", i, task.CreatedAt, seedDays, now)
+				}
+				if task.UpdatedAt.Before(task.CreatedAt) || task.UpdatedAt.After(now) {
+					t.Errorf("task %d updated at %v, created at %v", i, task.UpdatedAt, task.CreatedAt)
+				}
+			}
+
+			// the same seed gives the same tasks
+			again := seedTasks(count, now, rand.New(rand.NewPCG(1, 2)))
+			if !slices.EqualFunc(tasks, again, func(a, b Task) bool {
+				return a.Description == b.Description && a.Status == b.Status && a.CreatedAt.Equal(b.CreatedAt)
+			}) {
+				t.Error("the same seed gave different tasks")
+			}
+		})
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "buy milk", 8},
+		{"latin", "café", 4},
+		{"combining mark", "cafe\u0301", 4},
+		{"cjk", "牛乳を買う", 10},
+		{"hangul", "우유", 4},
+		{"emoji", "🚀 launch", 9},
+		{"mixed", "a牛🚀b", 6},
+		{"zero width joiner", "a\u200db", 2},
+		{"color", "\x1b[31mred\x1b[0m", 3},
+		{"highlighted cjk", "\x1b[1;33m牛乳\x1b[0m!", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := displayWidth(tt.s); got != tt.want {
+				t.Errorf("displayWidth(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateWithStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		err    error
+		want   string
+		status TaskStatus
+		// entries is the number of journal entries the update adds
+		entries int
+	}{
+		{"both", []string{"1", "new text", "--status", "in-progress"}, nil, "new text", TaskStatusInProgress, 1},
+		{"flag first", []string{"--status", "done", "1", "new text"}, nil, "new text", TaskStatusDone, 1},
+		{"invalid status", []string{"1", "new text", "--status", "later"}, ErrInvalidTaskStatus, "old text", TaskStatusTodo, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "old text"})
+			journal, err := os.ReadFile(store.journalPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = runTestCommand(t, updateCommand, store, tt.args...); !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			saved, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			task := saved.Tasks[0]
+			if task.Description != tt.want || task.Status != tt.status {
+				t.Errorf("got %q %v, want %q %v", task.Description, task.Status, tt.want, tt.status)
+			}
+
+			// both changes are one mutation, journaled and saved once
+			after, err := os.ReadFile(store.journalPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entries := bytes.Count(after[len(journal):], []byte("\n")); entries != tt.entries {
+				t.Errorf("got %d journal entries, want %d", entries, tt.entries)
+			}
+		})
+	}
+}
+
+func TestListIncludeArchived(t *testing.T) {
+	store := newTestStore(t,
+		Task{Description: "a", Status: TaskStatusDone},
+		Task{Description: "b"},
+		Task{Description: "c", Status: TaskStatusDone},
+	)
+	if _, err := store.ArchiveDoneBefore(time.Now().Add(time.Hour), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(Task{Description: "d", Status: TaskStatusDone}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args     []string
+		tasks    []TaskId
+		archived []TaskId
+		err      error
+	}{
+		{[]string{"--json"}, []TaskId{2, 4}, nil, nil},
+		{[]string{"--json", "--include-archived"}, []TaskId{2, 4}, []TaskId{1, 3}, nil},
+		{[]string{"--json", "--include-archived", "done"}, []TaskId{4}, []TaskId{1, 3}, nil},
+		{[]string{"--json", "--include-archived", "todo"}, []TaskId{2}, []TaskId{}, nil},
+		{[]string{"--include-archived"}, nil, nil, ErrConflictingFlags},
+		{[]string{"--json", "--include-archived", "--archived"}, nil, nil, ErrConflictingFlags},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			out, err := runTestCommand(t, listCommand, store, tt.args...)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			type idView struct {
+				Id TaskId `json:"id"`
+			}
+			ids := func(views []idView) (ids []TaskId) {
+				for _, view := range views {
+					ids = append(ids, view.Id)
+				}
+				return
+			}
+
+			if tt.archived == nil {
+				// without the flag the array stays bare
+				var views []idView
+				if err = json.Unmarshal([]byte(out), &views); err != nil {
+					t.Fatalf("%v in:\n%s", err, out)
+				}
+				if got := ids(views); !slices.Equal(got, tt.tasks) {
+					t.Errorf("got %v, want %v", got, tt.tasks)
+				}
+				return
+			}
+
+			var envelope struct {
+				Tasks    []idView  `json:"tasks"`
+				Archived *[]idView `json:"archived"`
+			}
+			if err = json.Unmarshal([]byte(out), &envelope); err != nil {
+				t.Fatalf("%v in:\n%s", err, out)
+			}
+			if got := ids(envelope.Tasks); !slices.Equal(got, tt.tasks) {
+				t.Errorf("got tasks %v, want %v", got, tt.tasks)
+			}
+			if envelope.Archived == nil {
+				t.Fatalf("got no archived array in:\n%s", out)
+			}
+			if got := ids(*envelope.Archived); !slices.Equal(got, tt.archived) {
+				t.Errorf("got archived %v, want %v", got, tt.archived)
+			}
+		})
+	}
+}
+
+func TestImportInvertedTimes(t *testing.T) {
+	csvInput := "description,created_at,updated_at\n" +
+		"a,2024-03-01T09:30:00Z,2024-03-01T10:00:00Z\n" +
+		"b,2024-03-01T09:30:00Z,2024-02-01T09:30:00Z\n"
+	jsonInput := `[
+  {"description": "a", "created_at": "2024-03-01T09:30:00Z", "updated_at": "2024-03-01T10:00:00Z"},
+  {"description": "b", "created_at": "2024-03-01T09:30:00Z", "updated_at": "2024-02-01T09:30:00Z"}
+]`
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		read    func(io.Reader, bool) ([]Task, []string, error)
+		input   string
+		clamp   bool
+		err     string
+		warning string
+	}{
+		{"csv rejected", readImportCSV, csvInput, false, "row 3: " + ErrInvertedTimestamps.Error(), ""},
+		{"csv clamped", readImportCSV, csvInput, true, "", "row 3: updated_at is before created_at, clamped it to created_at"},
+		{"json rejected", readImportJSON, jsonInput, false, "task 2: " + ErrInvertedTimestamps.Error(), ""},
+		{"json clamped", readImportJSON, jsonInput, true, "", "task 2: updated_at is before created_at, clamped it to created_at"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, warnings, err := tt.read(strings.NewReader(tt.input), tt.clamp)
+			if tt.err != "" {
+				if !errors.Is(err, ErrInvertedTimestamps) || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(warnings, []string{tt.warning}) {
+				t.Errorf("got warnings %q, want %q", warnings, tt.warning)
+			}
+			if len(tasks) != 2 || !tasks[1].UpdatedAt.Equal(created) {
+				t.Fatalf("got %+v, want the second update time clamped to %v", tasks, created)
+			}
+			if !tasks[0].UpdatedAt.After(created) {
+				t.Errorf("the first task was clamped too: %v", tasks[0].UpdatedAt)
+			}
+		})
+	}
+}
+
+func TestJSONSeqFraming(t *testing.T) {
+	tasks := []Task{
+		{Id: 1, Description: "buy milk"},
+		{Id: 2, Description: "two\nlines"},
+		{Id: 3, Description: "a \x1e separator"},
+	}
+
+	var out bytes.Buffer
+	if err := writeTasksJSONSeq(&out, tasks, viewOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+
+	if bytes.Count(data, []byte{recordSeparator}) != len(tasks) {
+		t.Fatalf("got %d record separators, want %d:\n%q", bytes.Count(data, []byte{recordSeparator}), len(tasks), data)
+	}
+	if data[0] != recordSeparator || data[len(data)-1] != '\n' {
+		t.Errorf("got %q, want it framed by a record separator and a newline", data)
+	}
+
+	// every record is one compact JSON text between its separator and newline
+	for i, record := range bytes.Split(data[1:], []byte{recordSeparator}) {
+		text, ok := bytes.CutSuffix(record, []byte("\n"))
+		if !ok || bytes.Contains(text, []byte("\n")) {
+			t.Errorf("record %d is not one line ended by a newline: %q", i, record)
+		}
+		var view struct {
+			Description string `json:"description"`
+		}
+		if err := json.Unmarshal(text, &view); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if view.Description != tasks[i].Description {
+			t.Errorf("record %d: got %q, want %q", i, view.Description, tasks[i].Description)
+		}
+	}
+}
+
+func TestListHighlight(t *testing.T) {
+	ansi := regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+	list := func(t *testing.T, color string, args ...string) string {
+		t.Helper()
+
+		var out bytes.Buffer
+		state := &CommandState{
+			TaskStore: newTestStore(t, Task{Description: "buy Milk and milk"}, Task{Description: "walk dog"}),
+			Args:      append([]string{"--highlight", "milk"}, args...),
+			IO:        IO{In: strings.NewReader(""), Out: &out, Err: &out},
+			Config:    defaultConfig(),
+		}
+		state.Config.Color = color
+		if err := listCommand(state); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	for _, args := range [][]string{nil, {"--table"}} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			plain := list(t, "never", args...)
+			if strings.Contains(plain, "\x1b") {
+				t.Errorf("got escape sequences without color:\n%q", plain)
+			}
+
+			colored := list(t, "always", args...)
+			for _, match := range []string{"Milk", "milk"} {
+				if !strings.Contains(colored, highlightStart+match+highlightEnd) {
+					t.Errorf("got no highlighted %q in:\n%q", match, colored)
+				}
+			}
+			if strings.Contains(colored, highlightStart+"walk") {
+				t.Errorf("got a highlight outside the matches:\n%q", colored)
+			}
+
+			// the sequences take no room, the columns line up the same
+			if stripped := ansi.ReplaceAllString(colored, ""); stripped != plain {
+				t.Errorf("got without its colors:\n%s\nwant:\n%s", stripped, plain)
+			}
+		})
+	}
+}
+
+func TestExportSQL(t *testing.T) {
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	stamps := "'2024-03-01T09:30:00Z', '2024-03-01T09:30:00Z'"
+
+	tests := []struct {
+		name   string
+		task   Task
+		values string
+	}{
+		{
+			name:   "plain",
+			task:   Task{Id: 1, Description: "buy milk", Status: TaskStatusTodo},
+			values: "1, 'buy milk', 'todo', NULL, NULL, NULL, NULL, NULL, 0, 'medium', NULL, NULL, NULL, NULL, NULL, NULL, " + stamps,
+		},
+		{
+			name:   "quotes",
+			task:   Task{Id: 2, Description: "don't panic", Status: TaskStatusTodo, Project: "o'brien"},
+			values: "2, 'don''t panic', 'todo', NULL, NULL, NULL, NULL, NULL, 0, 'medium', 'o''brien', NULL, NULL, NULL, NULL, NULL, " + stamps,
+		},
+		{
+			name: "every column",
+			task: Task{
+				Id: 3, Description: "ship", Status: TaskStatusDone, Color: "red", ParentId: 1, DueAt: &created,
+				Tags: []string{"home", "work"}, Assignee: "sam", Pinned: true, Priority: TaskPriorityHigh,
+				Project: "launch", DependsOn: []TaskId{2, 1, 2}, Recurrence: "weekly",
+				RemindAt: &created, WaitUntil: &created, CompletedAt: &created,
+			},
+			values: "3, 'ship', 'done', 'red', 1, '2024-03-01T09:30:00Z', 'home,work', 'sam', 1, 'high', 'launch', '1,2', 'weekly', " +
+				"'2024-03-01T09:30:00Z', '2024-03-01T09:30:00Z', '2024-03-01T09:30:00Z', " + stamps,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.CreatedAt = created
+			tt.task.UpdatedAt = created
+			store := &TaskStore{Tasks: []Task{tt.task}}
+
+			var out bytes.Buffer
+			if err := store.ExportSQL(&out); err != nil {
+				t.Fatal(err)
+			}
+
+			script := out.String()
+			if !strings.HasPrefix(script, "BEGIN TRANSACTION;\n"+sqlTasksTable) || !strings.HasSuffix(script, "COMMIT;\n") {
+				t.Errorf("not a transaction creating the table:\n%s", script)
+			}
+			if want := ") VALUES (" + tt.values + ");\n"; !strings.Contains(script, want) {
+				t.Errorf("missing %q in:\n%s", want, script)
+			}
+		})
+	}
+}
+
+func TestCompactJSON(t *testing.T) {
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	minimal := Task{Id: 1, Description: "a", Status: TaskStatusTodo, CreatedAt: at, UpdatedAt: at}
+	populated := Task{
+		Id:          2,
+		Description: "b",
+		Status:      TaskStatusInProgress,
+		Color:       "red",
+		ParentId:    1,
+		DueAt:       &at,
+		Tags:        []string{"x"},
+		Assignee:    "ana",
+		Pinned:      true,
+		Priority:    TaskPriorityHigh,
+		Project:     "home",
+		DependsOn:   []TaskId{1},
+		Notes:       []Note{{Text: "n", CreatedAt: at}},
+		Recurrence:  "3d",
+		RemindAt:    &at,
+		Estimate:    time.Hour,
+		Custom:      map[string]any{"k": "v"},
+		Checklist:   []ChecklistItem{{Text: "step"}},
+		WaitUntil:   &at,
+		CreatedAt:   at,
+		UpdatedAt:   at,
+	}
+	required := []string{"id", "description", "status", "created_at", "updated_at"}
+	set := []string{"color", "parent_id", "due_at", "tags", "assignee", "pinned", "priority", "project",
+		"depends_on", "notes", "recurrence", "remind_at", "estimate", "custom", "checklist", "wait_until"}
+
+	keys := func(view map[string]any) []string {
+		return slices.Sorted(maps.Keys(view))
+	}
+
+	// the full output has every field, whatever is set
+	full := decodeTaskViews(t, []Task{minimal, populated}, viewOptions{})
+	if !slices.Equal(keys(full[0]), keys(full[1])) {
+		t.Errorf("got the full keys %v and %v, want the same", keys(full[0]), keys(full[1]))
+	}
+	for _, key := range append(slices.Clone(required), set...) {
+		if _, ok := full[0][key]; !ok {
+			t.Errorf("got no %s in the full output of a minimal task", key)
+		}
+	}
+	if full[0]["due_at"] != nil || full[0]["priority"] != "medium" {
+		t.Errorf("got due_at %v and priority %v, want null and medium", full[0]["due_at"], full[0]["priority"])
+	}
+
+	compact := decodeTaskViews(t, []Task{minimal, populated}, viewOptions{Compact: true})
+	if got := keys(compact[0]); !slices.Equal(got, slices.Sorted(slices.Values(required))) {
+		t.Errorf("got the compact keys %v for a minimal task, want %v", got, required)
+	}
+	for _, key := range append(slices.Clone(required), set...) {
+		if _, ok := compact[1][key]; !ok {
+			t.Errorf("got no %s in the compact output of a populated task", key)
+		}
+	}
+}
+
+func TestDoctorFixDuplicateDescriptions(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "task.json")
+	data := `{"meta":{"current_id":6},"tasks":[` +
+		`{"id":1,"description":"a","status":1},` +
+		`{"id":2,"description":"a","status":1},` +
+		`{"id":3,"description":"a (2)","status":1},` +
+		`{"id":4,"description":"a","status":3},` +
+		`{"id":5,"description":"b","status":1}]}`
+	if err := os.WriteFile(dbPath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenTaskStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runTestCommand(t, doctorCommand, store, "--fix")
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	want := "Renamed task 2 from \"a\" to \"a (3)\"\n" +
+		"Renamed task 4 from \"a\" to \"a (4)\"\n" +
+		"No issues found\n"
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	saved, err := OpenTaskStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var descriptions []string
+	for _, task := range saved.Tasks {
+		descriptions = append(descriptions, task.Description)
+	}
+	if want := []string{"a", "a (3)", "a (2)", "a (4)", "b"}; !slices.Equal(descriptions, want) {
+		t.Errorf("got %q, want %q", descriptions, want)
+	}
+
+	// the tasks can be updated again
+	for _, args := range [][]string{{"1", "c"}, {"2", "d"}, {"4", "a"}} {
+		if _, err = runTestCommand(t, updateCommand, saved, args...); err != nil {
+			t.Errorf("update %v: %v", args, err)
+		}
+	}
+
+	// a second run has nothing left to fix
+	if out, err = runTestCommand(t, doctorCommand, saved, "--fix"); err != nil || out != "No issues found\n" {
+		t.Errorf("got %q and error %v", out, err)
+	}
+}
+
+func TestLocaleTimeLayout(t *testing.T) {
+	at := time.Date(2024, 3, 1, 14, 30, 5, 0, time.UTC)
+
+	tests := []struct {
+		locale     string
+		timeFormat string
+		want       string
+	}{
+		{"en_US.UTF-8", time.DateTime, "03/01/2024 02:30:05 PM"},
+		{"en-GB", time.DateTime, "01/03/2024 14:30:05"},
+		{"de_AT", time.DateTime, "01.03.2024 14:30:05"},
+		{"", time.DateTime, "2024-03-01 14:30:05"},
+		// unknown locales fall back to the time format
+		{"xx_YY", time.DateTime, "2024-03-01 14:30:05"},
+		// an explicit time format wins over the locale
+		{"en_US", time.RFC3339, "2024-03-01T14:30:05Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale+" "+tt.timeFormat, func(t *testing.T) {
+			config := defaultConfig()
+			config.TimeFormat = tt.timeFormat
+			if err := config.Set("locale", tt.locale); err != nil {
+				t.Fatal(err)
+			}
+			if got := at.Format(config.TimeLayout()); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := localeTimeLayout("xx_YY"); ok {
+		t.Errorf("got a layout for an unknown locale")
+	}
+
+	// the table shows the timestamps of the locale
+	for locale, want := range map[string]string{"en_US": "03/01/2024 09:30:00 AM", "fr_FR": "01/03/2024 09:30:00"} {
+		config := defaultConfig()
+		config.Locale = locale
+		if out := renderTasks(t, "table", RenderOptions{TimeFormat: config.TimeLayout()}); !strings.Contains(out, want) {
+			t.Errorf("got under %s:\n%s\nwant %q", locale, out, want)
+		}
+	}
+}
+
+func TestStepPriority(t *testing.T) {
+	tests := []struct {
+		priority       TaskPriority
+		next, prev     TaskPriority
+		nextOk, prevOk bool
+	}{
+		{TaskPriorityLow, TaskPriorityMedium, TaskPriorityLow, true, false},
+		{TaskPriorityMedium, TaskPriorityHigh, TaskPriorityLow, true, true},
+		{TaskPriorityHigh, TaskPriorityUrgent, TaskPriorityMedium, true, true},
+		{TaskPriorityUrgent, TaskPriorityUrgent, TaskPriorityHigh, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.priority.String(), func(t *testing.T) {
+			if next, ok := nextPriority(tt.priority); next != tt.next || ok != tt.nextOk {
+				t.Errorf("nextPriority got %v, %v, want %v, %v", next, ok, tt.next, tt.nextOk)
+			}
+			if prev, ok := prevPriority(tt.priority); prev != tt.prev || ok != tt.prevOk {
+				t.Errorf("prevPriority got %v, %v, want %v, %v", prev, ok, tt.prev, tt.prevOk)
+			}
+		})
+	}
+}
+
+func TestBumpAndLower(t *testing.T) {
+	tests := []struct {
+		name      string
+		commandFn func(*CommandState) error
+		priority  TaskPriority
+		want      TaskPriority
+		out       string
+	}{
+		{"bump", bumpCommand, TaskPriorityMedium, TaskPriorityHigh, "Task priority changed to high\n"},
+		{"bump urgent", bumpCommand, TaskPriorityUrgent, TaskPriorityUrgent, "Task priority is already urgent\n"},
+		{"lower", lowerCommand, TaskPriorityMedium, TaskPriorityLow, "Task priority changed to low\n"},
+		{"lower low", lowerCommand, TaskPriorityLow, TaskPriorityLow, "Task priority is already low\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a", Priority: tt.priority})
+			before := store.Tasks[0].UpdatedAt
+			time.Sleep(time.Millisecond)
+
+			out, err := runTestCommand(t, tt.commandFn, store, "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.out {
+				t.Errorf("got %q, want %q", out, tt.out)
+			}
+
+			saved, err := OpenTaskStore(store.dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			task := saved.Tasks[0]
+			if task.Priority != tt.want {
+				t.Errorf("got priority %v, want %v", task.Priority, tt.want)
+			}
+			if bumped := task.UpdatedAt.After(before); bumped != (tt.priority != tt.want) {
+				t.Errorf("updated at bumped = %v, want %v", bumped, tt.priority != tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONSliceOrder(t *testing.T) {
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	tags := []string{"zeta", "alpha", "mid", "beta", "alpha"}
+	dependsOn := []TaskId{9, 3, 5, 3}
+	// notes and checklists keep the order they were added in, which is
+	// not the sorted one
+	notes := []Note{{Text: "second thought", CreatedAt: at}, {Text: "a first", CreatedAt: at.Add(time.Hour)}}
+	checklist := []ChecklistItem{{Text: "z"}, {Text: "a"}}
+
+	render := func(tags []string, dependsOn []TaskId) string {
+		task := Task{Id: 1, Description: "a", CreatedAt: at, UpdatedAt: at,
+			Tags: tags, DependsOn: dependsOn, Notes: notes, Checklist: checklist}
+		var out bytes.Buffer
+		if err := writeTasksJSON(&out, []Task{task}, viewOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	want := render(tags, dependsOn)
+	random := rand.New(rand.NewPCG(1, 2))
+	for range 20 {
+		shuffledTags, shuffledIds := slices.Clone(tags), slices.Clone(dependsOn)
+		random.Shuffle(len(shuffledTags), func(i, j int) {
+			shuffledTags[i], shuffledTags[j] = shuffledTags[j], shuffledTags[i]
+		})
+		random.Shuffle(len(shuffledIds), func(i, j int) {
+			shuffledIds[i], shuffledIds[j] = shuffledIds[j], shuffledIds[i]
+		})
+
+		if got := render(shuffledTags, shuffledIds); got != want {
+			t.Fatalf("got for %v %v:\n%s\nwant:\n%s", shuffledTags, shuffledIds, got, want)
+		}
+	}
+
+	var views []struct {
+		Tags      []string `json:"tags"`
+		DependsOn []TaskId `json:"depends_on"`
+		Notes     []struct {
+			Text string `json:"text"`
+		} `json:"notes"`
+		Checklist []struct {
+			Text string `json:"text"`
+		} `json:"checklist"`
+	}
+	if err := json.Unmarshal([]byte(want), &views); err != nil {
+		t.Fatal(err)
+	}
+	view := views[0]
+	if !slices.Equal(view.Tags, []string{"alpha", "beta", "mid", "zeta"}) {
+		t.Errorf("got tags %v, want them sorted and deduplicated", view.Tags)
+	}
+	if !slices.Equal(view.DependsOn, []TaskId{3, 5, 9}) {
+		t.Errorf("got depends_on %v, want them sorted and deduplicated", view.DependsOn)
+	}
+	if len(view.Notes) != 2 || view.Notes[0].Text != "second thought" || len(view.Checklist) != 2 || view.Checklist[0].Text != "z" {
+		t.Errorf("got notes %v and checklist %v, want them in insertion order", view.Notes, view.Checklist)
+	}
+}
+
+func TestAddInteractive(t *testing.T) {
+	due := time.Date(2024, 6, 30, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		session string
+		err     error
+		want    Task
+	}{
+		{
+			name: "fully specified",
+			// an empty description and invalid answers are asked again
+			session: "\nwrite report\nsoon\nhigh\n2024-13-01\n2024-06-30\nwork, urgent docs\n",
+			want:    Task{Description: "write report", Priority: TaskPriorityHigh, DueAt: &due, Tags: []string{"docs", "urgent", "work"}},
+		},
+		{
+			name:    "optional fields skipped",
+			session: "buy milk\n\n\n\n",
+			want:    Task{Description: "buy milk", Priority: TaskPriorityMedium},
+		},
+		{
+			name:    "input ended",
+			session: "buy milk\nlow",
+			want:    Task{Description: "buy milk", Priority: TaskPriorityLow},
+		},
+		{
+			name:    "no description",
+			session: "\n",
+			err:     ErrEmptyDescription,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			var out, prompts bytes.Buffer
+			state := &CommandState{
+				TaskStore: store,
+				IO:        IO{In: strings.NewReader(tt.session), Out: &out, Err: &prompts},
+				Config:    defaultConfig(),
+			}
+
+			if err := addInteractive(state, Task{}); !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				if len(store.Tasks) != 0 {
+					t.Errorf("got a task created: %+v", store.Tasks)
+				}
+				return
+			}
+
+			if out.String() != "Task added successfully: (ID: 1)\n" {
+				t.Errorf("got %q", out.String())
+			}
+			task := store.Tasks[0]
+			if task.Description != tt.want.Description || task.Priority != tt.want.Priority || task.Status != TaskStatusTodo ||
+				!slices.Equal(task.Tags, tt.want.Tags) || (task.DueAt == nil) != (tt.want.DueAt == nil) ||
+				(task.DueAt != nil && !task.DueAt.Equal(*tt.want.DueAt)) {
+				t.Errorf("got %+v\nwant %+v", task, tt.want)
+			}
+			for _, prompt := range []string{"Priority", "Due date", "Tags"} {
+				if !strings.Contains(prompts.String(), prompt) {
+					t.Errorf("got no %s prompt in:\n%s", prompt, prompts.String())
+				}
+			}
+		})
+	}
+
+	// without a terminal the description is required as usual
+	store := newTestStore(t)
+	if _, err := runTestCommandInput(t, addCommand, store, "buy milk\n", "-i"); !errors.Is(err, ErrOnlyOneArgumentAllowed) {
+		t.Errorf("got error %v, want %v", err, ErrOnlyOneArgumentAllowed)
+	}
+	if _, err := runTestCommandInput(t, addCommand, store, "high\n", "-i", "buy milk"); err != nil {
+		t.Fatal(err)
+	}
+	if task := store.Tasks[0]; task.Description != "buy milk" || task.Priority != TaskPriorityMedium {
+		t.Errorf("got %+v, want the task added without prompts", task)
+	}
+}
+
+func TestSortByPriority(t *testing.T) {
+	tasks := []Task{
+		{Id: 1, Priority: TaskPriorityLow},
+		{Id: 2, Priority: TaskPriorityHigh},
+		{Id: 3, Priority: TaskPriorityMedium},
+		{Id: 4, Priority: TaskPriorityUrgent},
+		{Id: 5, Priority: TaskPriorityHigh},
+		{Id: 6, Priority: TaskPriorityLow},
+		{Id: 7, Priority: TaskPriorityMedium},
+	}
+
+	tests := []struct {
+		key  string
+		want []TaskId
+	}{
+		// the most pressing first, ties keeping their order
+		{"priority", []TaskId{4, 2, 5, 3, 7, 1, 6}},
+		{"-priority", []TaskId{1, 6, 3, 7, 2, 5, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			sorted, err := sortTasks(tasks, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := taskIds(sorted); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportTemplate(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, Task{Description: "buy milk"}, Task{Description: "fix <b>", Status: TaskStatusDone})
+
+	tmpl := filepath.Join(dir, "report.tmpl")
+	report := "<p>next id {{.Meta.CurrentId}}</p>\n<ul>\n{{range .Tasks}}  <li>{{.Id}} {{.Description}} ({{.Status}})</li>\n{{end}}</ul>\n"
+	if err := os.WriteFile(tmpl, []byte(report), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		out  string
+		want string
+	}{
+		// HTML output escapes the task fields
+		{"report.html", "<p>next id 3</p>\n<ul>\n  <li>1 buy milk (todo)</li>\n  <li>2 fix &lt;b&gt; (done)</li>\n</ul>\n"},
+		{"report.md", "<p>next id 3</p>\n<ul>\n  <li>1 buy milk (todo)</li>\n  <li>2 fix <b> (done)</li>\n</ul>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			out := filepath.Join(dir, tt.out)
+			if _, err := runTestCommand(t, exportCommand, store, "--template", tmpl, out); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		// line is where the error message points to
+		line string
+	}{
+		{"parse", "<ul>\n{{range .Tasks}}\n<li>{{.Id}</li>\n{{end}}</ul>\n", "report.tmpl:3"},
+		{"exec", "<ul>\n{{range .Tasks}}\n<li>{{.Missing}}</li>\n{{end}}</ul>\n", "report.tmpl:3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tmpl, out := filepath.Join(dir, "report.tmpl"), filepath.Join(dir, "report.html")
+			if err := os.WriteFile(tmpl, []byte(tt.template), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			store := newTestStore(t, Task{Description: "buy milk"})
+			_, err := runTestCommand(t, exportCommand, store, "--template", tmpl, out)
+			if err == nil || !strings.Contains(err.Error(), tt.line) {
+				t.Fatalf("got error %v, want it to point to %s", err, tt.line)
+			}
+			if _, err = os.Stat(out); !os.IsNotExist(err) {
+				t.Errorf("got an output file left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestUIKeyAction(t *testing.T) {
+	tests := []struct {
+		key  string
+		want uiAction
+	}{
+		{"\x1b[A", uiUp},
+		{"\x1bOA", uiUp},
+		{"k", uiUp},
+		{"\x1b[B", uiDown},
+		{"\x1bOB", uiDown},
+		{"j", uiDown},
+		{" ", uiCycleStatus},
+		{"d", uiDelete},
+		{"/", uiFilter},
+		{"q", uiQuit},
+		{"\x03", uiQuit},
+		{"\x04", uiQuit},
+		{"x", uiNone},
+		{"\x1b[C", uiNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q", tt.key), func(t *testing.T) {
+			if got := uiKeyAction(tt.key); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUIHandleKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   []string
+		cursor int
+		mode   uiMode
+		shown  []TaskId
+		effect *uiEffect
+		quit   bool
+	}{
+		{"down", []string{"j"}, 1, uiModeList, []TaskId{1, 2, 3}, nil, false},
+		{"stops at the last task", []string{"j", "j", "j", "j"}, 2, uiModeList, []TaskId{1, 2, 3}, nil, false},
+		{"stops at the first task", []string{"j", "k", "k"}, 0, uiModeList, []TaskId{1, 2, 3}, nil, false},
+		{"cycle status", []string{"j", " "}, 1, uiModeList, []TaskId{1, 2, 3},
+			&uiEffect{uiCycleStatus, Task{Id: 2, Status: TaskStatusDone}}, false},
+		{"cycle done back to todo", []string{"j", "j", " "}, 2, uiModeList, []TaskId{1, 2, 3},
+			&uiEffect{uiCycleStatus, Task{Id: 3, Status: TaskStatusTodo}}, false},
+		{"ask before deleting", []string{"d"}, 0, uiModeConfirmDelete, []TaskId{1, 2, 3}, nil, false},
+		{"delete confirmed", []string{"j", "d", "y"}, 1, uiModeList, []TaskId{1, 2, 3},
+			&uiEffect{uiDelete, Task{Id: 2, Status: TaskStatusInProgress}}, false},
+		{"delete refused", []string{"d", "n"}, 0, uiModeList, []TaskId{1, 2, 3}, nil, false},
+		{"filter", []string{"/", "M", "i"}, 0, uiModeFilter, []TaskId{1, 3}, nil, false},
+		{"filter applied", []string{"/", "m", "i", "l", "k", "\r"}, 0, uiModeList, []TaskId{1, 3}, nil, false},
+		{"filter edited", []string{"/", "m", "i", "x", "\x7f"}, 0, uiModeFilter, []TaskId{1, 3}, nil, false},
+		{"filter cancelled", []string{"/", "b", "\x1b"}, 0, uiModeList, []TaskId{1, 2, 3}, nil, false},
+		{"filter ignores control keys", []string{"/", "\x1b[A", "q"}, 0, uiModeFilter, nil, nil, false},
+		{"cursor kept within the filter", []string{"j", "j", "/", "b", "l", "o", "g"}, 0, uiModeFilter, []TaskId{2}, nil, false},
+		{"quit", []string{"q"}, 0, uiModeList, []TaskId{1, 2, 3}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui := newUIState([]Task{
+				{Id: 1, Description: "buy milk", Status: TaskStatusTodo},
+				{Id: 2, Description: "write blog post", Status: TaskStatusInProgress},
+				{Id: 3, Description: "Milk the cow", Status: TaskStatusDone},
+			})
+
+			var effect *uiEffect
+			for _, key := range tt.keys {
+				effect = ui.handleKey(key)
+			}
+
+			if ui.cursor != tt.cursor || ui.mode != tt.mode || ui.quit != tt.quit {
+				t.Errorf("got cursor %d, mode %v, quit %v, want %d, %v, %v", ui.cursor, ui.mode, ui.quit, tt.cursor, tt.mode, tt.quit)
+			}
+			if got := taskIds(ui.shown); !slices.Equal(got, tt.shown) {
+				t.Errorf("shows %v, want %v", got, tt.shown)
+			}
+			switch {
+			case (effect == nil) != (tt.effect == nil):
+				t.Errorf("got effect %v, want %v", effect, tt.effect)
+			case effect != nil && (effect.action != tt.effect.action || effect.task.Id != tt.effect.task.Id || effect.task.Status != tt.effect.task.Status):
+				t.Errorf("got effect %v on task %d (%v), want %v on task %d (%v)", effect.action, effect.task.Id, effect.task.Status,
+					tt.effect.action, tt.effect.task.Id, tt.effect.task.Status)
+			}
+		})
+	}
+}
+
+func TestAddDependency(t *testing.T) {
+	tests := []struct {
+		name    string
+		id, on  TaskId
+		wantErr error
+		want    []TaskId
+	}{
+		{"new", 4, 3, nil, []TaskId{3}},
+		{"already there", 3, 2, nil, []TaskId{2}},
+		{"self", 1, 1, ErrDependencyCycle, nil},
+		{"direct cycle", 1, 2, ErrDependencyCycle, nil},
+		{"indirect cycle", 1, 3, ErrDependencyCycle, nil},
+		{"unknown task", 9, 1, ErrTaskDoesNotExist, nil},
+		{"unknown dependency", 4, 9, ErrTaskDoesNotExist, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// c waits for b, which waits for a
+			store := newTestStore(t,
+				Task{Description: "a"},
+				Task{Description: "b", DependsOn: []TaskId{1}},
+				Task{Description: "c", DependsOn: []TaskId{2}},
+				Task{Description: "d"},
+			)
+
+			err := store.AddDependency(tt.id, tt.on)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			task, err := store.GetById(tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(task.DependsOn, tt.want) {
+				t.Errorf("got %v, want %v", task.DependsOn, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadyAndBlocked(t *testing.T) {
+	store := &TaskStore{Tasks: []Task{
+		{Id: 1, Description: "a", Status: TaskStatusDone},
+		{Id: 2, Description: "b", Status: TaskStatusTodo, DependsOn: []TaskId{1}},
+		{Id: 3, Description: "c", Status: TaskStatusInProgress, DependsOn: []TaskId{1, 2}},
+		// 9 was deleted, it is no longer waited for
+		{Id: 4, Description: "d", Status: TaskStatusTodo, DependsOn: []TaskId{9}},
+		{Id: 5, Description: "e", Status: TaskStatusDone, DependsOn: []TaskId{2}},
+		{Id: 6, Description: "f", Status: TaskStatusTodo},
+	}}
+
+	tests := []struct {
+		expr string
+		want []TaskId
+	}{
+		{"ready", []TaskId{2, 4, 6}},
+		{"blocked", []TaskId{3}},
+		{"not ready and not done", []TaskId{3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			predicate, err := parseWhere(tt.expr, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []TaskId
+			for _, task := range store.Tasks {
+				if predicate(task) {
+					got = append(got, task.Id)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRecurrence(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Recurrence
+		wantErr bool
+	}{
+		{"3d", Recurrence{3, 'd'}, false},
+		{"2w", Recurrence{2, 'w'}, false},
+		{"1m", Recurrence{1, 'm'}, false},
+		{"10y", Recurrence{10, 'y'}, false},
+		{"FREQ=WEEKLY;INTERVAL=2", Recurrence{2, 'w'}, false},
+		{"RRULE:FREQ=MONTHLY", Recurrence{1, 'm'}, false},
+		{"freq=daily;interval=3", Recurrence{3, 'd'}, false},
+		{" FREQ=YEARLY ", Recurrence{1, 'y'}, false},
+		{"", Recurrence{}, true},
+		{"d", Recurrence{}, true},
+		{"0d", Recurrence{}, true},
+		{"-1w", Recurrence{}, true},
+		{"3x", Recurrence{}, true},
+		{"weekly", Recurrence{}, true},
+		{"FREQ=HOURLY", Recurrence{}, true},
+		{"INTERVAL=2", Recurrence{}, true},
+		{"FREQ=DAILY;INTERVAL=x", Recurrence{}, true},
+		{"FREQ=DAILY;INTERVAL=0", Recurrence{}, true},
+		{"FREQ=WEEKLY;BYDAY=MO", Recurrence{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseRecurrence(tt.value)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRecurrence) {
+					t.Errorf("got error %v, want %v", err, ErrInvalidRecurrence)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name       string
+		recurrence Recurrence
+		from       time.Time
+		want       time.Time
+	}{
+		{"days", Recurrence{3, 'd'}, date(2024, 1, 30), date(2024, 2, 2)},
+		{"weeks", Recurrence{2, 'w'}, date(2024, 12, 25), date(2025, 1, 8)},
+		{"months", Recurrence{2, 'm'}, date(2024, 1, 15), date(2024, 3, 15)},
+		{"month end leap year", Recurrence{1, 'm'}, date(2024, 1, 31), date(2024, 2, 29)},
+		{"month end", Recurrence{1, 'm'}, date(2023, 1, 31), date(2023, 2, 28)},
+		{"month end thirty days", Recurrence{1, 'm'}, date(2024, 3, 31), date(2024, 4, 30)},
+		{"month end next year", Recurrence{3, 'm'}, date(2024, 11, 30), date(2025, 2, 28)},
+		{"year end", Recurrence{1, 'm'}, date(2024, 12, 31), date(2025, 1, 31)},
+		{"years", Recurrence{1, 'y'}, date(2024, 6, 15), date(2025, 6, 15)},
+		{"leap day", Recurrence{1, 'y'}, date(2024, 2, 29), date(2025, 2, 28)},
+		{"leap day to leap year", Recurrence{4, 'y'}, date(2024, 2, 29), date(2028, 2, 29)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.recurrence.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	now := time.Date(2024, 6, 15, 10, 0, 0, 0, time.Local)
+	date := func(year int, month time.Month, day int) *time.Time {
+		t := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+		return &t
+	}
+
+	tests := []struct {
+		name       string
+		recurrence string
+		dueAt      *time.Time
+		want       *time.Time
+	}{
+		{"no due date", "3d", nil, date(2024, 6, 18)},
+		{"due date", "1w", date(2024, 6, 14), date(2024, 6, 21)},
+		{"missed occurrences", "1w", date(2024, 5, 1), date(2024, 6, 19)},
+		{"due today", "1d", date(2024, 6, 14), date(2024, 6, 15)},
+		{"month end", "1m", date(2024, 5, 31), date(2024, 6, 30)},
+		{"rrule", "FREQ=MONTHLY;INTERVAL=2", date(2024, 6, 1), date(2024, 8, 1)},
+		{"unparsable", "sometimes", date(2024, 6, 14), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{
+				Description: "water the plants",
+				Tags:        []string{"home"},
+				Checklist:   []ChecklistItem{{Text: "ferns", Done: true}},
+			})
+			task := store.Tasks[0]
+			task.Status = TaskStatusDone
+			task.Recurrence = tt.recurrence
+			task.DueAt = tt.dueAt
+			task.UpdatedAt = now
+
+			store.scheduleNext(&task)
+
+			if tt.want == nil {
+				if len(store.Tasks) != 1 || task.Description != "water the plants" || task.Recurrence != tt.recurrence {
+					t.Errorf("scheduled %+v from %+v", store.Tasks[1:], task)
+				}
+				return
+			}
+
+			if len(store.Tasks) != 2 || store.Meta.CurrentId != 3 {
+				t.Fatalf("got %d tasks and the next id %d, want 2 and 3", len(store.Tasks), store.Meta.CurrentId)
+			}
+			if task.Description != "water the plants (done 2024-06-15)" || task.Recurrence != "" {
+				t.Errorf("the done task is %q recurring %q", task.Description, task.Recurrence)
+			}
+			next := store.Tasks[1]
+			if next.Id != 2 || next.Description != "water the plants" || next.Status != TaskStatusTodo || next.Recurrence != tt.recurrence {
+				t.Errorf("got the next occurrence %+v", next)
+			}
+			if next.DueAt == nil || !next.DueAt.Equal(*tt.want) {
+				t.Errorf("the next occurrence is due %v, want %v", next.DueAt, tt.want)
+			}
+			if !slices.Equal(next.Tags, []string{"home"}) || len(next.Checklist) != 1 || next.Checklist[0].Done {
+				t.Errorf("the next occurrence has the tags %v and the checklist %+v", next.Tags, next.Checklist)
+			}
+		})
+	}
+}
+
+func TestTimeSpent(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 6, 15, hour, minute, 0, 0, time.UTC)
+	}
+	ended := func(hour, minute int) *time.Time {
+		end := at(hour, minute)
+		return &end
+	}
+	now := at(18, 0)
+	sessions := []Session{
+		{Start: at(9, 0), End: ended(10, 30)},
+		{Start: at(14, 0), End: ended(15, 0)},
+		{Start: at(17, 0)},
+	}
+
+	tests := []struct {
+		name     string
+		sessions []Session
+		from, to time.Time
+		want     time.Duration
+	}{
+		{"no session", nil, time.Time{}, time.Time{}, 0},
+		{"all", sessions, time.Time{}, time.Time{}, 3*time.Hour + 30*time.Minute},
+		{"closed", sessions[:2], time.Time{}, time.Time{}, 2*time.Hour + 30*time.Minute},
+		{"tracking", sessions[2:], time.Time{}, time.Time{}, time.Hour},
+		{"from", sessions, at(10, 0), time.Time{}, 2*time.Hour + 30*time.Minute},
+		{"to", sessions, time.Time{}, at(14, 30), 2 * time.Hour},
+		{"from and to", sessions, at(10, 0), at(17, 30), 2 * time.Hour},
+		{"between sessions", sessions, at(11, 0), at(13, 0), 0},
+		{"after now", sessions, at(19, 0), time.Time{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := Task{Sessions: tt.sessions}
+			if got := task.TimeSpent(tt.from, tt.to, now); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatSpent(t *testing.T) {
+	tests := []struct {
+		spent time.Duration
+		want  string
+	}{
+		{0, "0m"},
+		{59 * time.Second, "0m"},
+		{45 * time.Minute, "45m"},
+		{time.Hour + 5*time.Minute, "1h05m"},
+		{26*time.Hour + 30*time.Minute, "26h30m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatSpent(tt.spent); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		wantErr  error
+		sessions int
+		tracking bool
+	}{
+		{"start", []string{"start"}, nil, 1, true},
+		{"start and stop", []string{"start", "stop"}, nil, 1, false},
+		{"two sessions", []string{"start", "stop", "start"}, nil, 2, true},
+		{"start twice", []string{"start", "start"}, ErrAlreadyTracking, 1, true},
+		{"stop without start", []string{"stop"}, ErrNotTracking, 0, false},
+		{"stop twice", []string{"start", "stop", "stop"}, ErrNotTracking, 1, false},
+	}
+
+	commandsFn := map[string]func(*CommandState) error{"start": startCommand, "stop": stopCommand}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a"})
+
+			var err error
+			for _, command := range tt.commands {
+				if _, err = runTestCommand(t, commandsFn[command], store, "1"); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			task := store.Tasks[0]
+			if len(task.Sessions) != tt.sessions || task.Tracking() != tt.tracking {
+				t.Errorf("got the sessions %+v, want %d tracking %v", task.Sessions, tt.sessions, tt.tracking)
+			}
+		})
+	}
+
+	store := newTestStore(t)
+	if _, err := runTestCommand(t, startCommand, store, "1"); !errors.Is(err, ErrTaskDoesNotExist) {
+		t.Errorf("got error %v, want %v", err, ErrTaskDoesNotExist)
+	}
+}
+
+func TestAttachAndOpen(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "spec.txt")
+	if err := os.WriteFile(file, []byte("the spec"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const url = "https://example.com/spec"
+	sum := sha256.Sum256([]byte("the spec"))
+	blob := hex.EncodeToString(sum[:]) + ".txt"
+
+	tests := []struct {
+		name      string
+		attach    []string
+		attachErr error
+		want      []Attachment
+		openErr   error
+	}{
+		{"file", []string{file}, nil, []Attachment{{Name: "spec.txt", Blob: blob, Size: 8}}, nil},
+		{"url", []string{url}, nil, []Attachment{{Name: url, URL: url}}, nil},
+		{"url then file", []string{url, file}, nil, []Attachment{{Name: url, URL: url}, {Name: "spec.txt", Blob: blob, Size: 8}}, nil},
+		{"directory", []string{dir}, ErrNotRegularFile, nil, ErrNoAttachments},
+		{"missing file", []string{filepath.Join(dir, "missing.txt")}, fs.ErrNotExist, nil, ErrNoAttachments},
+		{"none", nil, nil, nil, ErrNoAttachments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, Task{Description: "a"})
+
+			var err error
+			for _, target := range tt.attach {
+				if _, err = runTestCommand(t, attachCommand, store, "1", target); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.attachErr) || (tt.attachErr == nil && err != nil) {
+				t.Fatalf("got error %v, want %v", err, tt.attachErr)
+			}
+
+			attachments := store.Tasks[0].Attachments
+			if len(attachments) != len(tt.want) {
+				t.Fatalf("got the attachments %+v, want %+v", attachments, tt.want)
+			}
+			for i, attachment := range attachments {
+				attachment.AddedAt = time.Time{}
+				if attachment != tt.want[i] {
+					t.Errorf("got the attachment %+v, want %+v", attachment, tt.want[i])
+				}
+			}
+
+			out, err := runTestCommand(t, openCommand, store, "--print", "1")
+			if !errors.Is(err, tt.openErr) || (tt.openErr == nil && err != nil) {
+				t.Fatalf("got error %v, want %v", err, tt.openErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// the first attachment is opened, a file from the blob directory
+			// next to the store
+			want := tt.want[0].URL
+			if want == "" {
+				want = filepath.Join(filepath.Dir(store.dbPath), "task.blobs", blob)
+				if data, err := os.ReadFile(want); err != nil || string(data) != "the spec" {
+					t.Errorf("got the blob %q, %v", data, err)
+				}
+			}
+			if strings.TrimSpace(out) != want {
+				t.Errorf("opened %q, want %q", strings.TrimSpace(out), want)
+			}
+		})
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr error
+		want    []ChecklistItem
+		out     string
+	}{
+		{"add", []string{"add", "1", "label"}, nil, []ChecklistItem{{"pack", false}, {"ship", true}, {"label", false}}, "[1/3]"},
+		{"add trimmed", []string{"add", "1", "  label "}, nil, []ChecklistItem{{"pack", false}, {"ship", true}, {"label", false}}, "[1/3]"},
+		{"done", []string{"done", "1", "1"}, nil, []ChecklistItem{{"pack", true}, {"ship", true}}, "[2/2]"},
+		{"undo", []string{"undo", "1", "2"}, nil, []ChecklistItem{{"pack", false}, {"ship", false}}, "[0/2]"},
+		{"done twice", []string{"done", "1", "2"}, nil, []ChecklistItem{{"pack", false}, {"ship", true}}, "No changes"},
+		{"undo not done", []string{"undo", "1", "1"}, nil, []ChecklistItem{{"pack", false}, {"ship", true}}, "No changes"},
+		{"empty item", []string{"add", "1", " "}, ErrEmptyDescription, nil, ""},
+		{"item zero", []string{"done", "1", "0"}, ErrInvalidCheckItem, nil, ""},
+		{"item past the end", []string{"done", "1", "3"}, ErrInvalidCheckItem, nil, ""},
+		{"item not a number", []string{"undo", "1", "ship"}, ErrInvalidCheckItem, nil, ""},
+		{"unknown action", []string{"tick", "1", "1"}, ErrUnknownCheckAction, nil, ""},
+		{"no action", nil, ErrUnknownCheckAction, nil, ""},
+		{"missing item", []string{"add", "1"}, ErrMissingCheckItem, nil, ""},
+		{"unknown task", []string{"add", "9", "label"}, ErrTaskDoesNotExist, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checklist := []ChecklistItem{{"pack", false}, {"ship", true}}
+			store := newTestStore(t, Task{Description: "a", Checklist: checklist})
+
+			out, err := runTestCommand(t, checkCommand, store, tt.args...)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			want := tt.want
+			if err != nil {
+				want = checklist
+			}
+			if got := store.Tasks[0].Checklist; !slices.Equal(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+			if !strings.Contains(out, tt.out) {
+				t.Errorf("got %q, want it to contain %q", out, tt.out)
+			}
+		})
+	}
+}